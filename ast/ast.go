@@ -11,6 +11,7 @@ import (
 type Node interface {
 	TokenLiteral() string // 返回节点的token值
 	String() string       // 返回节点的字符串
+	Pos() token.Position  // 返回节点在源代码中的起始位置
 }
 
 // Statement 定义语句节点类型
@@ -25,6 +26,40 @@ type Expression interface {
 	expressionNode() // 表达式节点为表达式
 }
 
+// Comments 语句之前紧邻的注释，嵌入在可以带注释的语句节点中
+type Comments struct {
+	LeadingComments []string // 每行注释去掉 // 和首尾空白后的文本
+}
+
+// leadingComments 返回嵌入的注释，供 Doc 和解析器访问
+func (c *Comments) leadingComments() *Comments {
+	return c
+}
+
+// commented 可以带前置注释的节点
+type commented interface {
+	leadingComments() *Comments
+}
+
+// Doc 返回节点的文档注释，即紧邻语句之前的各行注释以换行连接，
+// 例如 let 绑定函数之前的注释；节点不带注释时返回空字符串
+func Doc(node Node) string {
+	c, ok := node.(commented)
+	if !ok {
+		return ""
+	}
+	return strings.Join(c.leadingComments().LeadingComments, "\n")
+}
+
+// AttachComments 将注释附加到语句节点，节点不支持注释时返回 false
+func AttachComments(node Node, comments []string) bool {
+	c, ok := node.(commented)
+	if ok {
+		c.leadingComments().LeadingComments = comments
+	}
+	return ok
+}
+
 // Program 定义程序节点
 type Program struct {
 	Statements []Statement // 程序节点中的语句
@@ -42,6 +77,14 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+// Pos 返回程序节点的起始位置
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 // String 返回程序节点的字符串
 func (p *Program) String() string {
 	var out bytes.Buffer
@@ -68,6 +111,11 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+// Pos 返回标识符节点的起始位置
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos
+}
+
 // String 返回标识符的字符串
 func (i *Identifier) String() string {
 	return i.Value
@@ -78,6 +126,8 @@ type LetStatement struct {
 	Token token.Token // let关键字token
 	Name  *Identifier // let语句的标识符
 	Value Expression  // let语句的值表达式
+
+	Comments // 语句之前紧邻的注释
 }
 
 // 定义let语句节点为语句
@@ -91,6 +141,11 @@ func (l *LetStatement) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+// Pos 返回let语句的起始位置
+func (l *LetStatement) Pos() token.Position {
+	return l.Token.Pos
+}
+
 // String 返回let语句的字符串
 func (l *LetStatement) String() string {
 	var out bytes.Buffer
@@ -108,6 +163,8 @@ func (l *LetStatement) String() string {
 type ReturnStatement struct {
 	Token       token.Token // return关键字token
 	ReturnValue Expression  // 返回值表达式
+
+	Comments // 语句之前紧邻的注释
 }
 
 // 定义return语句节点为语句
@@ -122,6 +179,11 @@ func (r ReturnStatement) TokenLiteral() string {
 	return r.Token.Literal
 }
 
+// Pos 返回return语句的起始位置
+func (r ReturnStatement) Pos() token.Position {
+	return r.Token.Pos
+}
+
 // String 返回return语句的字符串
 func (r ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -137,6 +199,8 @@ func (r ReturnStatement) String() string {
 type ExpressionStatement struct {
 	Token      token.Token // 表达式token
 	Expression Expression  // 表达式
+
+	Comments // 语句之前紧邻的注释
 }
 
 // 定义表达式语句节点为语句
@@ -150,6 +214,11 @@ func (e *ExpressionStatement) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+// Pos 返回表达式语句的起始位置
+func (e *ExpressionStatement) Pos() token.Position {
+	return e.Token.Pos
+}
+
 // String 返回表达式语句的字符串
 func (e *ExpressionStatement) String() string {
 	if e.Expression != nil {
@@ -175,11 +244,43 @@ func (i *IntegerLiteral) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+// Pos 返回整数节点的起始位置
+func (i *IntegerLiteral) Pos() token.Position {
+	return i.Token.Pos
+}
+
 // String 返回整数节点的字符串
 func (i *IntegerLiteral) String() string {
 	return i.Token.Literal
 }
 
+// FloatLiteral 定义浮点数节点
+type FloatLiteral struct {
+	Token token.Token // 浮点数token
+	Value float64     // 浮点数值
+}
+
+// 定义浮点数节点为表达式
+var _ Expression = (*FloatLiteral)(nil)
+
+// expressionNode 标识浮点数节点为表达式
+func (f *FloatLiteral) expressionNode() {}
+
+// TokenLiteral 返回浮点数节点的token值
+func (f *FloatLiteral) TokenLiteral() string {
+	return f.Token.Literal
+}
+
+// Pos 返回浮点数节点的起始位置
+func (f *FloatLiteral) Pos() token.Position {
+	return f.Token.Pos
+}
+
+// String 返回浮点数节点的字符串
+func (f *FloatLiteral) String() string {
+	return f.Token.Literal
+}
+
 // PrefixExpression 定义前缀表达式节点
 type PrefixExpression struct {
 	Token    token.Token // 前缀表达式token
@@ -198,6 +299,11 @@ func (p *PrefixExpression) TokenLiteral() string {
 	return p.Token.Literal
 }
 
+// Pos 返回前缀表达式的起始位置
+func (p *PrefixExpression) Pos() token.Position {
+	return p.Token.Pos
+}
+
 // String 返回前缀表达式的字符串
 func (p *PrefixExpression) String() string {
 	var out bytes.Buffer
@@ -227,6 +333,11 @@ func (i *InfixExpression) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+// Pos 返回中缀表达式的起始位置
+func (i *InfixExpression) Pos() token.Position {
+	return i.Left.Pos()
+}
+
 // String 返回中缀表达式的字符串
 func (i *InfixExpression) String() string {
 	var out bytes.Buffer
@@ -255,6 +366,11 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+// Pos 返回布尔节点的起始位置
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
+
 // String 返回布尔节点的字符串
 func (b *Boolean) String() string {
 	return b.Token.Literal
@@ -277,6 +393,11 @@ func (b *BlockStatement) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+// Pos 返回块语句的起始位置
+func (b *BlockStatement) Pos() token.Position {
+	return b.Token.Pos
+}
+
 // String 返回块语句节点的字符串
 func (b *BlockStatement) String() string {
 	var out bytes.Buffer
@@ -305,6 +426,11 @@ func (i *IfExpression) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+// Pos 返回if表达式的起始位置
+func (i *IfExpression) Pos() token.Position {
+	return i.Token.Pos
+}
+
 // String 返回if表达式的字符串
 func (i *IfExpression) String() string {
 	var out bytes.Buffer
@@ -319,6 +445,157 @@ func (i *IfExpression) String() string {
 	return out.String()
 }
 
+// WhileStatement 定义while语句节点
+type WhileStatement struct {
+	Token     token.Token     // while token
+	Condition Expression      // 循环条件
+	Body      *BlockStatement // 循环体
+
+	Comments // 语句之前紧邻的注释
+}
+
+// 定义while语句节点为语句
+var _ Statement = (*WhileStatement)(nil)
+
+// statementNode 标识while语句节点为语句
+func (w *WhileStatement) statementNode() {}
+
+// TokenLiteral 返回while语句的token值
+func (w *WhileStatement) TokenLiteral() string {
+	return w.Token.Literal
+}
+
+// Pos 返回while语句的起始位置
+func (w *WhileStatement) Pos() token.Position {
+	return w.Token.Pos
+}
+
+// String 返回while语句的字符串
+func (w *WhileStatement) String() string {
+	return "while" + w.Condition.String() + " " + w.Body.String()
+}
+
+// ForStatement 定义for语句节点
+type ForStatement struct {
+	Token     token.Token     // for token
+	Init      Statement       // 初始化语句
+	Condition Expression      // 循环条件
+	Post      Statement       // 每次循环体执行后的语句
+	Body      *BlockStatement // 循环体
+
+	Comments // 语句之前紧邻的注释
+}
+
+// 定义for语句节点为语句
+var _ Statement = (*ForStatement)(nil)
+
+// statementNode 标识for语句节点为语句
+func (f *ForStatement) statementNode() {}
+
+// TokenLiteral 返回for语句的token值
+func (f *ForStatement) TokenLiteral() string {
+	return f.Token.Literal
+}
+
+// Pos 返回for语句的起始位置
+func (f *ForStatement) Pos() token.Position {
+	return f.Token.Pos
+}
+
+// String 返回for语句的字符串
+func (f *ForStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for(")
+	out.WriteString(strings.TrimSuffix(f.Init.String(), ";"))
+	out.WriteString("; ")
+	out.WriteString(f.Condition.String())
+	out.WriteString("; ")
+	out.WriteString(strings.TrimSuffix(f.Post.String(), ";"))
+	out.WriteString(") ")
+	out.WriteString(f.Body.String())
+	return out.String()
+}
+
+// LoopExpression 定义出现在表达式位置的循环，如 let x = while (c) { ... }，
+// Loop 为 *WhileStatement 或 *ForStatement，循环的值总是 null
+type LoopExpression struct {
+	Token token.Token // while 或 for token
+	Loop  Statement   // 循环语句
+}
+
+// 定义循环表达式节点为表达式
+var _ Expression = (*LoopExpression)(nil)
+
+// expressionNode 标识循环表达式节点为表达式
+func (l *LoopExpression) expressionNode() {}
+
+// TokenLiteral 返回循环表达式的token值
+func (l *LoopExpression) TokenLiteral() string {
+	return l.Token.Literal
+}
+
+// Pos 返回循环表达式的起始位置
+func (l *LoopExpression) Pos() token.Position {
+	return l.Token.Pos
+}
+
+// String 返回循环表达式的字符串
+func (l *LoopExpression) String() string {
+	return l.Loop.String()
+}
+
+// BreakStatement 定义break语句节点
+type BreakStatement struct {
+	Token token.Token // break token
+}
+
+// 定义break语句节点为语句
+var _ Statement = (*BreakStatement)(nil)
+
+// statementNode 标识break语句节点为语句
+func (b *BreakStatement) statementNode() {}
+
+// TokenLiteral 返回break语句的token值
+func (b *BreakStatement) TokenLiteral() string {
+	return b.Token.Literal
+}
+
+// Pos 返回break语句的起始位置
+func (b *BreakStatement) Pos() token.Position {
+	return b.Token.Pos
+}
+
+// String 返回break语句的字符串
+func (b *BreakStatement) String() string {
+	return b.TokenLiteral() + ";"
+}
+
+// ContinueStatement 定义continue语句节点
+type ContinueStatement struct {
+	Token token.Token // continue token
+}
+
+// 定义continue语句节点为语句
+var _ Statement = (*ContinueStatement)(nil)
+
+// statementNode 标识continue语句节点为语句
+func (c *ContinueStatement) statementNode() {}
+
+// TokenLiteral 返回continue语句的token值
+func (c *ContinueStatement) TokenLiteral() string {
+	return c.Token.Literal
+}
+
+// Pos 返回continue语句的起始位置
+func (c *ContinueStatement) Pos() token.Position {
+	return c.Token.Pos
+}
+
+// String 返回continue语句的字符串
+func (c *ContinueStatement) String() string {
+	return c.TokenLiteral() + ";"
+}
+
 // FunctionLiteral 定义函数节点
 type FunctionLiteral struct {
 	Token      token.Token     // 函数token
@@ -338,6 +615,11 @@ func (f *FunctionLiteral) TokenLiteral() string {
 	return f.Token.Literal
 }
 
+// Pos 返回函数字面量的起始位置
+func (f *FunctionLiteral) Pos() token.Position {
+	return f.Token.Pos
+}
+
 // String 返回函数的字符串
 func (f *FunctionLiteral) String() string {
 	var out bytes.Buffer
@@ -374,6 +656,11 @@ func (c *CallExpression) TokenLiteral() string {
 	return c.Token.Literal
 }
 
+// Pos 返回调用表达式的起始位置
+func (c *CallExpression) Pos() token.Position {
+	return c.Function.Pos()
+}
+
 // String 返回函数的字符串
 func (c *CallExpression) String() string {
 	var out bytes.Buffer
@@ -405,6 +692,11 @@ func (s *StringLiteral) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+// Pos 返回字符串节点的起始位置
+func (s *StringLiteral) Pos() token.Position {
+	return s.Token.Pos
+}
+
 // String 返回字符串节点的字符串
 func (s *StringLiteral) String() string {
 	return s.Token.Literal
@@ -427,6 +719,11 @@ func (a *ArrayLiteral) TokenLiteral() string {
 	return a.Token.Literal
 }
 
+// Pos 返回数组字面量的起始位置
+func (a *ArrayLiteral) Pos() token.Position {
+	return a.Token.Pos
+}
+
 // String 返回数组节点的字符串
 func (a *ArrayLiteral) String() string {
 	var out bytes.Buffer
@@ -459,6 +756,11 @@ func (i *IndexExpression) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+// Pos 返回索引表达式的起始位置
+func (i *IndexExpression) Pos() token.Position {
+	return i.Left.Pos()
+}
+
 // String 返回数组索引节点的字符串
 func (i *IndexExpression) String() string {
 	var out bytes.Buffer
@@ -471,6 +773,110 @@ func (i *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression 定义切片节点，如 arr[1:3]，省略的边界为 nil
+type SliceExpression struct {
+	Token token.Token // [ token
+	Left  Expression  // 被切片的数组或字符串
+	Start Expression  // 起始下标，可省略
+	End   Expression  // 结束下标（不含），可省略
+}
+
+// 定义切片节点为表达式
+var _ Expression = (*SliceExpression)(nil)
+
+// expressionNode 标识切片节点为表达式
+func (s *SliceExpression) expressionNode() {}
+
+// TokenLiteral 返回切片节点的token值
+func (s *SliceExpression) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+// Pos 返回切片表达式的起始位置
+func (s *SliceExpression) Pos() token.Position {
+	return s.Left.Pos()
+}
+
+// String 返回切片节点的字符串
+func (s *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(s.Left.String())
+	out.WriteString("[")
+	if s.Start != nil {
+		out.WriteString(s.Start.String())
+	}
+	out.WriteString(":")
+	if s.End != nil {
+		out.WriteString(s.End.String())
+	}
+	out.WriteString("]")
+	out.WriteString(")")
+	return out.String()
+}
+
+// AssignExpression 定义索引赋值节点，如 arr[0] = 1
+type AssignExpression struct {
+	Token  token.Token      // = token
+	Target *IndexExpression // 被赋值的数组或哈希元素
+	Value  Expression       // 新的值
+}
+
+// 定义索引赋值节点为表达式
+var _ Expression = (*AssignExpression)(nil)
+
+// expressionNode 标识索引赋值节点为表达式
+func (a *AssignExpression) expressionNode() {}
+
+// TokenLiteral 返回索引赋值节点的token值
+func (a *AssignExpression) TokenLiteral() string {
+	return a.Token.Literal
+}
+
+// Pos 返回索引赋值的起始位置
+func (a *AssignExpression) Pos() token.Position {
+	return a.Target.Pos()
+}
+
+// String 返回索引赋值节点的字符串
+func (a *AssignExpression) String() string {
+	return a.Target.String() + " = " + a.Value.String()
+}
+
+// PropertyExpression 定义属性访问节点，如 obj.name
+type PropertyExpression struct {
+	Token    token.Token // . token
+	Left     Expression  // 被访问的对象
+	Property *Identifier // 属性名
+}
+
+// 定义属性访问节点为表达式
+var _ Expression = (*PropertyExpression)(nil)
+
+// expressionNode 标识属性访问节点为表达式
+func (p *PropertyExpression) expressionNode() {}
+
+// TokenLiteral 返回属性访问节点的token值
+func (p *PropertyExpression) TokenLiteral() string {
+	return p.Token.Literal
+}
+
+// Pos 返回属性访问表达式的起始位置
+func (p *PropertyExpression) Pos() token.Position {
+	return p.Left.Pos()
+}
+
+// String 返回属性访问节点的字符串
+func (p *PropertyExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(p.Left.String())
+	out.WriteString(".")
+	out.WriteString(p.Property.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 // HashLiteral 定义哈希节点
 type HashLiteral struct {
 	Token token.Token               // 哈希token
@@ -488,6 +894,11 @@ func (h *HashLiteral) TokenLiteral() string {
 	return h.Token.Literal
 }
 
+// Pos 返回哈希字面量的起始位置
+func (h *HashLiteral) Pos() token.Position {
+	return h.Token.Pos
+}
+
 // String 返回哈希节点的字符串
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
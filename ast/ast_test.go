@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"monkey/token"
@@ -37,3 +38,79 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q, want=%q", program.String(), expected)
 	}
 }
+
+func TestDoc(t *testing.T) {
+	stmt := &LetStatement{Name: &Identifier{Value: "f"}}
+	if Doc(stmt) != "" {
+		t.Errorf("statement without comments has doc %q", Doc(stmt))
+	}
+	if !AttachComments(stmt, []string{"first", "second"}) {
+		t.Fatalf("LetStatement does not accept comments")
+	}
+	if Doc(stmt) != "first\nsecond" {
+		t.Errorf("wrong doc. got=%q", Doc(stmt))
+	}
+	if AttachComments(&Identifier{Value: "x"}, []string{"x"}) {
+		t.Errorf("Identifier accepted comments")
+	}
+}
+
+func TestTree(t *testing.T) {
+	stmt := &LetStatement{
+		Name: &Identifier{Value: "f"},
+		Value: &IfExpression{
+			Condition: &PrefixExpression{Operator: "!", Right: &Boolean{Value: true}},
+			Consequence: &BlockStatement{Statements: []Statement{
+				&ExpressionStatement{Expression: &ArrayLiteral{Elements: []Expression{
+					&StringLiteral{Value: ""},
+					&HashLiteral{Pairs: map[Expression]Expression{
+						&IntegerLiteral{Token: token.Token{Literal: "2"}, Value: 2}: &FloatLiteral{Value: 0.5},
+						&IntegerLiteral{Token: token.Token{Literal: "1"}, Value: 1}: &Identifier{Value: "x"},
+					}},
+				}}},
+			}},
+		},
+	}
+	AttachComments(stmt, []string{"skipped"})
+	expected := `LetStatement
+  Name: Identifier f
+  Value: IfExpression
+    Condition: PrefixExpression !
+      Right: Boolean true
+    Consequence: BlockStatement
+      Statements[0]: ExpressionStatement
+        Expression: ArrayLiteral
+          Elements[0]: StringLiteral ""
+          Elements[1]: HashLiteral
+            Pairs[0].Key: IntegerLiteral 1
+            Pairs[0].Value: Identifier x
+            Pairs[1].Key: IntegerLiteral 2
+            Pairs[1].Value: FloatLiteral 0.5
+`
+	if got := Tree(stmt); got != expected {
+		t.Errorf("wrong tree.\ngot:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	call := &CallExpression{
+		Function: &Identifier{Value: "f"},
+		Arguments: []Expression{
+			&Identifier{Value: "a"},
+			&FunctionLiteral{Body: &BlockStatement{Statements: []Statement{
+				&ExpressionStatement{Expression: &Identifier{Value: "hidden"}},
+			}}},
+		},
+	}
+	var names []string
+	Inspect(call, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			names = append(names, ident.Value)
+		}
+		_, isFunction := node.(*FunctionLiteral)
+		return !isFunction
+	})
+	if strings.Join(names, ",") != "f,a" {
+		t.Errorf("wrong identifiers visited. got=%v", names)
+	}
+}
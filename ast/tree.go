@@ -0,0 +1,113 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tree 返回节点的缩进树形表示，每行一个节点，子节点比父节点多缩进两个空格并标出所在的字段，
+// 运算符、字面量值等标量字段跟在节点类型名之后
+func Tree(node Node) string {
+	var out strings.Builder
+	writeTree(&out, 0, "", node)
+	return out.String()
+}
+
+// writeTree 输出一个节点及其子节点，label 为节点在父节点中的字段名
+func writeTree(out *strings.Builder, depth int, label string, node Node) {
+	v := reflect.Indirect(reflect.ValueOf(node))
+	out.WriteString(strings.Repeat("  ", depth) + label + v.Type().Name())
+	for i := range v.NumField() {
+		field, value := v.Type().Field(i), v.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		var scalar string
+		switch value.Kind() {
+		case reflect.String:
+			scalar = value.String()
+			if _, ok := node.(*StringLiteral); ok {
+				scalar = strconv.Quote(scalar)
+			}
+		case reflect.Int64, reflect.Float64, reflect.Bool:
+			scalar = fmt.Sprint(value.Interface())
+		}
+		if scalar != "" {
+			out.WriteString(" " + scalar)
+		}
+	}
+	out.WriteString("\n")
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if field.IsExported() && !field.Anonymous {
+			writeTreeField(out, depth+1, field.Name, v.Field(i))
+		}
+	}
+}
+
+// writeTreeField 输出字段中的子节点，数组字段逐个输出元素，哈希的键值对按键的字符串表示排序
+func writeTreeField(out *strings.Builder, depth int, name string, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if value.IsNil() {
+			return
+		}
+		if node, ok := value.Interface().(Node); ok {
+			writeTree(out, depth, name+": ", node)
+		}
+	case reflect.Slice:
+		for i := range value.Len() {
+			writeTreeField(out, depth, fmt.Sprintf("%s[%d]", name, i), value.Index(i))
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Interface().(Node).String() < keys[j].Interface().(Node).String()
+		})
+		for i, key := range keys {
+			writeTreeField(out, depth, fmt.Sprintf("%s[%d].Key", name, i), key)
+			writeTreeField(out, depth, fmt.Sprintf("%s[%d].Value", name, i), value.MapIndex(key))
+		}
+	}
+}
+
+// Inspect 深度优先遍历节点及其全部子节点，f 返回 false 时不再进入该节点的子节点。
+// 哈希字面量的键值对按 map 的顺序遍历，顺序不固定
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+	v := reflect.Indirect(reflect.ValueOf(node))
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if field.IsExported() && !field.Anonymous {
+			inspectField(v.Field(i), f)
+		}
+	}
+}
+
+// inspectField 遍历字段中的子节点
+func inspectField(value reflect.Value, f func(Node) bool) {
+	switch value.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if value.IsNil() {
+			return
+		}
+		if node, ok := value.Interface().(Node); ok {
+			Inspect(node, f)
+		}
+	case reflect.Slice:
+		for i := range value.Len() {
+			inspectField(value.Index(i), f)
+		}
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			inspectField(iter.Key(), f)
+			inspectField(iter.Value(), f)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"monkey/vm"
 )
 
+// input 基准测试使用的斐波那契程序，结果为 fibonacciResult
 var input = `
 let fibonacci = fn(x) {
 	if (x==0) {
@@ -27,7 +28,11 @@ let fibonacci = fn(x) {
 fibonacci(35);
 `
 
-func benchmark(engine string) {
+// fibonacciResult fibonacci(35) 的值
+const fibonacciResult = "9227465"
+
+// benchmark 用指定引擎执行 input 并输出耗时，返回计算结果，出错时返回 nil
+func benchmark(engine string) object.Object {
 	fmt.Println("Benchmarking...")
 	var duration time.Duration
 	var result object.Object
@@ -41,7 +46,7 @@ func benchmark(engine string) {
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Printf("compiler error: %s", err)
-			return
+			return nil
 		}
 
 		machine := vm.New(comp.Bytecode())
@@ -51,7 +56,7 @@ func benchmark(engine string) {
 		err = machine.Run()
 		if err != nil {
 			fmt.Printf("vm error: %s", err)
-			return
+			return nil
 		}
 
 		duration = time.Since(start)
@@ -64,4 +69,30 @@ func benchmark(engine string) {
 	}
 
 	fmt.Printf("engine=%s, result=%s, duration=%s\n", engine, result.Inspect(), duration)
+	return result
+}
+
+// execute 使用指定引擎执行一段程序并返回结果
+func execute(engine, source string) (object.Object, error) {
+	l := lexer.New(source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors: %v", p.Errors())
+	}
+
+	if engine == "vm" {
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			return nil, fmt.Errorf("compiler error: %s", err)
+		}
+		machine := vm.New(comp.Bytecode())
+		err = machine.Run()
+		if err != nil {
+			return nil, fmt.Errorf("vm error: %s", err)
+		}
+		return machine.LastPoppedStackElem(), nil
+	}
+	return evaluator.Eval(program, object.NewEnvironment()), nil
 }
@@ -1,6 +1,13 @@
 package benchmark
 
-import "testing"
+import (
+	"testing"
+
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/vm"
+)
 
 func Test_benchmark(t *testing.T) {
 	tests := []struct {
@@ -18,7 +25,127 @@ func Test_benchmark(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			benchmark(tt.engine)
+			result := benchmark(tt.engine)
+			if result == nil || result.Inspect() != fibonacciResult {
+				t.Errorf("wrong result. want=%s, got=%v", fibonacciResult, result)
+			}
 		})
 	}
 }
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	benchmarkFibonacci(b, "vm")
+}
+
+func BenchmarkFibonacciEval(b *testing.B) {
+	benchmarkFibonacci(b, "eval")
+}
+
+// benchmarkFibonacci 统计执行 fibonacci(35) 的耗时
+func benchmarkFibonacci(b *testing.B, engine string) {
+	for i := 0; i < b.N; i++ {
+		result, err := execute(engine, input)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Inspect() != fibonacciResult {
+			b.Fatalf("wrong result: %s", result.Inspect())
+		}
+	}
+}
+
+// arrayBuildingInput 在循环中反复构建数组
+var arrayBuildingInput = `
+let build = fn(n, acc) {
+	if (n == 0) {
+		acc
+	} else {
+		build(n - 1, push(acc, [n, n + 1, n + 2]))
+	}
+};
+len(build(200, []));
+`
+
+func BenchmarkArrayBuildingVM(b *testing.B) {
+	benchmarkArrayBuilding(b, "vm")
+}
+
+func BenchmarkArrayBuildingEval(b *testing.B) {
+	benchmarkArrayBuilding(b, "eval")
+}
+
+// benchmarkArrayBuilding 统计构建数组时的内存分配
+func benchmarkArrayBuilding(b *testing.B, engine string) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		result, err := execute(engine, arrayBuildingInput)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Inspect() != "200" {
+			b.Fatalf("wrong result: %s", result.Inspect())
+		}
+	}
+}
+
+// deepRecursionInput 深度递归调用
+var deepRecursionInput = `
+let sum = fn(n, acc) {
+	if (n == 0) {
+		acc
+	} else {
+		sum(n - 1, acc + n)
+	}
+};
+sum(500, 0);
+`
+
+func BenchmarkDeepRecursionEval(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		result, err := execute("eval", deepRecursionInput)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Inspect() != "125250" {
+			b.Fatalf("wrong result: %s", result.Inspect())
+		}
+	}
+}
+
+// shortProgram 用于衡量虚拟机创建开销的短程序
+var shortProgram = `let a = 1; let b = [a, 2, 3]; len(b) + a`
+
+func BenchmarkVMCreation(b *testing.B) {
+	benchmarkVMCreation(b, false)
+}
+
+func BenchmarkVMCreationPooled(b *testing.B) {
+	benchmarkVMCreation(b, true)
+}
+
+// benchmarkVMCreation 比较是否归还对象池时创建并运行虚拟机的开销
+func benchmarkVMCreation(b *testing.B, pooled bool) {
+	comp := compiler.New()
+	err := comp.Compile(parser.New(lexer.New(shortProgram)).ParseProgram())
+	if err != nil {
+		b.Fatal(err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		machine := vm.New(bytecode)
+		err := machine.Run()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if machine.LastPoppedStackElem().Inspect() != "4" {
+			b.Fatalf("wrong result: %s", machine.LastPoppedStackElem().Inspect())
+		}
+		if pooled {
+			machine.Release()
+		}
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
+	"strings"
+
+	"monkey/token"
 )
 
 type Instructions []byte
@@ -40,6 +44,15 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpGetMethod
+	OpPow
+	OpSetIndex
+	OpSlice
+	OpEnterLoop
+	OpExitLoop
+	OpUnwindLoop
+	OpJumpTruthyOrPop
+	OpJumpNotTruthyOrPop
 )
 
 // Definition 定义
@@ -49,36 +62,45 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:       {"OpConstant", []int{2}},
-	OpAdd:            {"OpAdd", []int{}},
-	OpPop:            {"OpPop", []int{}},
-	OpSub:            {"OpSub", []int{}},
-	OpMul:            {"OpMul", []int{}},
-	OpDiv:            {"OpDiv", []int{}},
-	OpTrue:           {"OpTrue", []int{}},
-	OpFalse:          {"OpFalse", []int{}},
-	OpEqual:          {"OpEqual", []int{}},
-	OpNotEqual:       {"OpNotEqual", []int{}},
-	OpGreaterThan:    {"OpGreaterThan", []int{}},
-	OpMinus:          {"OpMinus", []int{}},
-	OpBang:           {"OpBang", []int{}},
-	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
-	OpJump:           {"OpJump", []int{2}},
-	OpNull:           {"OpNull", []int{}},
-	OpSetGlobal:      {"OpSetGlobal", []int{2}},
-	OpGetGlobal:      {"OpGetGlobal", []int{2}},
-	OpArray:          {"OpArray", []int{2}},
-	OpHash:           {"OpHash", []int{2}},
-	OpIndex:          {"OpIndex", []int{}},
-	OpCall:           {"OpCall", []int{1}},
-	OpReturnValue:    {"OpReturnValue", []int{}},
-	OpReturn:         {"OpReturn", []int{}},
-	OpGetLocal:       {"OpGetLocal", []int{1}},
-	OpSetLocal:       {"OpSetLocal", []int{1}},
-	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{2, 1}},
-	OpGetFree:        {"OpGetFree", []int{1}},
-	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpAdd:                {"OpAdd", []int{}},
+	OpPop:                {"OpPop", []int{}},
+	OpSub:                {"OpSub", []int{}},
+	OpMul:                {"OpMul", []int{}},
+	OpDiv:                {"OpDiv", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	OpJumpNotTruthy:      {"OpJumpNotTruthy", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpNull:               {"OpNull", []int{}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpArray:              {"OpArray", []int{2}},
+	OpHash:               {"OpHash", []int{2}},
+	OpIndex:              {"OpIndex", []int{}},
+	OpCall:               {"OpCall", []int{1}},
+	OpReturnValue:        {"OpReturnValue", []int{}},
+	OpReturn:             {"OpReturn", []int{}},
+	OpGetLocal:           {"OpGetLocal", []int{1}},
+	OpSetLocal:           {"OpSetLocal", []int{1}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{1}},
+	OpClosure:            {"OpClosure", []int{2, 1}},
+	OpGetFree:            {"OpGetFree", []int{1}},
+	OpCurrentClosure:     {"OpCurrentClosure", []int{}},
+	OpGetMethod:          {"OpGetMethod", []int{2}},
+	OpPow:                {"OpPow", []int{}},
+	OpSetIndex:           {"OpSetIndex", []int{}},
+	OpSlice:              {"OpSlice", []int{}},
+	OpEnterLoop:          {"OpEnterLoop", []int{}},
+	OpExitLoop:           {"OpExitLoop", []int{}},
+	OpUnwindLoop:         {"OpUnwindLoop", []int{}},
+	OpJumpTruthyOrPop:    {"OpJumpTruthyOrPop", []int{2}},
+	OpJumpNotTruthyOrPop: {"OpJumpNotTruthyOrPop", []int{2}},
 }
 
 // Lookup 查找
@@ -153,6 +175,7 @@ func (ins Instructions) String() string {
 		def, err := Lookup(ins[i])
 		if err != nil {
 			_, _ = fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
 			continue
 		}
 		operands, read := ReadOperands(def, ins[i+1:])
@@ -162,6 +185,16 @@ func (ins Instructions) String() string {
 	return out.String()
 }
 
+// InstructionAt 反汇编位置 offset 处的单条指令，格式与 String 输出的一行相同
+func (ins Instructions) InstructionAt(offset int) string {
+	def, err := Lookup(ins[offset])
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s", err)
+	}
+	operands, _ := ReadOperands(def, ins[offset+1:])
+	return fmt.Sprintf("%04d %s", offset, ins.fmtInstruction(def, operands))
+}
+
 // fmtInstruction 格式化指令
 func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	operandsCount := len(def.OperandWidths)
@@ -178,3 +211,76 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	}
 	return fmt.Sprintf("ERROR: unsupported operand arity for %s", def.Name)
 }
+
+// Concat 连接多条指令
+func Concat(instructions ...Instructions) Instructions {
+	var out Instructions
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+// Diff 反汇编并逐行比较两段指令，返回标出第一处差异的可读结果，相同时返回空字符串。
+// 以 "-" 开头的行只出现在 expected 中，以 "+" 开头的行只出现在 actual 中
+func Diff(expected, actual Instructions) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+	offset := 0
+	for offset < len(expected) && offset < len(actual) && expected[offset] == actual[offset] {
+		offset++
+	}
+
+	want := disassembledLines(expected)
+	got := disassembledLines(actual)
+	var out bytes.Buffer
+	_, _ = fmt.Fprintf(&out, "first difference at offset %04d\n", offset)
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w == g {
+			_, _ = fmt.Fprintf(&out, "  %s\n", w)
+			continue
+		}
+		if w != "" {
+			_, _ = fmt.Fprintf(&out, "- %s\n", w)
+		}
+		if g != "" {
+			_, _ = fmt.Fprintf(&out, "+ %s\n", g)
+		}
+	}
+	return out.String()
+}
+
+// disassembledLines 反汇编指令并按行拆分
+func disassembledLines(ins Instructions) []string {
+	s := strings.TrimSuffix(ins.String(), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// SourcePosition 行号表中的一项：从 Offset 开始直到下一项之前的指令都编译自 Pos 处的源代码
+type SourcePosition struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable 指令位置到源代码位置的映射，按 Offset 升序排列
+type LineTable []SourcePosition
+
+// Lookup 返回位置 offset 处的指令对应的源代码位置，没有记录时返回 false
+func (t LineTable) Lookup(offset int) (token.Position, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+	return t[i-1].Pos, true
+}
@@ -1,7 +1,11 @@
 package code
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
+
+	"monkey/token"
 )
 
 func TestMake(t *testing.T) {
@@ -29,6 +33,29 @@ func TestMake(t *testing.T) {
 	}
 }
 
+func TestAllOpcodesDefined(t *testing.T) {
+	for op := OpConstant; op <= OpSlice; op++ {
+		if _, err := Lookup(byte(op)); err != nil {
+			t.Errorf("opcode %d has no definition", op)
+		}
+	}
+
+	widths := map[Opcode][]int{
+		OpCall:       {1},
+		OpGetLocal:   {1},
+		OpSetLocal:   {1},
+		OpGetBuiltin: {1},
+		OpClosure:    {2, 1},
+		OpGetFree:    {1},
+	}
+	for op, want := range widths {
+		def, _ := Lookup(byte(op))
+		if fmt.Sprint(def.OperandWidths) != fmt.Sprint(want) {
+			t.Errorf("%s has wrong operand widths. got=%v, want=%v", def.Name, def.OperandWidths, want)
+		}
+	}
+}
+
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
 		Make(OpAdd),
@@ -52,6 +79,94 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringUndefinedOpcode(t *testing.T) {
+	ins := Concat(Instructions{255}, Make(OpPop))
+	expected := "ERROR: opcode 255 undefined\n0001 OpPop\n"
+	if ins.String() != expected {
+		t.Errorf("instructions string: got %q, want %q", ins.String(), expected)
+	}
+}
+
+func TestInstructionAt(t *testing.T) {
+	ins := Concat(Make(OpConstant, 2), Make(OpAdd), Instructions{255})
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "0000 OpConstant 2"},
+		{3, "0003 OpAdd"},
+		{4, "ERROR: opcode 255 undefined"},
+	}
+	for _, tt := range tests {
+		if got := ins.InstructionAt(tt.offset); got != tt.expected {
+			t.Errorf("instruction at %d: got %q, want %q", tt.offset, got, tt.expected)
+		}
+	}
+}
+
+func TestLineTableLookup(t *testing.T) {
+	table := LineTable{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 4, Pos: token.Position{Line: 2, Column: 3}},
+		{Offset: 9, Pos: token.Position{Line: 1, Column: 5}},
+	}
+	tests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{0, token.Position{Line: 1, Column: 1}},
+		{3, token.Position{Line: 1, Column: 1}},
+		{4, token.Position{Line: 2, Column: 3}},
+		{8, token.Position{Line: 2, Column: 3}},
+		{20, token.Position{Line: 1, Column: 5}},
+	}
+	for _, tt := range tests {
+		pos, ok := table.Lookup(tt.offset)
+		if !ok || pos != tt.expected {
+			t.Errorf("Lookup(%d): got %s (%t), want %s", tt.offset, pos, ok, tt.expected)
+		}
+	}
+	if _, ok := (LineTable{}).Lookup(0); ok {
+		t.Errorf("Lookup on empty table should report no position")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	concat := Concat(Make(OpAdd), Make(OpConstant, 1), Make(OpPop))
+	expected := Instructions{byte(OpAdd), byte(OpConstant), 0, 1, byte(OpPop)}
+	if !bytes.Equal(concat, expected) {
+		t.Errorf("wrong concatenation. got=%v, want=%v", concat, expected)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	expected := Concat(Make(OpConstant, 0), Make(OpConstant, 1), Make(OpAdd))
+	if diff := Diff(expected, Concat(Make(OpConstant, 0), Make(OpConstant, 1), Make(OpAdd))); diff != "" {
+		t.Errorf("equal instructions have diff:\n%s", diff)
+	}
+
+	actual := Concat(Make(OpConstant, 0), Make(OpConstant, 2), Make(OpSub))
+	want := `first difference at offset 0005
+  0000 OpConstant 0
+- 0003 OpConstant 1
++ 0003 OpConstant 2
+- 0006 OpAdd
++ 0006 OpSub
+`
+	if diff := Diff(expected, actual); diff != want {
+		t.Errorf("wrong diff. got\n%s\nwant\n%s", diff, want)
+	}
+
+	want = `first difference at offset 0003
+  0000 OpConstant 0
+- 0003 OpConstant 1
+- 0006 OpAdd
+`
+	if diff := Diff(expected, Make(OpConstant, 0)); diff != want {
+		t.Errorf("wrong diff for truncated instructions. got\n%s\nwant\n%s", diff, want)
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -60,6 +175,8 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
+		{OpGetLocal, []int{0}, 1},
+		{OpCall, []int{7}, 1},
 		{OpClosure, []int{65535, 255}, 3},
 	}
 	for _, tt := range tests {
@@ -79,3 +196,13 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestReadUint8(t *testing.T) {
+	ins := Concat(Make(OpGetLocal, 200), Make(OpGetBuiltin, 3))
+	if got := ReadUint8(ins[1:]); got != 200 {
+		t.Errorf("wrong first operand. got=%d, want=200", got)
+	}
+	if got := ReadUint8(ins[3:]); got != 3 {
+		t.Errorf("wrong second operand. got=%d, want=3", got)
+	}
+}
@@ -1,12 +1,15 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
+	"strings"
 
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
+	"monkey/token"
 )
 
 // EmittedInstruction 存储指令和位置
@@ -20,6 +23,17 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	loops []*loopJumps // 正在编译的循环，最内层在最后
+
+	positions code.LineTable // 已生成指令对应的源代码位置
+}
+
+// loopJumps 记录循环中待回填的 break 和 continue 跳转指令位置
+type loopJumps struct {
+	breaks    []int
+	continues []int
+	unwind    bool // break 和 continue 可能在表达式求值中途跳出，跳转前需要回退栈
 }
 
 // Compiler 编译器
@@ -28,6 +42,15 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	dedupConstants bool        // 是否复用相同的常量
+	constantIndex  map[any]int // 开启常量去重时，可复用常量的键到其在常量池中位置的映射
+	elidePure      bool        // 是否跳过结果未被使用的纯表达式语句
+
+	hoisted    map[ast.Expression]Symbol // 外提到循环之前的表达式及保存其值的变量，为 nil 表示不外提
+	unassigned map[string]Symbol         // 已提升声明但对应的 let 还没编译到的函数名
+
+	position token.Position // 正在编译的节点的位置，记录到之后生成的指令上
 }
 
 // New 创建编译器
@@ -58,16 +81,104 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return compiler
 }
 
+// NewWithPredefined 创建编译器，宿主提供的对象作为常量放入常量池，
+// 并在程序开头按名字顺序赋值给同名全局变量，脚本可以直接引用
+func NewWithPredefined(predefined map[string]object.Object) *Compiler {
+	compiler := New()
+	names := make([]string, 0, len(predefined))
+	for name := range predefined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		symbol := compiler.symbolTable.Define(name)
+		compiler.emit(code.OpConstant, compiler.addConstant(predefined[name]))
+		compiler.emit(code.OpSetGlobal, symbol.Index)
+	}
+	return compiler
+}
+
+// hoistFunctions 预先在符号表中声明顶层用 let 绑定的函数名，
+// 使互相递归的函数在编译函数体时能解析到后面才定义的函数
+func (c *Compiler) hoistFunctions(statements []ast.Statement) {
+	for _, s := range statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			if c.unassigned == nil {
+				c.unassigned = make(map[string]Symbol)
+			}
+			c.unassigned[let.Name.Value] = c.symbolTable.Define(let.Name.Value)
+		}
+	}
+}
+
+// EnableConstantDedup 开启常量去重，相同的整数、字符串和不捕获自由变量的相同函数共用一个常量
+func (c *Compiler) EnableConstantDedup() {
+	c.dedupConstants = true
+}
+
+// EnablePureElision 开启纯表达式消除，结果未被使用且没有副作用的表达式语句（如 5; 或 x;）不再生成指令
+func (c *Compiler) EnablePureElision() {
+	c.elidePure = true
+}
+
+// compileStatements 依次编译程序或块中的语句。开启纯表达式消除时跳过除最后一条以外的纯表达式语句，
+// 最后一条语句的值可能是程序或块的结果，总是保留
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for i, s := range statements {
+		if c.elidePure && i < len(statements)-1 {
+			if stmt, ok := s.(*ast.ExpressionStatement); ok && c.isPure(stmt.Expression) {
+				continue
+			}
+		}
+		err := c.Compile(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPure 判断表达式求值时既没有副作用也不会出错：字面量、已赋值的变量，以及只由它们组成的数组。
+// 运算和调用可能在运行时出错或有副作用，不算纯表达式；提升后尚未赋值的函数名读取时会报错，也不算
+func (c *Compiler) isPure(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.Identifier:
+		_, pending := c.unassigned[expr.Value]
+		return !pending && c.symbolTable.defined(expr.Value)
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			if !c.isPure(el) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // Compile 编译
 func (c *Compiler) Compile(node ast.Node) error {
+	if pos := sourcePosition(node); pos.Line > 0 && pos != c.position {
+		outer := c.position
+		c.position = pos
+		defer func() { c.position = outer }()
+	}
+	if expr, ok := node.(ast.Expression); ok {
+		if symbol, ok := c.hoisted[expr]; ok {
+			c.loadSymbol(symbol)
+			return nil
+		}
+	}
 	switch n := node.(type) {
 	case *ast.Program:
-		for _, s := range n.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
-		}
+		c.hoistFunctions(n.Statements)
+		return c.compileStatements(n.Statements)
 	case *ast.ExpressionStatement:
 		err := c.Compile(n.Expression)
 		if err != nil {
@@ -75,6 +186,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.PrefixExpression:
+		if c.foldNegativeLiteral(n) {
+			return nil
+		}
 		err := c.Compile(n.Right)
 		if err != nil {
 			return err
@@ -88,6 +202,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unsupported prefix operator %s", n.Operator)
 		}
 	case *ast.InfixExpression:
+		if n.Operator == "&&" || n.Operator == "||" {
+			return c.compileLogicalExpression(n)
+		}
 		if n.Operator == "<" {
 			err := c.Compile(n.Right)
 			if err != nil {
@@ -113,6 +230,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpAdd)
 		case "-":
 			c.emit(code.OpSub)
+		case "**":
+			c.emit(code.OpPow)
 		case "*":
 			c.emit(code.OpMul)
 		case "/":
@@ -128,16 +247,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: n.Value}
-		c.emit(code.OpConstant, c.addConstant(integer))
+		c.emit(code.OpConstant, c.addIntegerConstant(integer))
 	case *ast.Boolean:
 		if n.Value {
 			c.emit(code.OpTrue)
 		} else {
 			c.emit(code.OpFalse)
 		}
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: n.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.StringLiteral:
 		str := &object.String{Value: n.Value}
-		c.emit(code.OpConstant, c.addConstant(str))
+		c.emit(code.OpConstant, c.addStringConstant(str))
 	case *ast.IfExpression:
 		err := c.Compile(n.Condition)
 		if err != nil {
@@ -149,8 +271,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		// 块以 let 等非表达式语句结尾时没有留下值，补一个 null 保持栈平衡
 		if c.lastInstructionIs(code.OpPop) {
 			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
 		}
 		jumpPos := c.emit(code.OpJump, 9999)
 
@@ -167,29 +292,75 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 		// 回填else后语句开始位置
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
-	case *ast.BlockStatement:
-		for _, s := range n.Statements {
-			err := c.Compile(s)
+	case *ast.WhileStatement:
+		if c.hoisted != nil {
+			done, err := c.hoistInvariants(n)
 			if err != nil {
 				return err
 			}
+			defer done()
+		}
+		loop := c.enterLoop(n.Body)
+		loopStart := len(c.currentInstructions())
+		err := c.Compile(n.Condition)
+		if err != nil {
+			return err
 		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		err = c.Compile(n.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+		loopEnd := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, loopEnd)
+		c.leaveLoop(loop, loopStart, loopEnd)
+		// 循环的值为 null，与表达式语句一样压栈后弹出
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return fmt.Errorf("%s outside loop", n.TokenLiteral())
+		}
+		loop := loops[len(loops)-1]
+		if loop.unwind {
+			c.emit(code.OpUnwindLoop)
+		}
+		pos := c.emit(code.OpJump, 9999)
+		if _, ok := n.(*ast.BreakStatement); ok {
+			loop.breaks = append(loop.breaks, pos)
+		} else {
+			loop.continues = append(loop.continues, pos)
+		}
+	case *ast.ForStatement:
+		return c.compileForStatement(n)
+	case *ast.LoopExpression:
+		err := c.Compile(n.Loop)
+		if err != nil {
+			return err
+		}
+		// 循环语句以 OpNull, OpPop 结尾，作为表达式时保留栈上的 null
+		c.removeLastPop()
+	case *ast.BlockStatement:
+		return c.compileStatements(n.Statements)
 	case *ast.LetStatement:
 		symbol := c.symbolTable.Define(n.Name.Value)
 		err := c.Compile(n.Value)
 		if err != nil {
 			return err
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+		if pending, ok := c.unassigned[n.Name.Value]; ok && pending == symbol {
+			delete(c.unassigned, n.Name.Value)
 		}
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(n.Value)
@@ -235,6 +406,36 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.SliceExpression:
+		err := c.Compile(n.Left)
+		if err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{n.Start, n.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			err = c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+	case *ast.AssignExpression:
+		err := c.Compile(n.Target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(n.Target.Index)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(n.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
 	case *ast.FunctionLiteral:
 		c.enterScope()
 		if n.Name != "" {
@@ -256,6 +457,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		positions := c.scopes[c.scopeIndex].positions
 		instructions := c.leaveScope()
 		for _, v := range freeSymbols {
 			c.loadSymbol(v)
@@ -264,26 +466,47 @@ func (c *Compiler) Compile(node ast.Node) error {
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(n.Parameters),
+			Positions:     positions,
 		}
-		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+		c.emit(code.OpClosure, c.addFunctionConstant(compiledFn, len(freeSymbols)), len(freeSymbols))
 	case *ast.ReturnStatement:
 		err := c.Compile(n.ReturnValue)
 		if err != nil {
 			return err
 		}
 		c.emit(code.OpReturnValue)
-	case *ast.CallExpression:
-		err := c.Compile(n.Function)
+	case *ast.PropertyExpression:
+		err := c.Compile(n.Left)
 		if err != nil {
 			return err
 		}
+		name := &object.String{Value: n.Property.Value}
+		c.emit(code.OpConstant, c.addStringConstant(name))
+		c.emit(code.OpIndex)
+	case *ast.CallExpression:
+		numArgs := len(n.Arguments)
+		if property, ok := n.Function.(*ast.PropertyExpression); ok {
+			// 方法调用：接收者作为第一个参数
+			err := c.Compile(property.Left)
+			if err != nil {
+				return err
+			}
+			name := &object.String{Value: property.Property.Value}
+			c.emit(code.OpGetMethod, c.addStringConstant(name))
+			numArgs++
+		} else {
+			err := c.Compile(n.Function)
+			if err != nil {
+				return err
+			}
+		}
 		for _, v := range n.Arguments {
-			err = c.Compile(v)
+			err := c.Compile(v)
 			if err != nil {
 				return err
 			}
 		}
-		c.emit(code.OpCall, len(n.Arguments))
+		c.emit(code.OpCall, numArgs)
 	}
 	return nil
 }
@@ -294,14 +517,112 @@ func (c *Compiler) addConstant(obj object.Object) int {
 	return len(c.constants) - 1
 }
 
+// addIntegerConstant 添加整数常量，相同的整数复用已有常量
+func (c *Compiler) addIntegerConstant(integer *object.Integer) int {
+	if !c.dedupConstants {
+		return c.addConstant(integer)
+	}
+	return c.addDedupConstant(integer)
+}
+
+// addStringConstant 添加字符串常量，内容相同的字符串复用已有常量。
+// 字符串不可修改且按内容比较，共用同一个对象不会改变程序的行为
+func (c *Compiler) addStringConstant(str *object.String) int {
+	if !c.dedupConstants {
+		return c.addConstant(str)
+	}
+	return c.addDedupConstant(str)
+}
+
+// addFunctionConstant 添加函数常量，不捕获自由变量的相同函数复用已有常量。
+// 捕获自由变量的闭包各自保留常量，避免合并捕获不同变量的闭包
+func (c *Compiler) addFunctionConstant(fn *object.CompiledFunction, numFree int) int {
+	if !c.dedupConstants || numFree > 0 {
+		return c.addConstant(fn)
+	}
+	return c.addDedupConstant(fn)
+}
+
+// addDedupConstant 添加可以复用的常量，常量池中已有相同的常量时返回它的位置。
+// 第一次调用时为常量池中已有的常量（如 NewWithState 传入的常量）建立索引
+func (c *Compiler) addDedupConstant(obj object.Object) int {
+	if c.constantIndex == nil {
+		c.constantIndex = make(map[any]int)
+		for i, constant := range c.constants {
+			if key, ok := constantKey(constant); ok {
+				if _, seen := c.constantIndex[key]; !seen {
+					c.constantIndex[key] = i
+				}
+			}
+		}
+	}
+	key, ok := constantKey(obj)
+	if !ok {
+		return c.addConstant(obj)
+	}
+	if i, ok := c.constantIndex[key]; ok {
+		return i
+	}
+	i := c.addConstant(obj)
+	c.constantIndex[key] = i
+	return i
+}
+
+// functionKey 函数常量的去重键。指令相同但源代码位置不同的函数不能合并，否则运行时错误会报告错误的位置
+type functionKey struct {
+	numLocals     int
+	numParameters int
+	instructions  string
+	positions     string
+}
+
+// constantKey 返回常量去重使用的键，整数、字符串和函数的键类型各不相同，不会互相混淆
+func constantKey(obj object.Object) (any, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value, true
+	case *object.String:
+		return obj.Value, true
+	case *object.CompiledFunction:
+		return functionKey{
+			numLocals:     obj.NumLocals,
+			numParameters: obj.NumParameters,
+			instructions:  string(obj.Instructions),
+			positions:     fmt.Sprint(obj.Positions),
+		}, true
+	}
+	return nil, false
+}
+
 // emit 添加指令
 func (c *Compiler) emit(op code.Opcode, operand ...int) int {
 	ins := code.Make(op, operand...)
 	pos := c.addInstruction(ins)
+	c.addPosition(pos)
 	c.setLastInstruction(op, pos)
 	return pos
 }
 
+// addPosition 记录从 offset 开始的指令编译自当前节点，先丢弃已被移除的指令留下的记录
+func (c *Compiler) addPosition(offset int) {
+	positions := c.scopes[c.scopeIndex].positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= offset {
+		positions = positions[:len(positions)-1]
+	}
+	if len(positions) == 0 || positions[len(positions)-1].Pos != c.position {
+		positions = append(positions, code.SourcePosition{Offset: offset, Pos: c.position})
+	}
+	c.scopes[c.scopeIndex].positions = positions
+}
+
+// sourcePosition 返回节点生成的指令出错时报告的位置，中缀表达式与求值器一致取运算符的位置
+func sourcePosition(node ast.Node) token.Position {
+	if infix, ok := node.(*ast.InfixExpression); ok {
+		return infix.Token.Pos
+	}
+	return node.Pos()
+}
+
 // addInstruction 添加指令
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewIns := len(c.currentInstructions())
@@ -323,6 +644,157 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.OpCode == op
 }
 
+// foldNegativeLiteral 将数字字面量前的负号在编译期折叠为一个负数常量，不再生成 OpMinus
+func (c *Compiler) foldNegativeLiteral(n *ast.PrefixExpression) bool {
+	if n.Operator != "-" {
+		return false
+	}
+	switch right := n.Right.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addIntegerConstant(&object.Integer{Value: -right.Value}))
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: -right.Value}))
+	default:
+		return false
+	}
+	return true
+}
+
+// compileForStatement 编译for语句，初始化语句中的 let 在块作用域中定义循环变量，不会泄漏到外层
+func (c *Compiler) compileForStatement(n *ast.ForStatement) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	var err error
+	if let, ok := n.Init.(*ast.LetStatement); ok {
+		symbol := c.symbolTable.Declare(let.Name.Value)
+		err = c.Compile(let.Value)
+		if err == nil {
+			c.storeSymbol(symbol)
+		}
+	} else {
+		err = c.Compile(n.Init)
+	}
+	if err != nil {
+		return err
+	}
+	loop := c.enterLoop(n.Body)
+	loopStart := len(c.currentInstructions())
+	err = c.Compile(n.Condition)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+	err = c.Compile(n.Body)
+	if err != nil {
+		return err
+	}
+	postStart := len(c.currentInstructions())
+	err = c.Compile(n.Post)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loopStart)
+	loopEnd := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, loopEnd)
+	c.leaveLoop(loop, postStart, loopEnd)
+	// 循环的值为 null，与表达式语句一样压栈后弹出
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// enterLoop 开始编译循环，需要回退栈时先记录进入循环时的栈指针。
+// 循环条件和 break 的目标还没有编译，enterLoop 要在循环开始位置之前调用
+func (c *Compiler) enterLoop(body *ast.BlockStatement) *loopJumps {
+	loop := &loopJumps{unwind: jumpsInExpression(body)}
+	if loop.unwind {
+		c.emit(code.OpEnterLoop)
+	}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+// leaveLoop 结束循环，将 continue 回填到 continueTarget，将 break 回填到循环结束位置
+func (c *Compiler) leaveLoop(loop *loopJumps, continueTarget, loopEnd int) {
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, continueTarget)
+	}
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, loopEnd)
+	}
+	if loop.unwind {
+		c.emit(code.OpExitLoop)
+	}
+}
+
+// jumpsInExpression 判断循环体中的 break 或 continue 是否可能在表达式求值中途执行，
+// 如 1 + if (c) { continue; }，此时栈上留有已求值的操作数。
+// 只出现在语句级 if 中的跳转不需要回退栈，内层循环和函数中的跳转不属于这个循环
+func jumpsInExpression(block *ast.BlockStatement) bool {
+	if block == nil {
+		return false
+	}
+	for _, s := range block.Statements {
+		var expr ast.Expression
+		switch s := s.(type) {
+		case *ast.ExpressionStatement:
+			expr = s.Expression
+		case *ast.LetStatement:
+			expr = s.Value
+		case *ast.ReturnStatement:
+			expr = s.ReturnValue
+		}
+		if ifExpr, ok := expr.(*ast.IfExpression); ok {
+			if containsJump(ifExpr.Condition) || jumpsInExpression(ifExpr.Consequence) || jumpsInExpression(ifExpr.Alternative) {
+				return true
+			}
+			continue
+		}
+		if expr != nil && containsJump(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsJump 判断表达式中是否有属于外层循环的 break 或 continue
+func containsJump(expr ast.Expression) bool {
+	found := false
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.BreakStatement, *ast.ContinueStatement:
+			found = true
+		case *ast.WhileStatement, *ast.ForStatement, *ast.FunctionLiteral:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// compileLogicalExpression 编译短路求值的 && 和 ||，左侧已决定结果时保留左侧的值并跳过右侧，
+// 否则弹出左侧的值计算右侧，结果为决定结果的操作数本身
+func (c *Compiler) compileLogicalExpression(n *ast.InfixExpression) error {
+	err := c.Compile(n.Left)
+	if err != nil {
+		return err
+	}
+	op := code.OpJumpNotTruthyOrPop
+	if n.Operator == "||" {
+		op = code.OpJumpTruthyOrPop
+	}
+	jumpPos := c.emit(op, 9999)
+	err = c.Compile(n.Right)
+	if err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
 // removeLastPop 移除最后一条Pop
 func (c *Compiler) removeLastPop() {
 	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:c.scopes[c.scopeIndex].lastInstruction.Position]
@@ -390,16 +862,62 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
+// storeSymbol 将栈顶的值保存到全局或局部变量
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
 // Bytecode 产生字节码
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		GlobalNames:  c.globalNames(),
 	}
 }
 
+// globalNames 返回全局符号表中按位置排列的变量名，块作用域中定义的全局变量没有名字
+func (c *Compiler) globalNames() []string {
+	root := c.symbolTable
+	for root.Outer != nil {
+		root = root.Outer
+	}
+	names := make([]string, root.numDefinitions)
+	for _, symbol := range root.GlobalSymbols() {
+		names[symbol.Index] = symbol.Name
+	}
+	return names
+}
+
 // Bytecode 字节码
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Positions    code.LineTable // 主程序指令对应的源代码位置
+	GlobalNames  []string       // 按位置排列的全局变量名，用于报告读取尚未赋值的全局变量
+}
+
+// ConstantsString 逐行列出常量池中每个常量的位置、类型和 Inspect 结果，
+// 编译函数列出参数和局部变量个数，并在其下缩进输出函数体的反汇编
+func (b *Bytecode) ConstantsString() string {
+	var out bytes.Buffer
+	for i, constant := range b.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			_, _ = fmt.Fprintf(&out, "%04d %s %s\n", i, constant.Type(), constant.Inspect())
+			continue
+		}
+		_, _ = fmt.Fprintf(&out, "%04d %s params=%d locals=%d\n", i, constant.Type(), fn.NumParameters, fn.NumLocals)
+		for _, line := range strings.SplitAfter(fn.Instructions.String(), "\n") {
+			if line != "" {
+				out.WriteString("    " + line)
+			}
+		}
+	}
+	return out.String()
 }
@@ -29,6 +29,26 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "2 ** 3",
+			expectedConstants: []any{2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 * 0.5",
+			expectedConstants: []any{2, 0.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "1; 2",
 			expectedConstants: []any{1, 2},
@@ -131,15 +151,66 @@ func TestIntegerArithmetic(t *testing.T) {
 		},
 		{
 			input:             "-1",
+			expectedConstants: []any{-1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, testCases)
+}
+
+func TestNegativeLiteralFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "-5",
+			expectedConstants: []any{-5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-2.5",
+			expectedConstants: []any{-2.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(-5)",
+			expectedConstants: []any{-5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; - x",
 			expectedConstants: []any{1},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpMinus),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1 - 5",
+			expectedConstants: []any{1, 5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSub),
+				code.Make(code.OpPop),
+			},
+		},
 	}
-	runCompilerTests(t, testCases)
+	runCompilerTests(t, tests)
 }
 
 func TestBooleanArithmetic(t *testing.T) {
@@ -400,6 +471,53 @@ func TestIndexExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestSliceExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"abc"[1:2]`,
+			expectedConstants: []any{"abc", 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1][:1]",
+			expectedConstants: []any{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1][0] = 2",
+			expectedConstants: []any{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -934,25 +1052,647 @@ func TestRecursiveFunctions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestMethodCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let obj = {}; obj.name`,
+			expectedConstants: []any{"name"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let obj = {}; obj.greet(1)`,
+			expectedConstants: []any{"greet", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetMethod, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantsString(t *testing.T) {
+	program := parse(`let add = fn(a, b) { a + b }; add(1, 2); "hi"`)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected := `0000 COMPILED_FUNCTION params=2 locals=2
+    0000 OpGetLocal 0
+    0002 OpGetLocal 1
+    0004 OpAdd
+    0005 OpReturnValue
+0001 INTEGER 1
+0002 INTEGER 2
+0003 STRING hi
+`
+	if got := compiler.Bytecode().ConstantsString(); got != expected {
+		t.Errorf("wrong constants listing.\ngot:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestConstantDedup(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// 指令相同但源代码位置不同的函数各自保留常量
+			input: `fn() { 1 }; fn() { 1 }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { fn() { a } }; fn(b) { fn() { b } }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let h = {"yes": "yes"}; h.yes; [h["yes"], "no", "yes"]`,
+			expectedConstants: []any{"yes", "no"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTestsWith(t, tests, (*Compiler).EnableConstantDedup)
+
+	// REPL 中每次输入都从第 1 行开始，再次输入的相同函数位置也相同，复用之前的常量
+	symbolTable := NewSymbolTable()
+	var constants []object.Object
+	for i := 0; i < 2; i++ {
+		compiler := NewWithState(symbolTable, constants)
+		compiler.EnableConstantDedup()
+		if err := compiler.Compile(parse(`fn() { 1 }`)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = compiler.Bytecode().Constants
+	}
+	if len(constants) != 2 {
+		t.Errorf("identical function from a later input was not reused. constants=%d", len(constants))
+	}
+}
+
+func TestPureElision(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = 1; 5; x; "dead"; [x, 2.5]; x`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `puts(1); 1 + 2; 3`,
+			expectedConstants: []any{1, 1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { fn() { a; 1 } }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTestsWith(t, tests, (*Compiler).EnablePureElision)
+
+	compiler := New()
+	compiler.EnablePureElision()
+	err := compiler.Compile(parse(`missing; 1`))
+	if err == nil || err.Error() != "identifier not found: missing" {
+		t.Errorf("undefined identifier should still be reported. got=%v", err)
+	}
+}
+
+func TestLoopHoisting(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let n = 10; let i = 0; while (i < n * 2) { let i = i + 1; } i`,
+			expectedConstants: []any{10, 0, 2, 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpSetGlobal, 1),
+				// 0012 n * 2 在循环前计算一次
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpConstant, 2),
+				// 0018
+				code.Make(code.OpMul),
+				// 0019
+				code.Make(code.OpSetGlobal, 2),
+				// 0022 循环开始
+				code.Make(code.OpGetGlobal, 2),
+				// 0025
+				code.Make(code.OpGetGlobal, 1),
+				// 0028
+				code.Make(code.OpGreaterThan),
+				// 0029
+				code.Make(code.OpJumpNotTruthy, 45),
+				// 0032
+				code.Make(code.OpGetGlobal, 1),
+				// 0035
+				code.Make(code.OpConstant, 3),
+				// 0038
+				code.Make(code.OpAdd),
+				// 0039
+				code.Make(code.OpSetGlobal, 1),
+				// 0042
+				code.Make(code.OpJump, 22),
+				// 0045
+				code.Make(code.OpNull),
+				// 0046
+				code.Make(code.OpPop),
+				// 0047
+				code.Make(code.OpGetGlobal, 1),
+				// 0050
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// i 在循环中重新绑定，&& 右侧可能被短路，都不外提
+			input:             `let i = 0; while (i + 1 < 3 && 1 / 0) { let i = i + 1; }`,
+			expectedConstants: []any{0, 3, 1, 1, 0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpJumpNotTruthyOrPop, 27),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpDiv),
+				code.Make(code.OpJumpNotTruthy, 43),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpJump, 6),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTestsWith(t, tests, (*Compiler).EnableLoopHoisting)
+}
+
+// TestBooleansAndNullNotPooled 虚拟机按指针比较 True/False/Null，它们不能进入常量池
+func TestBooleansAndNullNotPooled(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `true; false; !true; true == false; if (false) { true }`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJumpNotTruthy, 19),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJump, 20),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	compiler := New()
+	err := compiler.Compile(parse(`let t = true; let f = !t; if (f) { t } else { f }`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if n := len(compiler.Bytecode().Constants); n != 0 {
+		t.Errorf("booleans or null stored in constant pool. got %d constants", n)
+	}
+}
+
+func TestFunctionHoisting(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let f = fn() { g() }; let x = 1; let g = fn() { x };`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 2),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`foobar`, "identifier not found: foobar"},
+		{`let a = b;`, "identifier not found: b"},
+		{`fn() { x }`, "identifier not found: x"},
+		{`[1, missing]`, "identifier not found: missing"},
+		{`break;`, "break outside loop"},
+		{`while (true) { fn() { continue; } }`, "continue outside loop"},
+	}
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected compiler error for %q but got none", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 10 }",
+			expectedConstants: []any{10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLoopExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = while (false) {}",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 7),
+				// 0004
+				code.Make(code.OpJump, 0),
+				// 0007
+				code.Make(code.OpNull),
+				// 0008
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (let i = 0; i < 2; let i = i + 1) { i }",
+			expectedConstants: []any{0, 2, 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpGreaterThan),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 33),
+				// 0016
+				code.Make(code.OpGetGlobal, 0),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpGetGlobal, 0),
+				// 0023
+				code.Make(code.OpConstant, 2),
+				// 0026
+				code.Make(code.OpAdd),
+				// 0027
+				code.Make(code.OpSetGlobal, 0),
+				// 0030
+				code.Make(code.OpJump, 6),
+				// 0033
+				code.Make(code.OpNull),
+				// 0034
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	compiler := New()
+	err := compiler.Compile(parse("for (let i = 0; i < 2; let i = i + 1) {} i"))
+	if err == nil || err.Error() != "identifier not found: i" {
+		t.Errorf("loop variable leaked into outer scope. got=%v", err)
+	}
+}
+
+func TestBreakAndContinue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { if (false) { continue; } break; }",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 15),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpJump, 16),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpJump, 23),
+				// 0020
+				code.Make(code.OpJump, 0),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// break 在加法的右操作数中执行，跳转前丢弃已压栈的左操作数
+			input:             "while (true) { 1 + if (true) { break; } }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpEnterLoop),
+				// 0001
+				code.Make(code.OpTrue),
+				// 0002
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0005
+				code.Make(code.OpConstant, 0),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0012
+				code.Make(code.OpUnwindLoop),
+				// 0013
+				code.Make(code.OpJump, 26),
+				// 0016
+				code.Make(code.OpNull),
+				// 0017
+				code.Make(code.OpJump, 21),
+				// 0020
+				code.Make(code.OpNull),
+				// 0021
+				code.Make(code.OpAdd),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpJump, 1),
+				// 0026
+				code.Make(code.OpExitLoop),
+				// 0027
+				code.Make(code.OpNull),
+				// 0028
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestPredefinedGlobals(t *testing.T) {
+	compiler := NewWithPredefined(map[string]object.Object{
+		"version": &object.Integer{Value: 2},
+		"answer":  &object.Integer{Value: 42},
+	})
+	err := compiler.Compile(parse("answer + version"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	expectedInstructions := []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpSetGlobal, 1),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+	}
+	if err := testInstructions(t, expectedInstructions, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	if err := testConstants(t, []any{42, 2}, bytecode.Constants); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
 // runCompilerTests 运行编译器测试用例
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWith(t, tests, nil)
+}
+
+// runCompilerTestsWith 与 runCompilerTests 相同，setup 不为 nil 时在编译前用它配置编译器，
+// 例如开启可选的优化
+func runCompilerTestsWith(t *testing.T, tests []compilerTestCase, setup func(*Compiler)) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := New()
+		if setup != nil {
+			setup(compiler)
+		}
 		err := compiler.Compile(program)
 		if err != nil {
-			t.Fatalf("compiler error: %s", err)
+			t.Fatalf("compiler error for %s: %s", tt.input, err)
 		}
 		bytecode := compiler.Bytecode()
 
 		err = testInstructions(t, tt.expectedInstructions, bytecode.Instructions)
 		if err != nil {
-			t.Fatalf("testInstructions failed: %s", err)
+			t.Fatalf("testInstructions failed for %s: %s", tt.input, err)
 		}
 		err = testConstants(t, tt.expectedConstants, bytecode.Constants)
 		if err != nil {
-			t.Fatalf("testConstants failed: %s", err)
+			t.Fatalf("testConstants failed for %s: %s", tt.input, err)
 		}
 	}
 }
@@ -966,28 +1706,13 @@ func parse(input string) *ast.Program {
 
 // testInstructions 测试指令
 func testInstructions(t *testing.T, expected []code.Instructions, actual code.Instructions) error {
-	concat := concatInstructions(expected)
-	if len(concat) != len(actual) {
-		return fmt.Errorf("wrong number of instructions, want\n%s got\n%s", concat, actual)
-	}
-	for i := range concat {
-		if concat[i] != actual[i] {
-			fmt.Printf("liheyu want %b got %b\n", concat[i], actual[i])
-			return fmt.Errorf("wrong instruction at %d: want\n%s got\n%s", i, concat, actual)
-		}
+	diff := code.Diff(code.Concat(expected...), actual)
+	if diff != "" {
+		return fmt.Errorf("wrong instructions:\n%s", diff)
 	}
 	return nil
 }
 
-// concatInstructions 连接指令
-func concatInstructions(instructions []code.Instructions) code.Instructions {
-	var result code.Instructions
-	for _, ins := range instructions {
-		result = append(result, ins...)
-	}
-	return result
-}
-
 // testConstants 测试常量
 func testConstants(t *testing.T, expected []any, actual []object.Object) error {
 	if len(expected) != len(actual) {
@@ -1000,6 +1725,11 @@ func testConstants(t *testing.T, expected []any, actual []object.Object) error {
 			if err != nil {
 				return fmt.Errorf("constant %d failed: %s", i, err)
 			}
+		case float64:
+			result, ok := actual[i].(*object.Float)
+			if !ok || result.Value != constant {
+				return fmt.Errorf("constant %d - object is not Float %g. got=%T (%+v)", i, constant, actual[i], actual[i])
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -0,0 +1,111 @@
+package compiler
+
+import "monkey/ast"
+
+// EnableLoopHoisting 开启循环不变量外提：while 条件中不随循环变化的子表达式在进入循环前只计算一次。
+// 外提的表达式由字面量和循环中没有重新绑定的变量组成；循环中有函数调用或索引赋值时，
+// 变量指向的数组或哈希可能被修改，只外提纯字面量的表达式。
+// +、== 和 != 作用于哈希时会调用 __add__ 和 __eq__，这些运算只在两侧都是字面量时外提。
+// 条件中有函数调用或索引赋值时不外提任何部分，以免改变求值和副作用的顺序
+func (c *Compiler) EnableLoopHoisting() {
+	c.hoisted = make(map[ast.Expression]Symbol)
+}
+
+// hoistInvariants 在循环开始前计算 while 条件中的不变子表达式并保存到隐藏的变量中，
+// 之后编译条件时改为读取该变量。返回的函数在循环编译完成后清除这些记录
+func (c *Compiler) hoistInvariants(n *ast.WhileStatement) (func(), error) {
+	rebound := make(map[string]bool)
+	ast.Inspect(n, func(node ast.Node) bool {
+		if let, ok := node.(*ast.LetStatement); ok {
+			rebound[let.Name.Value] = true
+		}
+		return true
+	})
+	effects := hasEffects(n.Body)
+	if hasEffects(n.Condition) {
+		return func() {}, nil
+	}
+	invariant := func(name string) bool {
+		return !effects && !rebound[name] && c.symbolTable.defined(name)
+	}
+
+	var hoisted []ast.Expression
+	for _, expr := range invariantExpressions(n.Condition, invariant) {
+		err := c.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		// 隐藏变量只在一次性的块作用域中声明，名字不会被查到，也不会出现在全局符号中
+		symbol := NewBlockSymbolTable(c.symbolTable).Declare("")
+		c.storeSymbol(symbol)
+		c.hoisted[expr] = symbol
+		hoisted = append(hoisted, expr)
+	}
+	return func() {
+		for _, expr := range hoisted {
+			delete(c.hoisted, expr)
+		}
+	}, nil
+}
+
+// hasEffects 判断节点中是否有可能产生副作用的函数调用或索引赋值
+func hasEffects(node ast.Node) bool {
+	effects := false
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.CallExpression, *ast.AssignExpression:
+			effects = true
+		}
+		return !effects
+	})
+	return effects
+}
+
+// invariantExpressions 返回条件中可以外提的最大子表达式。
+// 只外提每次计算条件时都会求值的部分，&& 和 || 的右侧可能被短路，不会外提
+func invariantExpressions(expr ast.Expression, invariant func(name string) bool) []ast.Expression {
+	switch expr := expr.(type) {
+	case *ast.PrefixExpression:
+		if isInvariant(expr, invariant) {
+			return []ast.Expression{expr}
+		}
+		return invariantExpressions(expr.Right, invariant)
+	case *ast.InfixExpression:
+		if expr.Operator == "&&" || expr.Operator == "||" {
+			return invariantExpressions(expr.Left, invariant)
+		}
+		if isInvariant(expr, invariant) {
+			return []ast.Expression{expr}
+		}
+		return append(invariantExpressions(expr.Left, invariant), invariantExpressions(expr.Right, invariant)...)
+	}
+	return nil
+}
+
+// isInvariant 判断表达式是否只由字面量、不变的变量以及它们之间的前缀和中缀运算组成，
+// 可以被重载的运算符只作用于字面量
+func isInvariant(expr ast.Expression, invariant func(name string) bool) bool {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.Identifier:
+		return invariant(expr.Value)
+	case *ast.PrefixExpression:
+		return isInvariant(expr.Right, invariant)
+	case *ast.InfixExpression:
+		if expr.Operator == "&&" || expr.Operator == "||" {
+			return false
+		}
+		if overloadable(expr.Operator) {
+			literal := func(string) bool { return false }
+			return isInvariant(expr.Left, literal) && isInvariant(expr.Right, literal)
+		}
+		return isInvariant(expr.Left, invariant) && isInvariant(expr.Right, invariant)
+	}
+	return false
+}
+
+// overloadable 判断中缀运算符作用于哈希时是否会调用运算符重载方法
+func overloadable(operator string) bool {
+	return operator == "+" || operator == "==" || operator == "!="
+}
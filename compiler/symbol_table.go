@@ -1,5 +1,7 @@
 package compiler
 
+import "sort"
+
 // SymbolScope 符号作用域
 type SymbolScope string
 
@@ -26,6 +28,8 @@ type SymbolTable struct {
 	numDefinitions int
 
 	FreeSymbols []Symbol
+
+	block bool // 块作用域与外层符号表共用局部变量的位置，只隔离名字
 }
 
 // NewSymbolTable 创建符号表
@@ -43,19 +47,58 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
-// Define 定义符号
+// NewBlockSymbolTable 创建块作用域的符号表，其中定义的变量仍存放在外层函数或全局的位置中，
+// 但名字只在块内可见
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
+// owner 返回为块作用域分配变量位置的函数或全局符号表
+func (st *SymbolTable) owner() *SymbolTable {
+	for st.block {
+		st = st.Outer
+	}
+	return st
+}
+
+// Define 定义符号，同一作用域中重复定义的名字复用已有的位置，let 重新绑定相当于赋值；
+// 块作用域中重新绑定所属函数或全局中已有的名字同样复用外层的位置。
+// 因此之前定义的函数读取全局变量时会看到重新绑定后的值，与求值器一致；
+// 闭包捕获的局部变量仍是创建闭包时的值
 func (st *SymbolTable) Define(name string) Symbol {
+	if existing, ok := st.lookupVariable(name); ok {
+		return existing
+	}
+	return st.Declare(name)
+}
+
+// lookupVariable 在当前作用域以及块作用域所属的外层作用域中查找变量，不跨越函数边界
+func (st *SymbolTable) lookupVariable(name string) (Symbol, bool) {
+	if existing, ok := st.store[name]; ok && (existing.Scope == GlobalScope || existing.Scope == LocalScope) {
+		return existing, true
+	}
+	if st.block {
+		return st.Outer.lookupVariable(name)
+	}
+	return Symbol{}, false
+}
+
+// Declare 在当前作用域中定义新的符号，块作用域中会遮蔽外层的同名变量
+func (st *SymbolTable) Declare(name string) Symbol {
+	owner := st.owner()
 	symbol := Symbol{
 		Name:  name,
-		Index: st.numDefinitions,
+		Index: owner.numDefinitions,
 	}
-	if st.Outer == nil {
+	if owner.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
 	}
 	st.store[name] = symbol
-	st.numDefinitions++
+	owner.numDefinitions++
 	return symbol
 }
 
@@ -64,7 +107,7 @@ func (st *SymbolTable) Resolve(name string) (Symbol, bool) {
 	symbol, ok := st.store[name]
 	if !ok && st.Outer != nil {
 		symbol, ok = st.Outer.Resolve(name)
-		if ok && symbol.Scope != GlobalScope && symbol.Scope != BuiltinScope {
+		if ok && !st.block && symbol.Scope != GlobalScope && symbol.Scope != BuiltinScope {
 			symbol = st.DefineFree(symbol)
 			ok = true
 		}
@@ -72,6 +115,28 @@ func (st *SymbolTable) Resolve(name string) (Symbol, bool) {
 	return symbol, ok
 }
 
+// defined 判断名字能否解析，与 Resolve 不同，不会把外层函数的局部变量登记为自由变量
+func (st *SymbolTable) defined(name string) bool {
+	for ; st != nil; st = st.Outer {
+		if _, ok := st.store[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GlobalSymbols 返回当前符号表中定义的全局变量，按位置排序
+func (st *SymbolTable) GlobalSymbols() []Symbol {
+	var symbols []Symbol
+	for _, symbol := range st.store {
+		if symbol.Scope == GlobalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+	return symbols
+}
+
 // DefineBuiltin 定义内置符号
 func (st *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{
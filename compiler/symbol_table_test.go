@@ -42,6 +42,62 @@ func TestDefine(t *testing.T) {
 	}
 }
 
+func TestRedefineReusesIndex(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+	if a := global.Define("a"); a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("redefined global got new symbol: %+v", a)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	if c := local.Define("c"); c != (Symbol{Name: "c", Scope: LocalScope, Index: 0}) {
+		t.Errorf("redefined local got new symbol: %+v", c)
+	}
+	if a := local.Define("a"); a != (Symbol{Name: "a", Scope: LocalScope, Index: 1}) {
+		t.Errorf("local shadowing global got wrong symbol: %+v", a)
+	}
+	if local.numDefinitions != 2 {
+		t.Errorf("wrong number of local definitions. got=%d", local.numDefinitions)
+	}
+}
+
+func TestBlockSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+	block := NewBlockSymbolTable(local)
+
+	if c := block.Define("c"); c != (Symbol{Name: "c", Scope: LocalScope, Index: 1}) {
+		t.Errorf("block symbol not allocated in enclosing frame: %+v", c)
+	}
+	if b, ok := block.Resolve("b"); !ok || b != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("outer local resolved as %+v, ok=%t", b, ok)
+	}
+	if b := block.Define("b"); b != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("redefining outer local in block did not reuse it: %+v", b)
+	}
+	if b := block.Declare("b"); b != (Symbol{Name: "b", Scope: LocalScope, Index: 2}) {
+		t.Errorf("declared block symbol does not shadow outer local: %+v", b)
+	}
+	if len(block.FreeSymbols) != 0 || len(local.FreeSymbols) != 0 {
+		t.Errorf("block scope created free symbols")
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("block symbol visible outside the block")
+	}
+	if local.numDefinitions != 3 {
+		t.Errorf("wrong number of local definitions. got=%d", local.numDefinitions)
+	}
+
+	globalBlock := NewBlockSymbolTable(global)
+	if d := globalBlock.Define("d"); d != (Symbol{Name: "d", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("block symbol at top level is not global: %+v", d)
+	}
+}
+
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -64,6 +120,29 @@ func TestResolveGlobal(t *testing.T) {
 	}
 }
 
+func TestGlobalSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("b")
+	global.Define("a")
+	global.Define("b")
+	NewEnclosedSymbolTable(global).Define("local")
+
+	expected := []Symbol{
+		{Name: "b", Scope: GlobalScope, Index: 0},
+		{Name: "a", Scope: GlobalScope, Index: 1},
+	}
+	symbols := global.GlobalSymbols()
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of global symbols. got=%+v", symbols)
+	}
+	for i, sym := range expected {
+		if symbols[i] != sym {
+			t.Errorf("symbols[%d] wrong. want=%+v, got=%+v", i, sym, symbols[i])
+		}
+	}
+}
+
 func TestResolveLocal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -324,3 +403,81 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestResolveNestedFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("g")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("a")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("b")
+
+	thirdLocal := NewEnclosedSymbolTable(secondLocal)
+	thirdLocal.Define("c")
+
+	expected := []Symbol{
+		{Name: "g", Scope: GlobalScope, Index: 0},
+		{Name: "a", Scope: FreeScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+	for _, sym := range expected {
+		result, ok := thirdLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	// 中间作用域需要把外层局部变量转为自由变量，才能传递给最内层
+	expectedSecondFree := []Symbol{
+		{Name: "a", Scope: LocalScope, Index: 0},
+	}
+	if len(secondLocal.FreeSymbols) != len(expectedSecondFree) {
+		t.Fatalf("wrong number of free symbols in second scope. got=%d, want=%d",
+			len(secondLocal.FreeSymbols), len(expectedSecondFree))
+	}
+	for i, sym := range expectedSecondFree {
+		if secondLocal.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol in second scope. got=%+v, want=%+v", secondLocal.FreeSymbols[i], sym)
+		}
+	}
+
+	expectedThirdFree := []Symbol{
+		{Name: "a", Scope: FreeScope, Index: 0},
+		{Name: "b", Scope: LocalScope, Index: 0},
+	}
+	if len(thirdLocal.FreeSymbols) != len(expectedThirdFree) {
+		t.Fatalf("wrong number of free symbols in third scope. got=%d, want=%d",
+			len(thirdLocal.FreeSymbols), len(expectedThirdFree))
+	}
+	for i, sym := range expectedThirdFree {
+		if thirdLocal.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol in third scope. got=%+v, want=%+v", thirdLocal.FreeSymbols[i], sym)
+		}
+	}
+}
+
+func TestResolveFunctionNameFromNestedScope(t *testing.T) {
+	global := NewSymbolTable()
+	fnScope := NewEnclosedSymbolTable(global)
+	fnScope.DefineFunctionName("countDown")
+	inner := NewEnclosedSymbolTable(fnScope)
+
+	result, ok := inner.Resolve("countDown")
+	if !ok {
+		t.Fatalf("function name countDown not resolvable")
+	}
+	expected := Symbol{Name: "countDown", Scope: FreeScope, Index: 0}
+	if result != expected {
+		t.Errorf("expected countDown to resolve to %+v, got=%+v", expected, result)
+	}
+	if inner.FreeSymbols[0].Scope != FunctionScope {
+		t.Errorf("free symbol should capture the function scope. got=%+v", inner.FreeSymbols[0])
+	}
+}
@@ -0,0 +1,284 @@
+package conformance
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+)
+
+// errorCategories 两个引擎对同一类错误的措辞不同，以这些前缀开头的错误信息归为同一类后再比较，
+// 其余错误信息要求完全一致
+var errorCategories = []struct {
+	category string
+	prefixes []string
+}{
+	{"type error", []string{"type mismatch:", "unsupported operator:", "unsupported types for binary operation:", "unsupported type for negation:", "unknown operator:"}},
+	{"unusable as hash key", []string{"unusable as hash key"}},
+}
+
+// errorOf 返回出错时的期望结果，message 为错误信息或 errorCategories 中的类别
+func errorOf(message string) string {
+	return "ERROR: " + message
+}
+
+var programs = []struct {
+	input    string
+	expected string
+}{
+	// 算术
+	{`1 + 2 * 3`, "7"},
+	{`(1 + 2) * 3`, "9"},
+	{`7 / 2`, "3"},
+	{`-7 / 2`, "-3"},
+	{`2 ** 10`, "1024"},
+	{`1.5 + 1`, "2.5"},
+	{`3 / 2.0`, "1.5"},
+	{`-(-5)`, "5"},
+	{`1 < 2 && 2 > 3`, "false"},
+	{`!0`, "false"},
+	{`1 == 1.0`, "true"},
+	{`divmod(-7, 2)`, "[-3, -1]"},
+	{`2 ** -1`, errorOf("negative exponent: -1")},
+	{`1 + true`, errorOf("type error")},
+	{`-true`, errorOf("type error")},
+
+	// 字符串
+	{`"mon" + "key"`, "monkey"},
+	{`"a" == "a"`, "true"},
+	{`"a" != "b"`, "true"},
+	{`len("猴子")`, "6"},
+	{`"hello"[1:3]`, "el"},
+	{`"hello"[0]`, "h"},
+	{`"hello"[9]`, "null"},
+	{`"tab\tquote\""`, "tab\tquote\""},
+	{`repr("a\n")`, `"a\n"`},
+	{`repr([1, 1.0, "1", bytes("1")])`, `[1, 1.0, "1", b"1"]`},
+	{`str([1, 1.0, "1", bytes("1")])`, `[1, 1.0, 1, b"1"]`},
+	{`str(12) + "!"`, "12!"},
+	{`int("41") + 1`, "42"},
+	{`"a" - "b"`, errorOf("type error")},
+	{`"猴子"[1]`, errorOf("string index 1 splits a UTF-8 character")},
+
+	// 数组
+	{`[1, 2, 3][1]`, "2"},
+	{`[1, 2, 3][-1]`, "null"},
+	{`[1, 2, 3][1:]`, "[2, 3]"},
+	{`len([1, [2, 3]])`, "2"},
+	{`first([])`, "null"},
+	{`rest([1, 2, 3])`, "[2, 3]"},
+	{`push([1], 2)`, "[1, 2]"},
+	{`let a = [1, 2]; a[0] = 5; a`, "[5, 2]"},
+	{`flatten([[1], [2, [3]]])`, "[1, 2, [3]]"},
+	{`map(range(4), fn(x) { x * x })`, "[0, 1, 4, 9]"},
+	{`filter(range(10), fn(x) { x > 6 })`, "[7, 8, 9]"},
+	{`reduce([1, 2, 3], 10, fn(a, b) { a + b })`, "16"},
+	{`sort([3, 1, 2], {"reverse": true})`, "[3, 2, 1]"},
+	{`[1, 2][5] = 1`, errorOf("index out of range: 5 (length 2)")},
+	{`first(1)`, errorOf("argument to `first` must be Array, got INTEGER")},
+
+	// 哈希
+	{`{"a": 1}["a"]`, "1"},
+	{`{"a": 1}["b"]`, "null"},
+	{`{1: "one", true: "yes"}[true]`, "yes"},
+	{`let h = {}; h["k"] = 2; h["k"] * 3`, "6"},
+	{`sortedKeys({"b": 1, "a": 2})`, "[a, b]"},
+	{`let p = {"x": 1, "get": fn(self) { self["x"] }}; p.get()`, "1"},
+	{`type({"__type__": "Point"})`, "Point"},
+	{`{[1]: 1}`, errorOf("unusable as hash key")},
+	{`{}[fn() {}]`, errorOf("unusable as hash key")},
+
+	// 索引
+	{`[1, 2, 3][0]`, "1"},
+	{`[1, 2, 3][2]`, "3"},
+	{`[1, 2, 3][3]`, "null"},
+	{`[1, 2, 3][-3]`, "null"},
+	{`[][0]`, "null"},
+	{`"abc"[2]`, "c"},
+	{`"abc"[3]`, "null"},
+	{`"abc"[-1]`, "null"},
+	{`""[0]`, "null"},
+	{`{1: "a", "1": "b"}[1]`, "a"},
+	{`{1: "a", "1": "b"}["1"]`, "b"},
+	{`{1: "a"}[-1]`, "null"},
+	{`[1]["0"]`, errorOf("index operator not supported: ARRAY")},
+	{`"abc"[true]`, errorOf("index operator not supported: STRING")},
+	{`5[0]`, errorOf("index operator not supported: INTEGER")},
+	{`{}[[]]`, errorOf("unusable as hash key")},
+
+	// 字节数组
+	{`bytes("hi")`, `b"hi"`},
+	{`string(bytes("猴子"))`, "猴子"},
+	{`let b = bytes("héllo"); [b[0], b[1], b[2], b[6]]`, "[104, 195, 169, null]"},
+	{`bytes("abc")[-1]`, "null"},
+	{`len(bytes("猴子"))`, "6"},
+	{`bytes([104, 105]) == bytes("hi")`, "true"},
+	{`bytes("a") != bytes("b")`, "true"},
+	{`bytes("abc")[1:]`, `b"bc"`},
+	{`bytes([0, 255])`, `b"\x00\xff"`},
+	{`type(bytes(""))`, "BYTES"},
+	{`string(bytes([255]))`, errorOf("result of `string` is not valid UTF-8")},
+	{`bytes([256])`, errorOf("byte value out of range: 256")},
+	{`bytes(1)`, errorOf("argument to `bytes` must be String or Array, got INTEGER")},
+
+	// 错误和真假：错误不会被当作假值，&& 和 || 的结果是布尔值
+	{`first(1) || 5`, "5"},
+	{`let e = len(1); e || 5`, "5"},
+	{`first(1) && 5`, errorOf("argument to `first` must be Array, got INTEGER")},
+	{`len(1) + 1`, errorOf("type error")},
+	{`if (first(1)) { 1 } else { 2 }`, "2"},
+	{`!first(1)`, "true"},
+	{`let r = first(1); r || 7`, "7"},
+	{`let r = divmod(7, 0); if (r) { r[0] } else { -1 }`, "-1"},
+	{`let r = divmod(7, 2); if (r) { r[0] } else { -1 }`, "3"},
+	{`first([]) || 7`, "7"},
+	{`let r = first([]); if (r) { r } else { 7 }`, "7"},
+	{`0 && "x"`, "x"},
+	{`false || 0`, "0"},
+	{`(1 + true) || 5`, errorOf("type error")},
+
+	// 函数和闭包
+	{`let add = fn(a, b) { a + b }; add(2, 3)`, "5"},
+	{`let adder = fn(x) { fn(y) { x + y } }; adder(2)(3)`, "5"},
+	{`let f = fn() { return 1; 2 }; f()`, "1"},
+	{`fn() {}()`, "null"},
+	{`let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(10)`, "3628800"},
+	{`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10)`, "true"},
+	{`let counter = fn() { let c = [0]; fn() { c[0] = c[0] + 1 } }; let next = counter(); next(); next()`, "2"},
+	{`fn(a) { a }(1, 2)`, errorOf("wrong number of arguments: want=1, got=2")},
+	{`5()`, errorOf("calling INTEGER is not supported")},
+	{`let p = {"x": 1}; p.get()`, errorOf("undefined method get on HASH")},
+	{`let p = {"get": 1}; p.get()`, errorOf("calling INTEGER is not supported")},
+	{`[1].get()`, errorOf("index operator not supported: ARRAY")},
+	{`missing`, errorOf("identifier not found: missing")},
+	{`let f = fn() { g() }; f(); let g = fn() { 1 };`, errorOf("identifier not found: g")},
+	{`let f = fn() { g() }; let g = fn() { 1 }; f()`, "1"},
+	{`let x = 1; let f = fn() { x }; let x = 2; f()`, "2"},
+
+	// 控制流
+	{`if (1 > 2) { 10 } else { 20 }`, "20"},
+	{`if (false) { 10 }`, "null"},
+	{`let i = 0; while (i < 5) { let i = i + 1; } i`, "5"},
+	{`let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 5) { break; } if (i == 2) { continue; } let s = s + i; } s`, "8"},
+	{`let x = while (false) {}; x`, "null"},
+	{`let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } } i`, "3"},
+	{`let f = fn() { let fs = []; for (let i = 0; i < 3; let i = i + 1) { let fs = push(fs, fn() { i }); } [fs[0](), fs[2]()] }; f()`, "[0, 2]"},
+	{`let f = fn() { let fs = []; for (let i = 0; i < 3; let i = i + 1) { let j = i * 10; let fs = push(fs, fn() { j }); } [fs[0](), fs[2]()] }; f()`, "[0, 20]"},
+	{`let fs = []; for (let i = 0; i < 3; let i = i + 1) { let fs = push(fs, fn() { i }); } [fs[0](), fs[2]()]`, "[3, 3]"},
+	{`let i = 0; while (i < 5000) { let i = i + 1; let x = 1 + if (true) { continue; } else { 2 }; } i`, "5000"},
+	{`let n = 0; let r = [1, 2, while (true) { let n = n + 1; let y = n * if (n < 100) { continue; } else { break; }; }]; [n, len(r)]`, "[100, 3]"},
+}
+
+func TestEnginesAgree(t *testing.T) {
+	for _, tt := range programs {
+		evaluated := runEvaluator(t, tt.input)
+		compiled := runVM(t, tt.input)
+		if evaluated != compiled {
+			t.Errorf("engines disagree on %s: evaluator=%q, vm=%q", tt.input, evaluated, compiled)
+			continue
+		}
+		if evaluated != tt.expected {
+			t.Errorf("wrong result for %s: expected=%q, got=%q", tt.input, tt.expected, evaluated)
+		}
+	}
+}
+
+func TestIndexErrorsAgree(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[1]["0"]`, "index operator not supported: ARRAY"},
+		{`"abc"[true]`, "index operator not supported: STRING"},
+		{`5[0]`, "index operator not supported: INTEGER"},
+		{`{}[[]]`, "unusable as hash key: ARRAY"},
+		{`bytes("a")["0"]`, "index operator not supported: BYTES"},
+		{`"猴子"[1]`, "string index 1 splits a UTF-8 character"},
+	}
+	for _, tt := range tests {
+		p := parse(t, tt.input)
+		program := p.ParseProgram()
+		evaluated, ok := evaluator.Eval(program, object.NewEnvironment()).(*object.Error)
+		if !ok || evaluated.Message != tt.expected {
+			t.Errorf("wrong evaluator error for %s: expected=%q, got=%+v", tt.input, tt.expected, evaluated)
+		}
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error for %s: %s", tt.input, err)
+		}
+		var runtimeErr *vm.RuntimeError
+		err := vm.New(comp.Bytecode()).Run()
+		if !errors.As(err, &runtimeErr) || runtimeErr.Err.Error() != tt.expected {
+			t.Errorf("wrong vm error for %s: expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *parser.Parser {
+	t.Helper()
+	return parser.New(lexer.New(input))
+}
+
+// runEvaluator 用求值器执行程序，返回结果的字符串表示
+func runEvaluator(t *testing.T, input string) string {
+	t.Helper()
+	p := parse(t, input)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %s: %v", input, p.Errors())
+	}
+	result := evaluator.Eval(program, object.NewEnvironment())
+	return describe(result)
+}
+
+// runVM 编译程序并用虚拟机执行，返回结果的字符串表示，错误信息去掉位置后再归类
+func runVM(t *testing.T, input string) string {
+	t.Helper()
+	p := parse(t, input)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %s: %v", input, p.Errors())
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return describeError(err.Error())
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			err = runtimeErr.Err
+		}
+		return describeError(err.Error())
+	}
+	return describe(machine.LastPoppedStackElem())
+}
+
+// describe 返回结果的字符串表示，错误对象只保留归类后的错误信息
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "null"
+	}
+	if err, ok := obj.(*object.Error); ok {
+		return describeError(err.Message)
+	}
+	return obj.Inspect()
+}
+
+// describeError 按 errorCategories 归类错误信息
+func describeError(message string) string {
+	for _, c := range errorCategories {
+		for _, prefix := range c.prefixes {
+			if strings.HasPrefix(message, prefix) {
+				return errorOf(c.category)
+			}
+		}
+	}
+	return errorOf(message)
+}
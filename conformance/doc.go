@@ -0,0 +1,3 @@
+// Package conformance 检查树遍历求值器和编译器+虚拟机对同一程序给出相同的结果，
+// 测试见 conformance_test.go
+package conformance
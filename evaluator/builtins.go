@@ -5,10 +5,37 @@ import (
 )
 
 var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"puts":  object.GetBuiltinByName("puts"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
+	"len":         object.GetBuiltinByName("len"),
+	"puts":        object.GetBuiltinByName("puts"),
+	"first":       object.GetBuiltinByName("first"),
+	"last":        object.GetBuiltinByName("last"),
+	"rest":        object.GetBuiltinByName("rest"),
+	"push":        object.GetBuiltinByName("push"),
+	"capacity":    object.GetBuiltinByName("capacity"),
+	"assign":      object.GetBuiltinByName("assign"),
+	"type":        object.GetBuiltinByName("type"),
+	"sortedKeys":  object.GetBuiltinByName("sortedKeys"),
+	"toJSON":      object.GetBuiltinByName("toJSON"),
+	"divmod":      object.GetBuiltinByName("divmod"),
+	"flatten":     object.GetBuiltinByName("flatten"),
+	"flattenDeep": object.GetBuiltinByName("flattenDeep"),
+	"chr":         object.GetBuiltinByName("chr"),
+	"repr":        object.GetBuiltinByName("repr"),
+	"map":         object.GetBuiltinByName("map"),
+	"filter":      object.GetBuiltinByName("filter"),
+	"reduce":      object.GetBuiltinByName("reduce"),
+	"range":       object.GetBuiltinByName("range"),
+	"bench":       object.GetBuiltinByName("bench"),
+	"str":         object.GetBuiltinByName("str"),
+	"int":         object.GetBuiltinByName("int"),
+	"sort":        object.GetBuiltinByName("sort"),
+	"split":       object.GetBuiltinByName("split"),
+	"sortBy":      object.GetBuiltinByName("sortBy"),
+	"groupBy":     object.GetBuiltinByName("groupBy"),
+	"countBy":     object.GetBuiltinByName("countBy"),
+	"iterate":     object.GetBuiltinByName("iterate"),
+	"keys":        object.GetBuiltinByName("keys"),
+	"values":      object.GetBuiltinByName("values"),
+	"bytes":       object.GetBuiltinByName("bytes"),
+	"string":      object.GetBuiltinByName("string"),
 }
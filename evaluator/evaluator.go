@@ -1,8 +1,14 @@
 package evaluator
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
 )
 
 var (
@@ -13,8 +19,22 @@ var (
 		Value: false,
 	}
 	Null = &object.Null{}
+
+	breakValue    = &object.BreakValue{}
+	continueValue = &object.ContinueValue{}
 )
 
+// SafeEval 执行表达式，并将求值过程中意外的 panic 转换为错误返回
+func SafeEval(node ast.Node, env *object.Environment) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = fmt.Errorf("evaluation panicked: %v", r)
+		}
+	}()
+	return Eval(node, env), nil
+}
+
 // Eval 执行表达式
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
@@ -24,37 +44,53 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(evalPrefixExpression(node.Operator, right, env), node.Token.Pos)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return withPosition(evalInfixExpression(node.Operator, left, right, env), node.Token.Pos)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.LoopExpression:
+		// 循环语句已经以 Null 作为自身的值
+		return Eval(node.Loop, env)
+	case *ast.BreakStatement:
+		return breakValue
+	case *ast.ContinueStatement:
+		return continueValue
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
@@ -67,35 +103,48 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			Env:        env,
 		}
 	case *ast.CallExpression:
+		if property, ok := node.Function.(*ast.PropertyExpression); ok {
+			return evalMethodCall(property, node.Arguments, env)
+		}
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, env.CallDepth()+1, env.Truthiness())
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+	case *ast.AssignExpression:
+		return evalIndexAssignment(node, env)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.PropertyExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		return evalIndexExpression(left, &object.String{Value: node.Property.Value})
 	default:
 		return &object.Error{Message: "unknown node type for eval"}
 	}
@@ -103,10 +152,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 }
 
 // evalPrefixExpression 执行前缀表达式
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func evalPrefixExpression(operator string, right object.Object, env *object.Environment) object.Object {
 	switch operator {
 	case "!":
-		return evalBangOperatorExpression(right)
+		return evalBangOperatorExpression(right, env)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
@@ -123,12 +172,12 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		result = Eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
-			return result.Value
+			return unwrapErrorValue(result.Value)
 		case *object.Error:
 			return result
 		}
 	}
-	return result
+	return unwrapErrorValue(result)
 }
 
 // evalBlockStatement 执行块语句
@@ -137,15 +186,15 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	for _, statement := range block.Statements {
 		result = Eval(statement, env)
 		if result != nil {
-			rt := result.Type()
-			switch rt {
-			case object.ReturnValueObj:
-				return result
-			case object.ErrorObj:
+			if isAbrupt(result) {
 				return result
 			}
 		}
 	}
+	if result == nil {
+		// 空块或以 let 结尾的块没有值
+		return Null
+	}
 	return result
 }
 
@@ -158,32 +207,32 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 }
 
 // evalBangOperatorExpression 执行前缀表达式 !
-func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case True:
-		return False
-	case False:
-		return True
-	case Null:
-		return True
-	default:
-		return False
-	}
+func evalBangOperatorExpression(right object.Object, env *object.Environment) object.Object {
+	return nativeBoolToBooleanObject(!isTruthy(right, env))
 }
 
-// evalMinusPrefixOperatorExpression 执行前缀表达式 -
+// evalMinusPrefixOperatorExpression 执行前缀表达式 -，-math.MinInt64 与 Go 一样回绕为 math.MinInt64
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	if integer, ok := right.(*object.Integer); ok && right.Type() == object.IntegerObj {
 		return &object.Integer{Value: -integer.Value}
 	}
+	if float, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -float.Value}
+	}
 	return &object.Error{
 		Message: "unsupported operator: -" + string(right.Type()),
 	}
 }
 
 // evalInfixExpression 执行中缀表达式
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
-
+func evalInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
+	if l, ok := left.(*object.Hash); ok {
+		if r, ok := right.(*object.Hash); ok {
+			if result, ok := evalHashOperator(operator, l, r, env); ok {
+				return result
+			}
+		}
+	}
 	if left.Type() == object.IntegerObj && right.Type() == object.IntegerObj {
 		l, okLeft := left.(*object.Integer)
 		r, okRight := right.(*object.Integer)
@@ -191,6 +240,13 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 			return evalIntegerInfixExpression(operator, l, r)
 		}
 	}
+	if left.Type() == object.FloatObj || right.Type() == object.FloatObj {
+		l, okLeft := object.FloatValue(left)
+		r, okRight := object.FloatValue(right)
+		if okLeft && okRight {
+			return evalFloatInfixExpression(operator, l, r)
+		}
+	}
 	if left.Type() == object.StringObj && right.Type() == object.StringObj {
 		l, okLeft := left.(*object.String)
 		r, okRight := right.(*object.String)
@@ -198,6 +254,11 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 			return evalStringInfixExpression(operator, l, r)
 		}
 	}
+	if l, ok := left.(*object.Bytes); ok && (operator == "==" || operator == "!=") {
+		if r, ok := right.(*object.Bytes); ok {
+			return nativeBoolToBooleanObject(bytes.Equal(l.Value, r.Value) == (operator == "=="))
+		}
+	}
 	if operator == "==" {
 		return nativeBoolToBooleanObject(left == right)
 	} else if operator == "!=" {
@@ -209,6 +270,41 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	return &object.Error{Message: "unsupported operator: " + string(left.Type()) + " " + operator + " " + string(right.Type())}
 }
 
+// evalLogicalExpression 执行短路求值的 && 和 ||，左侧已决定结果时不计算右侧，
+// 结果为决定结果的操作数本身，因此 r || fallback 可以在 r 为假或错误值时取默认值
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	if isTruthy(left, env) == (node.Operator == "||") {
+		return left
+	}
+	return Eval(node.Right, env)
+}
+
+// evalHashOperator 调用左侧哈希中定义的运算符方法，未定义时返回 false
+func evalHashOperator(operator string, left, right *object.Hash, env *object.Environment) (object.Object, bool) {
+	var name string
+	switch operator {
+	case "+":
+		name = object.AddMethod
+	case "==", "!=":
+		name = object.EqMethod
+	default:
+		return nil, false
+	}
+	method, ok := left.Field(name)
+	if !ok {
+		return nil, false
+	}
+	result := applyFunction(method, []object.Object{left, right}, env.CallDepth()+1, env.Truthiness())
+	if operator == "!=" && !isError(result) {
+		return nativeBoolToBooleanObject(!isTruthy(result, env)), true
+	}
+	return result, true
+}
+
 // evalIntegerInfixExpression 执行中缀表达式，整数类型
 func evalIntegerInfixExpression(operator string, left, right *object.Integer) object.Object {
 	switch operator {
@@ -220,6 +316,11 @@ func evalIntegerInfixExpression(operator string, left, right *object.Integer) ob
 		return &object.Integer{Value: left.Value * right.Value}
 	case "/":
 		return &object.Integer{Value: left.Value / right.Value}
+	case "**":
+		if right.Value < 0 {
+			return &object.Error{Message: "negative exponent: " + right.Inspect()}
+		}
+		return &object.Integer{Value: object.IntPow(left.Value, right.Value)}
 	case "<":
 		return nativeBoolToBooleanObject(left.Value < right.Value)
 	case ">":
@@ -232,11 +333,40 @@ func evalIntegerInfixExpression(operator string, left, right *object.Integer) ob
 	return &object.Error{Message: "unsupported operator: " + string(left.Type()) + " " + operator + " " + string(right.Type())}
 }
 
+// evalFloatInfixExpression 执行中缀表达式，浮点数类型，整数操作数已提升为浮点数
+func evalFloatInfixExpression(operator string, left, right float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: left + right}
+	case "-":
+		return &object.Float{Value: left - right}
+	case "*":
+		return &object.Float{Value: left * right}
+	case "/":
+		return &object.Float{Value: left / right}
+	case "**":
+		return &object.Float{Value: math.Pow(left, right)}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	}
+	return &object.Error{Message: "unsupported operator: FLOAT " + operator + " FLOAT"}
+}
+
 // evalStringInfixExpression
 func evalStringInfixExpression(operator string, left, right *object.String) object.Object {
 	switch operator {
 	case "+":
 		return &object.String{Value: left.Value + right.Value}
+	case "==":
+		return nativeBoolToBooleanObject(left.Value == right.Value)
+	case "!=":
+		return nativeBoolToBooleanObject(left.Value != right.Value)
 	}
 	return &object.Error{Message: "unsupported operator: " + string(left.Type()) + " " + operator + " " + string(right.Type())}
 }
@@ -244,10 +374,10 @@ func evalStringInfixExpression(operator string, left, right *object.String) obje
 // evalIfExpression 计算if表达式
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
-	if isTruthy(condition) {
+	if isTruthy(condition, env) {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
@@ -255,26 +385,108 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	return Null
 }
 
-// isTruthy 判断对象是否为真
-func isTruthy(obj object.Object) bool {
-	if obj == Null {
-		return false
+// evalWhileStatement 执行while语句，循环体中的 break、return 和错误会结束循环，循环本身的值为 Null
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition, env) {
+			return Null
+		}
+		result := Eval(ws.Body, env)
+		if result == breakValue {
+			return Null
+		}
+		if isAbrupt(result) && result != continueValue {
+			return result
+		}
 	}
-	if obj == True {
-		return true
+}
+
+// evalForStatement 执行for语句，初始化语句中的 let 定义只在循环内可见的循环变量，
+// 循环体中对外层已有变量的 let 修改外层变量，循环本身的值为 Null。
+// 与虚拟机一致，函数中的循环每次迭代在新的环境中绑定循环变量，循环体中创建的闭包捕获的是本次迭代的值；
+// 顶层的循环变量在虚拟机中是全局变量，所有迭代共用同一个环境
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	loopEnv := object.NewBlockEnvironment(env)
+	var result object.Object
+	var name string
+	if let, ok := fs.Init.(*ast.LetStatement); ok {
+		result = Eval(let.Value, loopEnv)
+		if isAbrupt(result) {
+			return result
+		}
+		name = let.Name.Value
+		loopEnv.Declare(name, result)
+	} else {
+		result = Eval(fs.Init, loopEnv)
+		if isAbrupt(result) {
+			return result
+		}
 	}
-	if obj == False {
-		return false
+	for {
+		condition := Eval(fs.Condition, loopEnv)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition, loopEnv) {
+			return Null
+		}
+		result = Eval(fs.Body, loopEnv)
+		if result == breakValue {
+			return Null
+		}
+		if isAbrupt(result) && result != continueValue {
+			return result
+		}
+		if name != "" && env.CallDepth() > 0 {
+			value, _ := loopEnv.Get(name)
+			loopEnv = object.NewBlockEnvironment(env)
+			loopEnv.Declare(name, value)
+		}
+		result = Eval(fs.Post, loopEnv)
+		if isAbrupt(result) {
+			return result
+		}
 	}
-	return true
 }
 
-// isError 判断对象是否为错误
+// isTruthy 按环境的设置判断对象是否为真，规则见 object.Truthiness
+func isTruthy(obj object.Object, env *object.Environment) bool {
+	return env.Truthiness().IsTruthy(unwrapErrorValue(obj))
+}
+
+// errorValue 内置函数返回的错误值。与虚拟机一致，它是普通的值，可以绑定到变量和作为参数传递，
+// 在条件中为假。求值器用 *object.Error 表示中止求值的运行时错误，所以错误值在求值器内部用这个类型包装
+type errorValue struct {
+	*object.Error
+}
+
+// unwrapErrorValue 把错误值还原为 *object.Error，用于传给内置函数以及返回求值结果
+func unwrapErrorValue(obj object.Object) object.Object {
+	if value, ok := obj.(*errorValue); ok {
+		return value.Error
+	}
+	return obj
+}
+
+// isError 判断对象是否为中止求值的运行时错误，错误值不算在内
 func isError(obj object.Object) bool {
+	_, ok := obj.(*object.Error)
+	return ok
+}
+
+// isAbrupt 判断对象是否会中断表达式求值：运行时错误、块中的 return 返回值或循环中的 break 和 continue
+func isAbrupt(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ErrorObj
+		switch obj.Type() {
+		case object.ReturnValueObj, object.BreakValueObj, object.ContinueValueObj:
+			return true
+		}
 	}
-	return false
+	return isError(obj)
 }
 
 // evalIdentifier 计算标识符
@@ -285,15 +497,23 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
-	return &object.Error{Message: "identifier not found: " + node.Value}
+	return &object.Error{Message: "identifier not found: " + node.Value, Pos: node.Token.Pos}
+}
+
+// withPosition 为还没有位置的错误对象记录出错的位置，其他对象原样返回
+func withPosition(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && err.Pos.Line == 0 {
+		err.Pos = pos
+	}
+	return obj
 }
 
 // evalExpressions 计算表达式列表
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
-	var result []object.Object
+	result := make([]object.Object, 0, len(exps))
 	for _, e := range exps {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -301,27 +521,83 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-// applyFunction 计算函数调用
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// ApplyFunction 调用函数对象，供虚拟机等外部调用者执行求值器创建的函数
+func ApplyFunction(fn object.Object, args []object.Object) object.Object {
+	return applyFunction(fn, args, 1, object.Truthiness{})
+}
+
+// applyFunction 计算函数调用，depth 为本次调用的深度。
+// Monkey 函数按定义时环境的设置求值并检查调用深度，内置函数按调用者的 truthiness 判断回调的结果
+func applyFunction(fn object.Object, args []object.Object, depth int, truthiness object.Truthiness) object.Object {
 	if fun, ok := fn.(*object.Function); ok {
+		if depth > fun.Env.MaxCallDepth() {
+			return &object.Error{Message: "maximum recursion depth exceeded"}
+		}
+		if len(args) != len(fun.Parameters) {
+			return &object.Error{Message: fmt.Sprintf("wrong number of arguments: want=%d, got=%d", len(fun.Parameters), len(args))}
+		}
 		extendedEnv := extendFunctionEnv(fun, args)
+		extendedEnv.SetCallDepth(depth)
 		evaluated := Eval(fun.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	}
 
 	if builtin, ok := fn.(*object.Builtin); ok {
-		if result := builtin.Fn(args...); result != nil {
-			return result
+		return applyBuiltin(builtin, args, depth, truthiness)
+	}
+
+	return &object.Error{Message: fmt.Sprintf("calling %s is not supported", fn.Type())}
+}
+
+// applyBuiltin 调用内置函数。回调的 Monkey 函数出错时中止求值，
+// 内置函数自身返回的错误作为错误值返回
+func applyBuiltin(builtin *object.Builtin, args []object.Object, depth int, truthiness object.Truthiness) object.Object {
+	var callErr object.Object
+	call := object.Caller{Truthiness: truthiness}
+	call.Call = func(fn object.Object, args ...object.Object) object.Object {
+		result := applyFunction(fn, args, depth+1, truthiness)
+		if isError(result) {
+			callErr = result
 		}
+		return unwrapErrorValue(result)
+	}
+	unwrapped := make([]object.Object, len(args))
+	for i, arg := range args {
+		unwrapped[i] = unwrapErrorValue(arg)
+	}
+	result := builtin.Call(call, unwrapped...)
+	if callErr != nil {
+		return callErr
+	}
+	if err, ok := result.(*object.Error); ok {
+		return &errorValue{Error: err}
+	}
+	if result == nil {
 		return Null
 	}
+	return result
+}
 
-	return &object.Error{Message: "not a function"}
+// evalMethodCall 计算方法调用 obj.method(args)，接收者作为第一个参数传入
+func evalMethodCall(property *ast.PropertyExpression, arguments []ast.Expression, env *object.Environment) object.Object {
+	receiver := Eval(property.Left, env)
+	if isAbrupt(receiver) {
+		return receiver
+	}
+	method, err := object.Method(receiver, property.Property.Value)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	args := evalExpressions(arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+	return applyFunction(method, append([]object.Object{receiver}, args...), env.CallDepth()+1, env.Truthiness())
 }
 
 // extendFunctionEnv 扩展函数环境
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewSizedEnclosedEnvironment(fn.Env, len(fn.Parameters))
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
 	}
@@ -336,39 +612,76 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-// evalIndexExpression 计算索引表达式
+// evalIndexExpression 计算索引表达式，越界或不存在的键返回 Null
 func evalIndexExpression(left, index object.Object) object.Object {
-	switch {
-	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
-		l, okL := left.(*object.Array)
-		i, okI := index.(*object.Integer)
-		if okL && okI {
-			return evalArrayIndexExpression(l, i)
+	result, err := object.Index(left, index)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	if result == nil {
+		return Null
+	}
+	return result
+}
+
+// evalSliceExpression 计算切片表达式，省略的边界以 Null 传给 object.Slice
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	bounds := []object.Object{Null, Null}
+	for i, bound := range []ast.Expression{node.Start, node.End} {
+		if bound == nil {
+			continue
 		}
-	case left.Type() == object.HashObj:
-		l, okL := left.(*object.Hash)
-		if okL {
-			return evalHashIndexExpression(l, index)
+		bounds[i] = Eval(bound, env)
+		if isAbrupt(bounds[i]) {
+			return bounds[i]
 		}
 	}
-	return &object.Error{Message: "index operator not supported"}
+	result, err := object.Slice(left, bounds[0], bounds[1])
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
 }
 
-// evalArrayIndexExpression 计算数组索引表达式
-func evalArrayIndexExpression(arr *object.Array, index *object.Integer) object.Object {
-	i := int(index.Value)
-	if i < 0 || i > len(arr.Elements)-1 {
-		return Null
+// evalIndexAssignment 计算索引赋值，原地修改数组元素或哈希键值，结果为赋的值
+func evalIndexAssignment(node *ast.AssignExpression, env *object.Environment) object.Object {
+	left := Eval(node.Target.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	index := Eval(node.Target.Index, env)
+	if isAbrupt(index) {
+		return index
+	}
+	value := Eval(node.Value, env)
+	if isAbrupt(value) {
+		return value
 	}
-	return arr.Elements[i]
+	if err := object.SetIndex(left, index, value); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return value
 }
 
 // evalHashLiteral 计算哈希字面量
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	keys := make([]ast.Expression, 0, len(node.Pairs))
+	for k := range node.Pairs {
+		keys = append(keys, k)
+	}
+	// 与编译器一致按键排序，保证求值顺序和报告的错误可复现
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	pairs := make(map[object.HashKey]object.HashPair, len(keys))
+	for _, keyNode := range keys {
+		valueNode := node.Pairs[keyNode]
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 		hashKey, ok := key.(object.Hashable)
@@ -376,23 +689,10 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return &object.Error{Message: "unusable as hash key"}
 		}
 		value := Eval(valueNode, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
 	}
 	return &object.Hash{Pairs: pairs}
 }
-
-// evalHashIndexExpression 计算哈希索引表达式
-func evalHashIndexExpression(hash *object.Hash, index object.Object) object.Object {
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return &object.Error{Message: "unusable as hash key: " + string(index.Type())}
-	}
-	pair, ok := hash.Pairs[key.HashKey()]
-	if !ok {
-		return Null
-	}
-	return pair.Value
-}
@@ -1,7 +1,10 @@
 package evaluator
 
 import (
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"monkey/lexer"
 	"monkey/object"
@@ -28,6 +31,17 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"-5 / -4", 1},
 		{"10 / 3", 3},
 		{"3*3*3", 27},
+		{"0 - 0", 0},
+		{"- -5", 5},
+		{"2 ** 10", 1024},
+		{"2 ** 0", 1},
+		{"-2 ** 2", -4},
+		{"(-2) ** 2", 4},
+		{"2 ** 3 ** 2", 512},
+		{"2 * 3 ** 2", 18},
+		{"-(-5)", 5},
+		{"-9223372036854775807 - 1", math.MinInt64},
+		{"-(-9223372036854775807 - 1)", math.MinInt64},
 		{"3*3/3", 3},
 	}
 	for _, tt := range tests {
@@ -57,6 +71,47 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"3.14", 3.14},
+		{"-0.5", -0.5},
+		{"2 + 0.5", 2.5},
+		{"0.5 + 2", 2.5},
+		{"5.0 / 2.0", 2.5},
+		{"5 / 2.0", 2.5},
+		{"1.5 * 2", 3},
+		{"10 - 0.25", 9.75},
+		{"2.0 ** 3", 8},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result, ok := evaluated.(*object.Float)
+		if !ok {
+			t.Errorf("%s: obj is not Float. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if result.Value != tt.want {
+			t.Errorf("%s: wrong value. got=%g, want=%g", tt.input, result.Value, tt.want)
+		}
+	}
+
+	comparisons := []struct {
+		input string
+		want  bool
+	}{
+		{"1.5 < 2", true},
+		{"2.5 > 2.4", true},
+		{"1.0 == 1", true},
+		{"0.1 != 0.1", false},
+	}
+	for _, tt := range comparisons {
+		testBooleanObject(t, testEval(tt.input), tt.want)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -74,6 +129,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 != 2", true},
 		{"1 == 1", true},
 		{"1 != 1", false},
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "a"`, false},
+		{`"a" != "b"`, true},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -94,6 +153,40 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	return true
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true && true", "true"},
+		{"true && false", "false"},
+		{"false && true", "false"},
+		{"false || true", "true"},
+		{"false || false", "false"},
+		{"1 && 2", "2"},
+		{"1 || 2", "1"},
+		{`if (false) { 1 } || "x"`, "x"},
+		{"if (false) { 1 } && 2", "null"},
+		{"1 < 2 && 2 < 3", "true"},
+		{"false && missing()", "false"},
+		{"true || missing()", "true"},
+		{"let x = 0; let f = fn() { x > 1 }; true && f()", "false"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	errObj, ok := testEval("true && missing()").(*object.Error)
+	if !ok {
+		t.Fatalf("right operand not evaluated when needed")
+	}
+	if errObj.Message != "identifier not found: missing" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
 func TestIfElseExpressions(t *testing.T) {
 	tests := []struct {
 		input string
@@ -130,6 +223,154 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	return true
 }
 
+func TestWhileLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let i = 0; while (i < 10) { let i = i + 1; } i", 10},
+		{"let i = 0; let sum = 0; while (i < 5) { let i = i + 1; let sum = sum + i; } sum", 15},
+		{"let f = fn(n) { while (n > 0) { let n = n - 1; } n }; f(3)", 0},
+		{"fn() { let i = 0; while (true) { if (i > 3) { return i; } let i = i + 1; } }()", 4},
+		{"while (false) { 1 }", nil},
+		{"let i = 0; while (i < 3) { let i = i + 1; }", nil},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+		} else if tt.input[0] == 'w' {
+			testNullObject(t, evaluated)
+		}
+	}
+
+	errObj, ok := testEval("while (missing) {}").(*object.Error)
+	if !ok || errObj.Message != "identifier not found: missing" {
+		t.Errorf("condition error not propagated. got=%v", errObj)
+	}
+}
+
+// 循环出现在表达式位置时的值总是 null，循环体最后一个表达式的值被丢弃
+func TestLoopResults(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let x = while (false) {}; x", nil},
+		{"let i = 0; let x = while (i < 3) { let i = i + 1; i }; if (x) { 1 } else { i }", 3},
+		{"let x = for (let i = 0; i < 3; let i = i + 1) { if (i == 1) { break; } i }; if (x) { 1 } else { 2 }", 2},
+		{"fn() { let r = while (true) { return 7; }; r }()", 7},
+		{"let x = 1 + 2; [while (false) {}, x][1]", 3},
+		{"len([while (false) {}, for (let i = 0; i < 1; let i = i + 1) {}])", 2},
+		{"fn() { while (false) {} }()", nil},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// 内置函数返回的错误是值，在条件中为假，可以用 || 回退到默认值
+func TestErrorFallbacks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`!first(1)`, "true"},
+		{`first(1) || 5`, "5"},
+		{`let e = len(1); e || 5`, "5"},
+		{`first(1) && 5`, "ErrorObj: argument to `first` must be Array, got INTEGER"},
+		{`if (first(1)) { 1 } else { 2 }`, "2"},
+		{`let r = divmod(7, 0); if (r) { r[0] } else { -1 }`, "-1"},
+		{`let r = divmod(7, 2); r || [-1, -1]`, "[3, 1]"},
+		{`type(len(1))`, "ERROR"},
+		{`let orElse = fn(v, d) { v || d }; orElse(first(1), 5) + orElse(first([4]), 0)`, "9"},
+		{`len(1) + 1`, "ErrorObj: [line 1:8] type mismatch: ERROR + INTEGER"},
+		{`(1 + true) || 5`, "ErrorObj: [line 1:4] type mismatch: INTEGER + BOOLEAN"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEmptyIsFalsy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		empty    string // 开启 EmptyIsFalsy 时的结果
+	}{
+		{`if ([]) { 1 } else { 2 }`, "1", "2"},
+		{`if (0) { 1 } else { 2 }`, "1", "2"},
+		{`if ({}) { 1 } else { 2 }`, "1", "2"},
+		{`if ([0]) { 1 } else { 2 }`, "1", "1"},
+		{`!""`, "false", "true"},
+		{`0 || 1`, "0", "1"},
+		{`let n = 3; let total = 0; while (n && n > -5) { let total = total + n; let n = n - 1; } total`, "-4", "6"},
+		{`let f = fn(x) { if (x) { 1 } else { 2 } }; f("")`, "1", "2"},
+		{`filter([0, 1, "", "a"], fn(x) { x })`, `[0, 1, , a]`, `[1, a]`},
+	}
+	for _, tt := range tests {
+		// 两个环境交替求值，一个环境的设置不影响另一个
+		plain := object.NewEnvironment()
+		empty := object.NewEnvironment()
+		empty.SetTruthiness(object.Truthiness{EmptyIsFalsy: true})
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		if got := Eval(program, empty).Inspect(); got != tt.empty {
+			t.Errorf("EmptyIsFalsy: wrong result for %s. expected=%q, got=%q", tt.input, tt.empty, got)
+		}
+		if got := Eval(program, plain).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestForLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let sum = fn(n) { let s = 0; for (let i = 1; i < n + 1; let i = i + 1) { let s = s + i; if (i == n) { return s; } } }; sum(4)", 10},
+		{"fn() { for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (i * 3 + j == 7) { return i * 10 + j; } } } }()", 21},
+		{"let i = 5; for (let i = 0; i < 3; let i = i + 1) {} i", 5},
+		{"let sum = 0; for (let i = 1; i < 5; let i = i + 1) { let sum = sum + i; } sum", 10},
+		{"fn(n) { let total = 0; for (let i = 0; i < n; let i = i + 1) { for (let j = 0; j < n; let j = j + 1) { let total = total + 1; } } total }(3)", 9},
+		{"let f = fn() { for (let i = 0; i < 3; let i = i + 1) { if (i == 2) { return fn() { i * 100 }; } } }; f()()", 200},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testNullObject(t, testEval("for (let i = 0; i < 3; let i = i + 1) { i }"))
+	errObj, ok := testEval("for (let i = 0; i < 3; let i = i + 1) {} i").(*object.Error)
+	if !ok || errObj.Message != "identifier not found: i" {
+		t.Errorf("loop variable leaked into outer scope. got=%v", errObj)
+	}
+}
+
+func TestBreakAndContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 2) { continue; } if (i == 5) { break; } let s = s + i; } s", 8},
+		{"let n = 0; while (true) { let n = n + 1; if (n > 3) { break; } } n", 4},
+		{"let n = 0; let odd = 0; while (n < 6) { let n = n + 1; if (n == n / 2 * 2) { continue; } let odd = odd + 1; } odd", 3},
+		{"let c = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (j == 1) { break; } let c = c + 1; } } c", 3},
+		{"fn() { for (let i = 0; i < 10; let i = i + 1) { if (i == 4) { break; } } 7 }()", 7},
+		{"let i = 0; while (i < 5000) { let i = i + 1; let x = 1 + if (true) { continue; } else { 2 }; } i", 5000},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+	testNullObject(t, testEval("while (true) { break; }"))
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -139,6 +380,8 @@ func TestReturnStatements(t *testing.T) {
 		{"return 10; 9;", 10},
 		{"return 2 * 5; 9;", 10},
 		{"9; return 2 * 5; 9;", 10},
+		{"if (true) { return 1; } 2;", 1},
+		{"let x = 3; return x * 2; 99;", 6},
 	}
 	for _, tt := range tests {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
@@ -151,6 +394,8 @@ func TestErrorHandling(t *testing.T) {
 		expected string
 	}{
 		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"fn(a) { a }(1, 2)", "wrong number of arguments: want=1, got=2"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
 		{"-true", "unsupported operator: -BOOLEAN"},
 		{"true + false;", "unsupported operator: BOOLEAN + BOOLEAN"},
 		{"true + 10;", "type mismatch: BOOLEAN + INTEGER"},
@@ -159,6 +404,7 @@ func TestErrorHandling(t *testing.T) {
 		{"5; 10 + true;", "type mismatch: INTEGER + BOOLEAN"},
 		{"if (10 > 1) { if (10 > 2) { return true + false; } return 1; } 10", "unsupported operator: BOOLEAN + BOOLEAN"},
 		{"foobar", "identifier not found: foobar"},
+		{"2 ** -1", "negative exponent: -1"},
 		{`"Hello" - "World"`, "unsupported operator: STRING - STRING"},
 		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
 	}
@@ -175,6 +421,25 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1;\nlet y = 2;\nx + foobar", "ErrorObj: [line 3:5] identifier not found: foobar"},
+		{"1;\n  1 + true", "ErrorObj: [line 2:5] type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ErrorObj: [line 1:1] unsupported operator: -BOOLEAN"},
+		{"let f = fn() { missing };\nf() + 1", "ErrorObj: [line 1:16] identifier not found: missing"},
+		{"len(1)", "ErrorObj: argument to `len` not supported, got INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -227,6 +492,111 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let newAdder = fn(x) { fn(y) { x + y }; }; let addTwo = newAdder(2); addTwo(2);", 4},
+		{"let x = 1; let f = fn(x) { let x = x + 10; x }; f(5) + x;", 16},
+		{"let f = fn(a, b, c, d, e, f, g, h, i) { let j = a + i; j * h }; f(1, 2, 3, 4, 5, 6, 7, 8, 9);", 80},
+		{"let f = fn() { let a = 1; let b = 2; let c = 3; let d = 4; let e = 5; let f = 6; let g = 7; let h = 8; let i = 9; a + i }; f();", 10},
+		{"let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(100);", 100},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestMutualRecursion(t *testing.T) {
+	defs := `
+	let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+	let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+	`
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{defs + "isEven(4)", true},
+		{defs + "isOdd(4)", false},
+		{defs + "isOdd(7)", true},
+	}
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+
+	forwardTests := []string{
+		"g(); let g = fn() { 1 };",
+		"let f = fn() { g() }; f(); let g = fn() { 1 };",
+		"let x = g; let g = fn() { 1 }; x",
+	}
+	for _, input := range forwardTests {
+		errObj, ok := testEval(input).(*object.Error)
+		if !ok || errObj.Message != "identifier not found: g" {
+			t.Errorf("wrong result for %s: expected identifier not found error, got=%v", input, errObj)
+		}
+	}
+}
+
+func TestFunctionsEndingInNonExpressions(t *testing.T) {
+	tests := []string{
+		"fn() { let x = 1; }()",
+		"let f = fn() { let x = 1; }; f(); f()",
+		"fn() { if (true) { let y = 2; } }()",
+		"if (true) {}",
+		"fn() {}()",
+	}
+	for _, input := range tests {
+		testNullObject(t, testEval(input))
+	}
+}
+
+func TestRecursionDepthLimit(t *testing.T) {
+	input := `let f = fn(x) { f(x + 1) }; f(0);`
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+	if errObj.Message != "maximum recursion depth exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	// limited 把调用深度限制为 10，其他环境仍使用默认的限制
+	limited := func(input string) object.Object {
+		env := object.NewEnvironment()
+		env.SetMaxCallDepth(10)
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+	countdown := `let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; `
+	testIntegerObject(t, limited(countdown+"f(9)"), 0)
+	if _, ok := limited(countdown + "f(10)").(*object.Error); !ok {
+		t.Errorf("expected recursion past MaxCallDepth to fail")
+	}
+	testIntegerObject(t, testEval(countdown+"f(10)"), 0)
+	if _, ok := limited(`let h = {"__add__": fn(a, b) { a + b }}; h + h`).(*object.Error); !ok {
+		t.Errorf("expected recursive operator method to fail")
+	}
+}
+
+func TestSafeEvalRecoversFromPanics(t *testing.T) {
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New("let x = 1; x / 0")).ParseProgram()
+	result, err := SafeEval(program, env)
+	if err == nil {
+		t.Fatalf("expected error, got result %v", result)
+	}
+	expected := "evaluation panicked: runtime error: integer divide by zero"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, err.Error())
+	}
+
+	result, err = SafeEval(parser.New(lexer.New("x + 1")).ParseProgram(), env)
+	if err != nil {
+		t.Fatalf("unexpected error after recovery: %s", err)
+	}
+	testIntegerObject(t, result, 2)
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 	evaluated := testEval(input)
@@ -263,6 +633,13 @@ func TestBuiltinFunctions(t *testing.T) {
 		{"len(1)", "argument to `len` not supported, got INTEGER"},
 		{"len(\"one\", \"two\")", "wrong number of arguments. got=2, want=1"},
 		{"head([])", nil},
+		{"capacity([1, 2, 3])", 3},
+		{"capacity(push([1, 2], 3))", 3},
+		{"capacity(1)", "argument to `capacity` must be Array, got INTEGER"},
+		{`let base = {"a": 1}; assign(base, {"b": 2}); base["a"] + base["b"]`, 3},
+		{`assign({"a": 1}, {"a": 5})["a"]`, 5},
+		{`let proto = {"greet": fn(self) { self.name }}; assign({"name": 1}, proto).greet()`, 1},
+		{`assign({}, 1)`, "argument to `assign` must be Hash, got INTEGER"},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -282,6 +659,383 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestSortedKeysBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`sortedKeys({})`, "[]"},
+		{`sortedKeys({10: "a", -1: "b", 2: "c"})`, "[-1, 2, 10]"},
+		{`sortedKeys({"b": 1, "a": 2, "c": 3})`, "[a, b, c]"},
+		{`sortedKeys({true: 1, false: 2})`, "[false, true]"},
+		{`sortedKeys([1])`, "ErrorObj: argument to `sortedKeys` must be Hash, got ARRAY"},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 5; i++ {
+			evaluated := testEval(tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Fatalf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+			}
+		}
+	}
+
+	errObj, ok := testEval(`sortedKeys({1: 1, "a": 2})`).(*object.Error)
+	if !ok {
+		t.Fatalf("no error returned for mixed key types")
+	}
+	if !strings.HasPrefix(errObj.Message, "keys of `sortedKeys` argument must share one type") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestToJSONBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`toJSON({"items": [1, 2], "meta": {"n": 2}})`, `{"items":[1,2],"meta":{"n":2}}`},
+		{`toJSON([1, "a", true, 0.5])`, `[1,"a",true,0.5]`},
+		{`toJSON({1: "a"})`, "ErrorObj: JSON object keys must be strings, got INTEGER"},
+		{`toJSON(fn() {})`, "ErrorObj: cannot serialize FUNCTION to JSON"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestDivmodBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`divmod(7, 2)`, "[3, 1]"},
+		{`divmod(-5, 4)`, "[-1, -1]"},
+		{`divmod(5, -4)`, "[-1, 1]"},
+		{`divmod(-5, -4)`, "[1, -1]"},
+		{`let r = divmod(-17, 5); r[0] * 5 + r[1]`, "-17"},
+		{`divmod(-5, 4)[0] == -5 / 4`, "true"},
+		{`divmod(1, 0)`, "ErrorObj: division by zero"},
+		{`divmod("a", 1)`, "ErrorObj: argument to `divmod` must be Integer, got STRING"},
+		{`divmod(1)`, "ErrorObj: wrong number of arguments. got=1, want=2"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFlattenBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`flatten([[1, 2], [3]])`, "[1, 2, 3]"},
+		{`flatten([1, [2, [3, [4]]], "a"])`, `[1, 2, [3, [4]], a]`},
+		{`flatten([])`, "[]"},
+		{`flattenDeep([1, [2, [3, [4]]], "a"])`, "[1, 2, 3, 4, a]"},
+		{`flattenDeep([[], [[]], [{"k": [1]}]])`, "[{k: [1]}]"},
+		{`let a = [1, 2]; let b = [a, a]; flattenDeep([b, 3])`, "[1, 2, 1, 2, 3]"},
+		{`let a = [1]; a[0] = a; flattenDeep(a)`, "ErrorObj: cannot flatten cyclic ARRAY"},
+		{`flatten(1)`, "ErrorObj: argument to `flatten` must be Array, got INTEGER"},
+		{`flattenDeep({})`, "ErrorObj: argument to `flattenDeep` must be Array, got HASH"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestChrBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`chr(65)`, "A"},
+		{`chr(29492)`, "猴"},
+		{`len(chr(128053))`, "4"},
+		{`chr(55296)`, "ErrorObj: argument to `chr` is not a valid code point: 55296"},
+		{`chr(1114112)`, "ErrorObj: argument to `chr` is not a valid code point: 1114112"},
+		{`chr(-1)`, "ErrorObj: argument to `chr` is not a valid code point: -1"},
+		{`chr("A")`, "ErrorObj: argument to `chr` must be Integer, got STRING"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestReprBuiltin(t *testing.T) {
+	// str 和 Inspect 的输出总是一致，repr 用字面量的写法区分类型
+	tests := []struct {
+		input   string
+		inspect string
+		repr    string
+	}{
+		{`1`, `1`, `1`},
+		{`"1"`, `1`, `"1"`},
+		{`2.0`, `2.0`, `2.0`},
+		{`"2.0"`, `2.0`, `"2.0"`},
+		{`"true"`, `true`, `"true"`},
+		{`bytes("1")`, `b"1"`, `b"1"`},
+		{`"a" + chr(10)`, "a\n", `"a\n"`},
+		{`"a\\b\t\"c\""`, "a\\b\t\"c\"", `"a\\b\t\"c\""`},
+		{`1.5`, `1.5`, `1.5`},
+		{`true`, `true`, `true`},
+		{`[1, "1", [true]]`, `[1, 1, [true]]`, `[1, "1", [true]]`},
+		{`[1, 1.0, "1"]`, `[1, 1.0, 1]`, `[1, 1.0, "1"]`},
+		{`{"k": "v"}`, `{k: v}`, `{"k": "v"}`},
+		{`push(["x"], ["y"])`, `[x, [y]]`, `["x", ["y"]]`},
+	}
+	for _, tt := range tests {
+		value := testEval(tt.input)
+		if value.Inspect() != tt.inspect {
+			t.Errorf("wrong inspect for %s. expected=%q, got=%q", tt.input, tt.inspect, value.Inspect())
+		}
+		str := testEval("str(" + tt.input + ")")
+		if str.Inspect() != tt.inspect {
+			t.Errorf("wrong str for %s. expected=%q, got=%q", tt.input, tt.inspect, str.Inspect())
+		}
+		repr := testEval("repr(" + tt.input + ")")
+		if repr.Inspect() != tt.repr {
+			t.Errorf("wrong repr for %s. expected=%q, got=%q", tt.input, tt.repr, repr.Inspect())
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map([], fn(x) { x })`, "[]"},
+		{`map(["a", "bc"], len)`, "[1, 2]"},
+		{`let k = 10; map([1, 2], fn(x) { return x + k; })`, "[11, 12]"},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, "[3, 4]"},
+		{`filter([1, 2, 3], fn(x) { if (x != 2) { x } })`, "[1, 3]"},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, "10"},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, "5"},
+		{`reduce(map([1, 2, 3], fn(x) { x * x }), 0, fn(a, b) { a + b })`, "14"},
+		{`map([1, "a"], fn(x) { x - 1 })`, "ErrorObj: [line 1:25] type mismatch: STRING - INTEGER"},
+		{`map([1], 1)`, "ErrorObj: calling INTEGER is not supported"},
+		{`map(1, fn(x) { x })`, "ErrorObj: argument to `map` must be Array, got INTEGER"},
+		{`reduce([1], fn(a, b) { a })`, "ErrorObj: wrong number of arguments. got=2, want=3"},
+		{`sortBy(["ccc", "a", "bb", "d"], len)`, "[a, d, bb, ccc]"},
+		{`let people = [{"name": "al", "age": 30}, {"name": "bo", "age": 25}, {"name": "cy", "age": 30}];
+		  map(sortBy(people, fn(p) { p["age"] }), fn(p) { p["name"] })`, "[bo, al, cy]"},
+		{`sortBy(["b", "a"], fn(s) { s })`, "[a, b]"},
+		{`sortBy([], fn(x) { x.y })`, "[]"},
+		{`sortBy([1, 2], fn(x) { [x] })`, "ErrorObj: key of `sortBy` must be Integer or String, got ARRAY"},
+		{`sortBy([1, 2], fn(x) { if (x == 1) { 1 } else { "a" } })`, "ErrorObj: keys of `sortBy` must share one type, got INTEGER and STRING"},
+		{`sortBy([1], fn(x) { x + true })`, "ErrorObj: [line 1:23] type mismatch: INTEGER + BOOLEAN"},
+		{`let g = groupBy([1, 2, 3, 4, 5], fn(x) { x - x / 2 * 2 == 0 }); [g[false], g[true]]`, "[[1, 3, 5], [2, 4]]"},
+		{`groupBy([], fn(x) { x })`, "{}"},
+		{`let c = countBy(split("a bb cc d eee", " "), len); [c[1], c[2], c[3], c[4]]`, "[2, 2, 1, null]"},
+		{`countBy(["x", "x"], fn(w) { w })`, "{x: 2}"},
+		{`groupBy([1], fn(x) { [x] })`, "ErrorObj: unusable as hash key: ARRAY"},
+		{`countBy(1, len)`, "ErrorObj: argument to `countBy` must be Array, got INTEGER"},
+		{`iterate(fn(x) { x * 2 }, 1, 5)`, "[1, 2, 4, 8, 16]"},
+		{`iterate(fn(n) { if (n / 2 * 2 == n) { n / 2 } else { 3 * n + 1 } }, 6, 9)`, "[6, 3, 10, 5, 16, 8, 4, 2, 1]"},
+		{`iterate(fn(x) { x }, "a", 1)`, "[a]"},
+		{`iterate(first, [[[1]]], 3)`, "[[[[1]]], [[1]], [1]]"},
+		{`iterate(1, 1, 2)`, "ErrorObj: argument to `iterate` must be Function, got INTEGER"},
+		{`iterate(fn(x) { x }, 1, 0)`, "ErrorObj: count argument to `iterate` must be positive, got 0"},
+		{`iterate(fn(x) { x }, 1, "3")`, "ErrorObj: argument to `iterate` must be Integer, got STRING"},
+		{`keys({"b": 1, 10: 2, true: 3, "a": 4, 2: 5, false: 6})`, "[false, true, 2, 10, a, b]"},
+		{`values({"b": 1, 10: 2, true: 3, "a": 4, 2: 5, false: 6})`, "[6, 3, 5, 2, 4, 1]"},
+		{`let h = {1: "one", "1": "string one"}; map(keys(h), fn(k) { h[k] })`, "[one, string one]"},
+		{`map(keys({1: 0, true: 0}), type)`, "[BOOLEAN, INTEGER]"},
+		{`{"b": 1, 10: 2, true: 3}`, "{true: 3, 10: 2, b: 1}"},
+		{`keys({})`, "[]"},
+		{`values([1])`, "ErrorObj: argument to `values` must be Hash, got ARRAY"},
+		{`string(bytes("round trip"))`, "round trip"},
+		{`bytes([109, 111, 110, 107, 101, 121])`, `b"monkey"`},
+		{`bytes(["a"])`, "ErrorObj: elements of `bytes` argument must be Integer, got STRING"},
+		{`bytes([-1])`, "ErrorObj: byte value out of range: -1"},
+		{`bytes(true)`, "ErrorObj: argument to `bytes` must be String or Array, got BOOLEAN"},
+		{`string("a")`, "ErrorObj: argument to `string` must be Bytes, got STRING"},
+		{`string(bytes("ab"), 1)`, "ErrorObj: wrong number of arguments. got=2, want=1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestRangeBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`range(5)`, "[0, 1, 2, 3, 4]"},
+		{`range(0, 5)`, "[0, 1, 2, 3, 4]"},
+		{`range(2, 5)`, "[2, 3, 4]"},
+		{`range(0, 10, 2)`, "[0, 2, 4, 6, 8]"},
+		{`range(0, 9, 3)`, "[0, 3, 6]"},
+		{`range(5, 0, -2)`, "[5, 3, 1]"},
+		{`range(0)`, "[]"},
+		{`range(3, 3)`, "[]"},
+		{`range(3, 3, -1)`, "[]"},
+		{`range(5, 0)`, "[]"},
+		{`range(0, 5, -1)`, "[]"},
+		{`range(-3)`, "[]"},
+		{`range(9223372036854775806, 9223372036854775807, 5)`, "[9223372036854775806]"},
+		{`range(0, 5, 0)`, "ErrorObj: step argument to `range` must not be zero"},
+		{`range("5")`, "ErrorObj: argument to `range` must be Integer, got STRING"},
+		{`range()`, "ErrorObj: wrong number of arguments. got=0, want=1..3"},
+		{`range(1, 2, 3, 4)`, "ErrorObj: wrong number of arguments. got=4, want=1..3"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// stepClock 将 object.Clock 替换为每次调用前进 step 的时钟，返回恢复函数
+func stepClock(step time.Duration) func() {
+	clock := object.Clock
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	object.Clock = func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+	return func() { object.Clock = clock }
+}
+
+func TestBenchBuiltin(t *testing.T) {
+	defer stepClock(1500 * time.Microsecond)()
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let r = bench(fn() { 1 + 2 }); [r["result"], r["ms"]]`, "[3, 1.5]"},
+		{`bench(fn() { bench(fn() { 0 })["ms"] })["ms"]`, "4.5"},
+		{`bench(fn() { bench(fn() { 0 })["ms"] })["result"]`, "1.5"},
+		{`bench(fn() { first(1) })`, "ErrorObj: argument to `first` must be Array, got INTEGER"},
+		{`bench(1)`, "ErrorObj: calling INTEGER is not supported"},
+		{`bench()`, "ErrorObj: wrong number of arguments. got=0, want=1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestConversionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`str(123) + "!"`, "123!"},
+		{`str("abc")`, "abc"},
+		{`str(1.5)`, "1.5"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`len(str(true))`, "4"},
+		{`int("42")`, "42"},
+		{`int("-7") + 1`, "-6"},
+		{`int(7)`, "7"},
+		{`int(2.9)`, "2"},
+		{`int(-2.9)`, "-2"},
+		{`int(str(99)) + 1`, "100"},
+		{`int("nope")`, "ErrorObj: argument to `int` is not a valid integer: \"nope\""},
+		{`int(" 1")`, "ErrorObj: argument to `int` is not a valid integer: \" 1\""},
+		{`int("99999999999999999999")`, "ErrorObj: argument to `int` is not a valid integer: \"99999999999999999999\""},
+		{`int(10.0 ** 30)`, "ErrorObj: argument to `int` is out of integer range: 1e+30"},
+		{`int([])`, "ErrorObj: argument to `int` must be String, Float or Integer, got ARRAY"},
+		{`str()`, "ErrorObj: wrong number of arguments. got=0, want=1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestBuiltinOptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`sort([3, 1, 2])`, "[1, 2, 3]"},
+		{`sort([3, 1, 2], {"reverse": true})`, "[3, 2, 1]"},
+		{`sort([3, 1, 2], {"reverse": false})`, "[1, 2, 3]"},
+		{`sort(["b", "c", "a"], {})`, "[a, b, c]"},
+		{`sort([2.5, -1.0])`, "[-1.0, 2.5]"},
+		{`sort([true, false])`, "[false, true]"},
+		{`let a = [2, 1]; sort(a); a`, "[2, 1]"},
+		{`sort([1, "a"])`, "ErrorObj: elements of `sort` argument must share one type, got INTEGER and STRING"},
+		{`sort([[1]])`, "ErrorObj: argument to `sort` cannot be ordered, got elements of type ARRAY"},
+		{`sort([1], {"reverse": 1})`, "ErrorObj: option `reverse` of `sort` must be Boolean, got INTEGER"},
+		{`sort([1], {"revers": true})`, "ErrorObj: unknown option \"revers\" for `sort`"},
+		{`sort([1], len, {}, {})`, "ErrorObj: wrong number of arguments. got=3, want=1..2"},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, "[3, 2, 1]"},
+		{`sort(["bb", "a", "ccc", "d"], fn(a, b) { len(a) < len(b) })`, "[a, d, bb, ccc]"},
+		{`sort([[2], [1, 1], [3]], fn(a, b) { a[0] < b[0] })`, "[[1, 1], [2], [3]]"},
+		{`sort([1, "a"], fn(a, b) { false })`, "[1, a]"},
+		{`sort([1, 2, 3], fn(a, b) { a < b }, {"reverse": true})`, "[3, 2, 1]"},
+		{`let a = [2, 1]; sort(a, fn(x, y) { x < y }); a`, "[2, 1]"},
+		{`sort([1], {}, {})`, "ErrorObj: argument to `sort` must be Function, got HASH"},
+		{`sort([1, 2], fn(a, b) { 1 })`, "ErrorObj: comparator of `sort` must return Boolean, got INTEGER"},
+		{`sort([1, "a"], fn(a, b) { a < b })`, "ErrorObj: [line 1:29] type mismatch: STRING < INTEGER"},
+		{`split("a,b,c", ",")`, "[a, b, c]"},
+		{`split("a,b,c", ",", {"limit": 2})`, "[a, b,c]"},
+		{`split("a,b,c", ",", {"limit": 1})`, "[a,b,c]"},
+		{`split("猴子", "")`, "[猴, 子]"},
+		{`split("", ",")`, "[]"},
+		{`split("a,b", ",", {"limit": 0})`, "ErrorObj: option `limit` of `split` must be positive, got 0"},
+		{`split("a,b", ",", {"limit": "2"})`, "ErrorObj: option `limit` of `split` must be Integer, got STRING"},
+		{`split("a,b", ",", {1: 2})`, "ErrorObj: unknown option 1 for `split`"},
+		{`split("a,b")`, "ErrorObj: wrong number of arguments. got=1, want=2"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTypeBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`type(1)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type([])`, "ARRAY"},
+		{`type({"a": 1})`, "HASH"},
+		{`type({"__type__": "Point", "x": 1})`, "Point"},
+		{`type({"__type__": 1})`, "HASH"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("obj is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("wrong type name. expected=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -297,6 +1051,42 @@ func TestArrayLiterals(t *testing.T) {
 	testIntegerObject(t, result.Elements[2], 6)
 }
 
+func TestReturnInsideLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn() { [if (true) { return 5; }, 2] }()", 5},
+		{"fn() { [1, if (true) { return 5; }][0] }()", 5},
+		{`fn() { {"a": if (true) { return 5; }} }()`, 5},
+		{"fn() { len(if (true) { return 5; }) }()", 5},
+		{"fn() { 1 + if (true) { return 5; } }()", 5},
+		{"fn() { let x = if (true) { return 5; }; 10 }()", 5},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestArrayLiteralErrorShortCircuits(t *testing.T) {
+	env := object.NewEnvironment()
+	Eval(parser.New(lexer.New(`let h = {};`)).ParseProgram(), env)
+	evaluated := Eval(parser.New(lexer.New(`[1 + true, assign(h, {"x": 1})]`)).ParseProgram(), env)
+
+	expected := "type mismatch: INTEGER + BOOLEAN"
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+	h, _ := env.Get("h")
+	if pairs := h.(*object.Hash).Pairs; len(pairs) != 0 {
+		t.Errorf("elements after the error were evaluated. h=%s", h.Inspect())
+	}
+}
+
 func TestArrayIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -392,6 +1182,80 @@ func TestHashLiterals(t *testing.T) {
 	}
 }
 
+func TestHashLiteralErrorOrder(t *testing.T) {
+	input := `{"c": 1 + true, "a": -true, "b": "a" - "b"}`
+	expected := "unsupported operator: -BOOLEAN"
+	for i := 0; i < 20; i++ {
+		errObj, ok := testEval(input).(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned")
+		}
+		if errObj.Message != expected {
+			t.Fatalf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1, 2, 3]; a[0] = 99; a`, "[99, 2, 3]"},
+		{`let a = [1, 2, 3]; a[2] = a[1] * 10`, "20"},
+		{`let h = {"x": 1}; h["y"] = 2; h["x"] = h["x"] + 10; [h["x"], h["y"]]`, "[11, 2]"},
+		{`let m = [[1, 2], [3]]; m[0][1] = m[1][0] = 7; m`, "[[1, 7], [7]]"},
+		{`let a = [0]; let f = fn(arr) { arr[0] = 5; }; f(a); a[0]`, "5"},
+		{`let a = [0, 0, 0]; for (let i = 0; i < 3; let i = i + 1) { a[i] = i * i; } a`, "[0, 1, 4]"},
+		{`[1, 2][2] = 3`, "ErrorObj: index out of range: 2 (length 2)"},
+		{`[1][-1] = 3`, "ErrorObj: index out of range: -1 (length 1)"},
+		{`[1]["a"] = 3`, "ErrorObj: array index must be INTEGER, got STRING"},
+		{`{}[fn() {}] = 1`, "ErrorObj: unusable as hash key: FUNCTION"},
+		{`let s = "ab"; s[0] = 1`, "ErrorObj: index assignment not supported: STRING"},
+		{`[1][0] = missing`, "ErrorObj: [line 1:10] identifier not found: missing"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStringIndexAndSlice(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"hello"[0]`, "h"},
+		{`"hello"[4]`, "o"},
+		{`"hello"[5]`, "null"},
+		{`"hello"[-1]`, "null"},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:2]`, "he"},
+		{`"hello"[3:]`, "lo"},
+		{`"hello"[:]`, "hello"},
+		{`"hello"[-3:-1]`, "ll"},
+		{`"hello"[-99:99]`, "hello"},
+		{`"hello"[4:1]`, ""},
+		{`[1, 2, 3, 4][1:3]`, "[2, 3]"},
+		{`[1, 2, 3][-2:]`, "[2, 3]"},
+		{`let a = [1, 2]; let b = a[:]; b[0] = 9; a`, "[1, 2]"},
+		{`"猴子"[0:3]`, "猴"},
+		{`"猴子"[0]`, "ErrorObj: string index 0 splits a UTF-8 character"},
+		{`"猴子"[1:]`, "ErrorObj: string slice [1:6] splits a UTF-8 character"},
+		{`[1]["a":]`, "ErrorObj: slice bound must be INTEGER, got STRING"},
+		{`{}[0:1]`, "ErrorObj: slice operator not supported: HASH"},
+		{`[1][missing:]`, "ErrorObj: [line 1:5] identifier not found: missing"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -416,3 +1280,85 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestMethodCalls(t *testing.T) {
+	person := `
+	let person = {
+		"name": "Monkey",
+		"greet": fn(self, greeting) { greeting + ", " + self.name },
+		"rename": fn(self, name) { {"name": name, "greet": self.greet} }
+	};
+	`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{person + `person.name`, "Monkey"},
+		{person + `person.greet("Hello")`, "Hello, Monkey"},
+		{person + `person.rename("Gorilla").greet("Hi")`, "Hi, Gorilla"},
+		{person + `person["greet"](person, "Hey")`, "Hey, Monkey"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("obj is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("str has wrong value. expected=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+
+	evaluated := testEval(`let x = 1; x.foo()`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "index operator not supported: INTEGER" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestHashOperatorMethods(t *testing.T) {
+	vector := `
+	let vec = fn(x, y) {
+		{
+			"x": x,
+			"y": y,
+			"__add__": fn(a, b) { vec(a["x"] + b["x"], a["y"] + b["y"]) },
+			"__eq__": fn(a, b) { a["x"] == b["x"] }
+		}
+	};
+	`
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{vector + `let v = vec(1, 2) + vec(3, 4); v["x"] * 10 + v["y"]`, 46},
+		{vector + `let v = vec(1, 2) + vec(3, 4) + vec(5, 6); v["x"] * 10 + v["y"]`, 102},
+		{vector + `vec(1, 2) == vec(1, 5)`, true},
+		{vector + `vec(1, 2) == vec(3, 2)`, false},
+		{vector + `vec(1, 2) != vec(3, 2)`, true},
+		{`{"a": 1} == {"a": 1}`, false},
+		{`{"__add__": 1} + {}`, "calling INTEGER is not supported"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
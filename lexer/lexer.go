@@ -1,26 +1,110 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
 	"monkey/token"
 )
 
+// readerChunkSize 从 reader 增量读取输入时每次读取的字节数
+const readerChunkSize = 512
+
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+	line         int // 当前字符所在行
+	column       int // 当前字符所在列
+
+	reader io.Reader // 非空时从 reader 增量读取输入，input 只保留当前 token 起的窗口
+	buf    []byte    // 从 reader 读取的缓冲区
+	offset int       // input 窗口起点在整个输入中的字节偏移
+	err    error     // 从 reader 读取时遇到的第一个非 EOF 错误
+
+	errors []string // 词法错误，如未知的转义序列
 }
 
 // New 创建lexer对象
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
+	l.readChar()
+	return l
+}
+
+// NewReader 创建从 reader 增量读取输入的lexer对象，适合较大的输入
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{reader: r, buf: make([]byte, readerChunkSize), line: 1}
 	l.readChar()
 	return l
 }
 
-// readChar 读取下一个字符
+// Reset 用新的输入重新初始化lexer，便于复用同一个对象
+func (l *Lexer) Reset(input string) {
+	*l = Lexer{input: input, line: 1}
+	l.readChar()
+}
+
+// Position 返回当前字符在输入中的字节偏移
+func (l *Lexer) Position() int {
+	return l.offset + l.position
+}
+
+// Errors 返回目前为止遇到的词法错误
+func (l *Lexer) Errors() []string {
+	return l.errors
+}
+
+// Err 返回从 reader 读取输入时遇到的错误，读取错误会像输入结束一样终止词法分析
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// fill 从 reader 读取更多输入追加到窗口末尾，没有更多输入时返回 false
+func (l *Lexer) fill() bool {
+	for l.reader != nil {
+		n, err := l.reader.Read(l.buf)
+		if n > 0 {
+			l.input += string(l.buf[:n])
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				l.err = err
+			}
+			l.reader = nil
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// discard 丢弃窗口中当前字符之前已经处理过的输入，只在 token 之间调用
+func (l *Lexer) discard() {
+	if l.buf == nil || l.position == 0 {
+		return
+	}
+	// 输入结束后 position 会越过窗口末尾
+	n := min(l.position, len(l.input))
+	l.input = l.input[n:]
+	l.offset += n
+	l.readPosition -= n
+	l.position -= n
+}
+
+// readChar 读取下一个字符，\n、\r\n 和单独的 \r 都算作一次换行
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
+	if l.ch == '\n' || l.ch == '\r' && l.peekChar() != '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	if l.readPosition >= len(l.input) && !l.fill() {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPosition]
@@ -31,7 +115,7 @@ func (l *Lexer) readChar() {
 
 // peekChar 读取下一个字符，但不移动指针
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	if l.readPosition >= len(l.input) && !l.fill() {
 		return 0
 	} else {
 		return l.input[l.readPosition]
@@ -40,9 +124,22 @@ func (l *Lexer) peekChar() byte {
 
 // NextToken 读取下一个token
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
+	l.discard()
 	l.skipWhitespace()
+	for l.ch == '/' && l.peekChar() == '*' {
+		l.skipBlockComment()
+		l.skipWhitespace()
+	}
+
+	pos := token.Position{Line: l.line, Column: l.column}
+	tok := l.readToken()
+	tok.Pos = pos
+	return tok
+}
+
+// readToken 从当前字符开始读取一个token
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -53,13 +150,38 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = token.New(token.ASSIGN, l.ch)
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			ch := l.ch
+			l.readChar()
+			tok = token.NewString(token.AND, string(ch)+string(l.ch))
+		} else {
+			tok = token.New(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			ch := l.ch
+			l.readChar()
+			tok = token.NewString(token.OR, string(ch)+string(l.ch))
+		} else {
+			tok = token.New(token.ILLEGAL, l.ch)
+		}
 	case '+':
 		tok = token.New(token.PLUS, l.ch)
 	case '-':
 		tok = token.New(token.MINUS, l.ch)
 	case '*':
-		tok = token.New(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			ch := l.ch
+			l.readChar()
+			tok = token.NewString(token.POW, string(ch)+string(l.ch))
+		} else {
+			tok = token.New(token.ASTERISK, l.ch)
+		}
 	case '/':
+		if l.peekChar() == '/' {
+			return token.NewString(token.COMMENT, l.readComment())
+		}
 		tok = token.New(token.SLASH, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
@@ -79,6 +201,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.New(token.COLON, l.ch)
 	case ',':
 		tok = token.New(token.COMMA, l.ch)
+	case '.':
+		tok = token.New(token.DOT, l.ch)
 	case '(':
 		tok = token.New(token.LPAREN, l.ch)
 	case ')':
@@ -100,7 +224,7 @@ func (l *Lexer) NextToken() token.Token {
 			literal := l.readIdentifier()
 			return token.NewString(token.LookupIdent(literal), literal)
 		} else if isDigit(l.ch) {
-			return token.NewString(token.INT, l.readNumber())
+			return token.NewString(l.readNumber())
 		} else {
 			tok = token.New(token.ILLEGAL, l.ch)
 		}
@@ -119,6 +243,31 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// skipBlockComment 跳过 /* */ 块注释，块注释不能嵌套，没有结束标记时记录错误
+func (l *Lexer) skipBlockComment() {
+	pos := token.Position{Line: l.line, Column: l.column}
+	l.readChar()
+	l.readChar()
+	for l.ch != '*' || l.peekChar() != '/' {
+		if l.ch == 0 {
+			l.errors = append(l.errors, fmt.Sprintf("unterminated block comment starting at %s", pos))
+			return
+		}
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+}
+
+// readComment 读取从 // 到行尾（不含换行符）的注释
+func (l *Lexer) readComment() string {
+	position := l.position
+	for l.ch != '\n' && l.ch != '\r' && l.ch != 0 {
+		l.readChar()
+	}
+	return l.input[position:l.position]
+}
+
 // skipWhitespace 跳过空白字符
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
@@ -126,13 +275,55 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-// readNumber 读取数字字符
-func (l *Lexer) readNumber() string {
+// digitClasses 整数前缀对应的数字字符类
+var digitClasses = map[byte]func(byte) bool{
+	'x': isHexDigit,
+	'X': isHexDigit,
+	'o': isOctalDigit,
+	'O': isOctalDigit,
+	'b': isBinaryDigit,
+	'B': isBinaryDigit,
+}
+
+// readNumber 读取数字字符，包含一个后跟数字的小数点时为浮点数，
+// 以 0x、0o 或 0b 开头时按对应进制读取整数。数字之间可以用单个下划线分隔，
+// 下划线出现在开头、末尾或连续出现时记录错误
+func (l *Lexer) readNumber() (token.TypeToken, string) {
+	pos := token.Position{Line: l.line, Column: l.column}
 	position := l.position
-	for isDigit(l.ch) {
+	var tokenType token.TypeToken = token.INT
+	var valid bool
+	if isDigitClass, ok := digitClasses[l.peekChar()]; ok && l.ch == '0' {
+		l.readChar()
 		l.readChar()
+		valid = l.readDigits(isDigitClass)
+	} else {
+		valid = l.readDigits(isDigit)
+		if l.ch == '.' && isDigit(l.peekChar()) {
+			tokenType = token.FLOAT
+			l.readChar()
+			valid = l.readDigits(isDigit) && valid
+		}
 	}
-	return l.input[position:l.position]
+	literal := l.input[position:l.position]
+	if !valid {
+		l.errors = append(l.errors, fmt.Sprintf("invalid digit separator in %s at %s", literal, pos))
+	}
+	return tokenType, literal
+}
+
+// readDigits 读取一串数字字符和下划线，返回下划线是否都位于两个数字之间
+func (l *Lexer) readDigits(isDigitClass func(byte) bool) bool {
+	valid := true
+	var previous byte
+	for isDigitClass(l.ch) || l.ch == '_' {
+		if l.ch == '_' && (previous == 0 || previous == '_') {
+			valid = false
+		}
+		previous = l.ch
+		l.readChar()
+	}
+	return valid && previous != '_'
 }
 
 // isLetter 判断一个字节是否为字母字符
@@ -145,19 +336,59 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// isHexDigit 判断一个字节是否为十六进制数字字符
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// isOctalDigit 判断一个字节是否为八进制数字字符
+func isOctalDigit(ch byte) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+// isBinaryDigit 判断一个字节是否为二进制数字字符
+func isBinaryDigit(ch byte) bool {
+	return ch == '0' || ch == '1'
+}
+
 // isIdentContinue 判断一个字节是否为标识符后续字符：字母、数字或下划线
 func isIdentContinue(ch byte) bool {
 	return isLetter(ch) || isDigit(ch)
 }
 
-// readString 读取字符串字符
+// escapes 字符串字面量中 \ 之后的字符对应的转义结果
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+	'0':  0,
+}
+
+// readString 读取字符串字面量并处理转义序列，未知的转义序列记录错误后原样保留
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var out strings.Builder
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case '"', 0:
+			return out.String()
+		case '\\':
+			pos := token.Position{Line: l.line, Column: l.column}
+			l.readChar()
+			if l.ch == 0 {
+				return out.String()
+			}
+			if decoded, ok := escapes[l.ch]; ok {
+				out.WriteByte(decoded)
+				continue
+			}
+			l.errors = append(l.errors, fmt.Sprintf("unknown escape sequence \\%c at %s", l.ch, pos))
+			out.WriteByte('\\')
+			out.WriteByte(l.ch)
+		default:
+			out.WriteByte(l.ch)
 		}
 	}
-	return l.input[position:l.position]
 }
@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"monkey/token"
 )
@@ -14,7 +18,7 @@ func TestNextToken(t *testing.T) {
 			x + y;
 	};
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 	if (5 < 10) {
 		return true;
@@ -27,6 +31,7 @@ func TestNextToken(t *testing.T) {
 	"foo bar";
 	[1, 2];
 	{"foo": "bar"};
+	obj.name;
 	`
 	tests := []struct {
 		expectedType    token.TypeToken
@@ -121,6 +126,10 @@ func TestNextToken(t *testing.T) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "obj"},
+		{token.DOT, "."},
+		{token.IDENT, "name"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 	l := New(input)
@@ -203,3 +212,351 @@ func TestIdent(t *testing.T) {
 		}
 	}
 }
+
+func TestNextTokenFullTokenSet(t *testing.T) {
+	input := `fn let true false if else return
+	ident "str" 42
+	= + - ! * ** / < > == != && || , ; : . ( ) { } [ ] @ & |`
+
+	tests := []struct {
+		expectedType    token.TypeToken
+		expectedLiteral string
+	}{
+		{token.FUNCTION, "fn"},
+		{token.LET, "let"},
+		{token.TRUE, "true"},
+		{token.FALSE, "false"},
+		{token.IF, "if"},
+		{token.ELSE, "else"},
+		{token.RETURN, "return"},
+		{token.IDENT, "ident"},
+		{token.STRING, "str"},
+		{token.INT, "42"},
+		{token.ASSIGN, "="},
+		{token.PLUS, "+"},
+		{token.MINUS, "-"},
+		{token.BANG, "!"},
+		{token.ASTERISK, "*"},
+		{token.POW, "**"},
+		{token.SLASH, "/"},
+		{token.LT, "<"},
+		{token.GT, ">"},
+		{token.EQ, "=="},
+		{token.NOT_EQ, "!="},
+		{token.AND, "&&"},
+		{token.OR, "||"},
+		{token.COMMA, ","},
+		{token.SEMICOLON, ";"},
+		{token.COLON, ":"},
+		{token.DOT, "."},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.RBRACKET, "]"},
+		{token.ILLEGAL, "@"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong, expected=%q got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestFloatNumbers(t *testing.T) {
+	input := `3.14 0.5 10 1.foo 5.`
+	expected := []token.Token{
+		{Type: token.FLOAT, Literal: "3.14"},
+		{Type: token.FLOAT, Literal: "0.5"},
+		{Type: token.INT, Literal: "10"},
+		{Type: token.INT, Literal: "1"},
+		{Type: token.DOT, Literal: "."},
+		{Type: token.IDENT, Literal: "foo"},
+		{Type: token.INT, Literal: "5"},
+		{Type: token.DOT, Literal: "."},
+		{Type: token.EOF, Literal: ""},
+	}
+	l := New(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want.Type || tok.Literal != want.Literal {
+			t.Fatalf("tokens[%d] wrong. expected=%s, got=%s", i, want, tok)
+		}
+	}
+}
+
+func TestPrefixedIntegers(t *testing.T) {
+	input := `0xFF 0Xab 0o17 0b1010 0x 0b12 0`
+	expected := []token.Token{
+		{Type: token.INT, Literal: "0xFF"},
+		{Type: token.INT, Literal: "0Xab"},
+		{Type: token.INT, Literal: "0o17"},
+		{Type: token.INT, Literal: "0b1010"},
+		{Type: token.INT, Literal: "0x"},
+		{Type: token.INT, Literal: "0b1"},
+		{Type: token.INT, Literal: "2"},
+		{Type: token.INT, Literal: "0"},
+		{Type: token.EOF, Literal: ""},
+	}
+	l := New(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want.Type || tok.Literal != want.Literal {
+			t.Fatalf("tokens[%d] wrong. expected=%s, got=%s", i, want, tok)
+		}
+	}
+}
+
+func TestDigitSeparators(t *testing.T) {
+	input := "1_000 0xFF_FF 1_000.000_1 1_ 1__0 0x_1 1_.5 _1"
+	expected := []token.Token{
+		{Type: token.INT, Literal: "1_000"},
+		{Type: token.INT, Literal: "0xFF_FF"},
+		{Type: token.FLOAT, Literal: "1_000.000_1"},
+		{Type: token.INT, Literal: "1_"},
+		{Type: token.INT, Literal: "1__0"},
+		{Type: token.INT, Literal: "0x_1"},
+		{Type: token.FLOAT, Literal: "1_.5"},
+		// 以下划线开头的是标识符而不是数字
+		{Type: token.IDENT, Literal: "_1"},
+		{Type: token.EOF, Literal: ""},
+	}
+	l := New(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want.Type || tok.Literal != want.Literal {
+			t.Fatalf("tokens[%d] wrong. expected=%s, got=%s", i, want, tok)
+		}
+	}
+	errors := []string{
+		"invalid digit separator in 1_ at 1:27",
+		"invalid digit separator in 1__0 at 1:30",
+		"invalid digit separator in 0x_1 at 1:35",
+		"invalid digit separator in 1_.5 at 1:40",
+	}
+	if len(l.Errors()) != len(errors) {
+		t.Fatalf("wrong errors. got=%q", l.Errors())
+	}
+	for i, msg := range errors {
+		if l.Errors()[i] != msg {
+			t.Errorf("errors[%d] wrong. got=%q, want=%q", i, l.Errors()[i], msg)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{"let x = 5;", []token.Token{
+			{Type: token.LET, Literal: "let"},
+			{Type: token.IDENT, Literal: "x"},
+			{Type: token.ASSIGN, Literal: "="},
+			{Type: token.INT, Literal: "5"},
+			{Type: token.SEMICOLON, Literal: ";"},
+			{Type: token.EOF, Literal: ""},
+		}},
+		{`"a" + b`, []token.Token{
+			{Type: token.STRING, Literal: "a"},
+			{Type: token.PLUS, Literal: "+"},
+			{Type: token.IDENT, Literal: "b"},
+			{Type: token.EOF, Literal: ""},
+		}},
+	}
+	l := New("")
+	for _, in := range inputs {
+		l.Reset(in.input)
+		for i, want := range in.expected {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Fatalf("%q: tokens[%d] wrong. expected=%s, got=%s", in.input, i, want, tok)
+			}
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	l := New("let x")
+	if l.Position() != 0 {
+		t.Fatalf("initial position wrong. got=%d", l.Position())
+	}
+	l.NextToken()
+	if l.Position() != 3 {
+		t.Errorf("position after let wrong. expected=3, got=%d", l.Position())
+	}
+	l.NextToken()
+	if l.Position() != 5 {
+		t.Errorf("position at end wrong. expected=5, got=%d", l.Position())
+	}
+	l.Reset("x")
+	if l.Position() != 0 {
+		t.Errorf("position after Reset wrong. got=%d", l.Position())
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	for name, newline := range map[string]string{"LF": "\n", "CRLF": "\r\n", "CR": "\r"} {
+		input := strings.Join([]string{"let a = 1;", "", "  let b = 2;", "a"}, newline)
+		expected := []token.Position{
+			{Line: 1, Column: 1}, {Line: 1, Column: 5}, {Line: 1, Column: 7}, {Line: 1, Column: 9}, {Line: 1, Column: 10},
+			{Line: 3, Column: 3}, {Line: 3, Column: 7}, {Line: 3, Column: 9}, {Line: 3, Column: 11}, {Line: 3, Column: 12},
+			{Line: 4, Column: 1},
+		}
+		l := New(input)
+		for i, want := range expected {
+			tok := l.NextToken()
+			if tok.Pos != want {
+				t.Errorf("%s: tokens[%d] %q position wrong. expected=%s, got=%s", name, i, tok.Literal, want, tok.Pos)
+			}
+		}
+	}
+
+	// 混合换行风格
+	l := New("a\r\nb\rc\nd\r\r\ne")
+	for i, line := range []int{1, 2, 3, 4, 6} {
+		if tok := l.NextToken(); tok.Pos.Line != line {
+			t.Errorf("mixed: tokens[%d] %q line wrong. expected=%d, got=%d", i, tok.Literal, line, tok.Pos.Line)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// adds\nlet a = 1 / 2; // half\r\n//\n"
+	tests := []struct {
+		expectedType    token.TypeToken
+		expectedLiteral string
+		expectedLine    int
+	}{
+		{token.COMMENT, "// adds", 1},
+		{token.LET, "let", 2},
+		{token.IDENT, "a", 2},
+		{token.ASSIGN, "=", 2},
+		{token.INT, "1", 2},
+		{token.SLASH, "/", 2},
+		{token.INT, "2", 2},
+		{token.SEMICOLON, ";", 2},
+		{token.COMMENT, "// half", 2},
+		{token.COMMENT, "//", 3},
+		{token.EOF, "", 4},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Pos.Line != tt.expectedLine {
+			t.Fatalf("tests[%d] wrong token. expected=%s %q line %d, got=%s %q line %d",
+				i, tt.expectedType, tt.expectedLiteral, tt.expectedLine, tok.Type, tok.Literal, tok.Pos.Line)
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	input := "/* header\n   spans lines */\nlet a = 1 + /* x */ 2; /**/ a /* * / */ / 2/*end*/"
+	tests := []struct {
+		expectedType    token.TypeToken
+		expectedLiteral string
+		expectedPos     token.Position
+	}{
+		{token.LET, "let", token.Position{Line: 3, Column: 1}},
+		{token.IDENT, "a", token.Position{Line: 3, Column: 5}},
+		{token.ASSIGN, "=", token.Position{Line: 3, Column: 7}},
+		{token.INT, "1", token.Position{Line: 3, Column: 9}},
+		{token.PLUS, "+", token.Position{Line: 3, Column: 11}},
+		{token.INT, "2", token.Position{Line: 3, Column: 21}},
+		{token.SEMICOLON, ";", token.Position{Line: 3, Column: 22}},
+		{token.IDENT, "a", token.Position{Line: 3, Column: 29}},
+		{token.SLASH, "/", token.Position{Line: 3, Column: 41}},
+		{token.INT, "2", token.Position{Line: 3, Column: 43}},
+		{token.EOF, "", token.Position{Line: 3, Column: 51}},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] wrong token. expected=%s %q at %s, got=%s %q at %s",
+				i, tt.expectedType, tt.expectedLiteral, tt.expectedPos, tok.Type, tok.Literal, tok.Pos)
+		}
+	}
+	if len(l.Errors()) != 0 {
+		t.Errorf("unexpected errors: %q", l.Errors())
+	}
+
+	l = New("1 /* never\nclosed *")
+	if tok := l.NextToken(); tok.Literal != "1" {
+		t.Fatalf("first token wrong. got=%q", tok.Literal)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Errorf("unterminated comment did not run to EOF. got=%s", tok.Type)
+	}
+	expected := []string{"unterminated block comment starting at 1:3"}
+	if len(l.Errors()) != 1 || l.Errors()[0] != expected[0] {
+		t.Errorf("wrong errors. expected=%q, got=%q", expected, l.Errors())
+	}
+}
+
+func TestReaderMatchesStringLexer(t *testing.T) {
+	program := `let five = 5;
+let add = fn(x, y) { x + y; };
+let s = "héllo, 世界";
+let e = "tab\t\"quoted\"\n";
+if (add(five, 2.5) != 10) { return [1, 2][0]; } else { obj.name }
+`
+	inputs := map[string]string{
+		"small": program,
+		"large": strings.Repeat(program, 200),
+		"crlf":  strings.ReplaceAll(program, "\n", "\r\n"),
+		"cr":    strings.ReplaceAll(program, "\n", "\r"),
+	}
+	for name, input := range inputs {
+		readers := map[string]io.Reader{
+			"chunked":  strings.NewReader(input),
+			"one byte": iotest.OneByteReader(strings.NewReader(input)),
+			"half":     iotest.HalfReader(strings.NewReader(input)),
+		}
+		for readerName, r := range readers {
+			expected := New(input)
+			actual := NewReader(r)
+			// 越过输入末尾后继续读取几个 EOF，与解析器的预读行为一致
+			for i, eofs := 0, 0; eofs < 3; i++ {
+				want, wantPos := expected.NextToken(), expected.Position()
+				got, gotPos := actual.NextToken(), actual.Position()
+				if got != want {
+					t.Fatalf("%s/%s: tokens[%d] wrong. expected=%s, got=%s", name, readerName, i, want, got)
+				}
+				if gotPos != wantPos {
+					t.Fatalf("%s/%s: position after tokens[%d] wrong. expected=%d, got=%d", name, readerName, i, wantPos, gotPos)
+				}
+				if want.Type == token.EOF {
+					eofs++
+				}
+			}
+			if actual.Err() != nil {
+				t.Errorf("%s/%s: unexpected read error: %s", name, readerName, actual.Err())
+			}
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	readErr := errors.New("boom")
+	l := NewReader(io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(readErr)))
+	expected := []token.TypeToken{token.LET, token.IDENT, token.EOF}
+	for i, want := range expected {
+		if tok := l.NextToken(); tok.Type != want {
+			t.Fatalf("tokens[%d] wrong. expected=%s, got=%s", i, want, tok.Type)
+		}
+	}
+	if !errors.Is(l.Err(), readErr) {
+		t.Errorf("read error not reported. got=%v", l.Err())
+	}
+}
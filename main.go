@@ -1,19 +1,180 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/token"
+	"monkey/vm"
 )
 
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run 解析命令行参数并执行，返回进程退出码
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dumpTokens := flags.Bool("tokens", false, "print the tokens of a script and exit")
+	dumpAST := flags.Bool("ast", false, "print the parsed AST of a script and exit")
+	dumpBytecode := flags.Bool("bytecode", false, "print the compiled bytecode of a script and exit")
+	engine := flags.String("engine", "vm", "engine used by the REPL: vm or eval")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *engine != "vm" && *engine != "eval" {
+		_, _ = fmt.Fprintf(stderr, "unknown engine %q: must be vm or eval\n", *engine)
+		return 2
+	}
+
+	if *dumpTokens || *dumpAST || *dumpBytecode {
+		if flags.NArg() != 1 {
+			_, _ = fmt.Fprintln(stderr, "usage: monkey --tokens|--ast|--bytecode script.monkey")
+			return 2
+		}
+		source, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "could not read script: %s\n", err)
+			return 1
+		}
+		switch {
+		case *dumpTokens:
+			err = printTokens(stdout, string(source))
+		case *dumpAST:
+			err = printAST(stdout, string(source))
+		default:
+			err = printBytecode(stdout, string(source))
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	if flags.NArg() > 0 {
+		path := flags.Arg(0)
+		if path == "run" {
+			if flags.NArg() != 2 {
+				_, _ = fmt.Fprintln(stderr, "usage: monkey run script.monkey")
+				return 2
+			}
+			path = flags.Arg(1)
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "could not read script: %s\n", err)
+			return 1
+		}
+		return runScript(string(source), stdout, stderr)
+	}
+	// 输入不是终端时从中读取整个脚本执行
+	if !repl.IsTerminal(stdin) {
+		source, err := io.ReadAll(stdin)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "could not read script: %s\n", err)
+			return 1
+		}
+		return runScript(string(source), stdout, stderr)
+	}
+
 	current, err := user.Current()
 	if err != nil {
-		panic(err)
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(stdout, "Hello %s \n", current.Username)
+	_, _ = fmt.Fprintf(stdout, "Feel free to type in commands \n")
+	if *engine == "eval" {
+		repl.Start(stdin, stdout)
+	} else {
+		repl.StartNew(stdin, stdout)
+	}
+	return 0
+}
+
+// runScript 编译并在虚拟机上执行脚本，只有 puts 产生输出，不输出最后的结果
+func runScript(source string, stdout, stderr io.Writer) int {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		_, _ = fmt.Fprintln(stderr, "parser errors:")
+		for _, msg := range p.Errors() {
+			_, _ = fmt.Fprintf(stderr, "\t%s\n", msg)
+		}
+		return 1
+	}
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "compiler error: %s\n", err)
+		return 1
+	}
+	defer func(previous io.Writer) { object.Stdout = previous }(object.Stdout)
+	object.Stdout = stdout
+	machine := vm.New(comp.Bytecode())
+	defer machine.Release()
+	err = machine.SafeRun()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "runtime error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printTokens 逐行输出源代码的token
+func printTokens(out io.Writer, source string) error {
+	l := lexer.New(source)
+	for {
+		tok := l.NextToken()
+		_, err := fmt.Fprintln(out, tok)
+		if err != nil {
+			return err
+		}
+		if tok.Type == token.EOF {
+			return nil
+		}
+	}
+}
+
+// printAST 输出源代码解析得到的AST，与 REPL 的 :ast 相同：先输出程序的字符串形式，再输出缩进的语法树
+func printAST(out io.Writer, source string) error {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return fmt.Errorf("parser errors: %v", p.Errors())
+	}
+	_, err := fmt.Fprintf(out, "%s\n%s", program.String(), ast.Tree(program))
+	return err
+}
+
+// printBytecode 输出源代码编译后的指令和常量池
+func printBytecode(out io.Writer, source string) error {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return fmt.Errorf("parser errors: %v", p.Errors())
+	}
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		return fmt.Errorf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	_, err = fmt.Fprintf(out, "Instructions:\n%s", bytecode.Instructions)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Hello %s \n", current.Username)
-	fmt.Printf("Feel free to type in commands \n")
-	repl.StartNew(os.Stdin, os.Stdout)
+	_, err = fmt.Fprintf(out, "Constants:\n%s", bytecode.ConstantsString())
+	return err
 }
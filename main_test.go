@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpFlags(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.monkey")
+	err := os.WriteFile(script, []byte(`let add = fn(a, b) { a + b }; add(1, 2 * 3);`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flag     string
+		expected []string
+	}{
+		{"--tokens", []string{"{LET let}", "{IDENT add}", "{FUNCTION fn}", "{* *}", "{EOF }"}},
+		{"--ast", []string{"let add = fn<add>(a, b) (a + b);", "add(1, (2 * 3))", "Program\n  Statements[0]: LetStatement\n", "      Arguments[1]: InfixExpression *\n"}},
+		{"--bytecode", []string{"Instructions:", "OpClosure 0 0", "OpSetGlobal 0", "OpCall 2", "Constants:", "0000 COMPILED_FUNCTION", "0001 INTEGER 1"}},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{tt.flag, script}, strings.NewReader(""), &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("%s: exit code %d, stderr: %s", tt.flag, code, stderr.String())
+		}
+		for _, want := range tt.expected {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("%s: output missing %q. got:\n%s", tt.flag, want, stdout.String())
+			}
+		}
+	}
+}
+
+func TestDumpFlagErrors(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "broken.monkey")
+	err := os.WriteFile(script, []byte(`let = 5;`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--ast", script}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("parser error exit code wrong. got=%d, want=1", code)
+	}
+	if !strings.Contains(stderr.String(), "parser errors") {
+		t.Errorf("parser errors not reported. got=%q", stderr.String())
+	}
+	if code := run([]string{"--tokens"}, strings.NewReader(""), &stdout, &stderr); code != 2 {
+		t.Errorf("missing script exit code wrong. got=%d, want=2", code)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.monkey")
+	err := os.WriteFile(script, []byte("let add = fn(a, b) { a + b };\nputs(add(1, 2));\nadd(3, 4)\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args  []string
+		stdin string
+	}{
+		{[]string{"run", script}, ""},
+		{[]string{script}, ""},
+		{nil, "let add = fn(a, b) { a + b };\nputs(add(1, 2));\nadd(3, 4)\n"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d, stderr: %s", tt.args, code, stderr.String())
+		}
+		// 最后一个表达式的值不输出，只有 puts 产生输出
+		if stdout.String() != "3\n" {
+			t.Errorf("%v: wrong output. got=%q", tt.args, stdout.String())
+		}
+	}
+}
+
+func TestRunScriptErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		code     int
+		expected string
+	}{
+		{"let = 5;", 1, "parser errors:\n\texpected next token to be IDENT, got = instead\n"},
+		{"missing", 1, "compiler error: identifier not found: missing\n"},
+		{"1;\n-true", 1, "runtime error: [line 2:1] unsupported type for negation: BOOLEAN\n"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(nil, strings.NewReader(tt.source), &stdout, &stderr)
+		if code != tt.code {
+			t.Errorf("%q: exit code wrong. got=%d, want=%d", tt.source, code, tt.code)
+		}
+		if !strings.HasPrefix(stderr.String(), tt.expected) {
+			t.Errorf("%q: wrong error output. got=%q, want=%q", tt.source, stderr.String(), tt.expected)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"run"}, strings.NewReader(""), &stdout, &stderr); code != 2 {
+		t.Errorf("run without script exit code wrong. got=%d, want=2", code)
+	}
+	if code := run([]string{filepath.Join(t.TempDir(), "missing.monkey")}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("missing script exit code wrong. got=%d, want=1", code)
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--engine=lisp"}, strings.NewReader(""), &stdout, &stderr); code != 2 {
+		t.Errorf("exit code wrong. got=%d, want=2", code)
+	}
+	if !strings.Contains(stderr.String(), `unknown engine "lisp"`) {
+		t.Errorf("unknown engine not reported. got=%q", stderr.String())
+	}
+}
@@ -1,138 +1,721 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
-// Builtins 保存内置函数
+// Clock bench 计时使用的时钟，测试时可以替换为固定的时间序列
+var Clock = time.Now
+
+// Stdout puts 输出的目标，默认为标准输出
+var Stdout io.Writer = os.Stdout
+
+// Builtins 保存内置函数，错误信息中的函数名取自这里注册的名字
 var Builtins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
 	{
+		// len 直接读取切片或字符串的长度，时间复杂度为 O(1)
 		"len",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1",
-						len(args))
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Bytes:
+				return &Integer{Value: int64(len(arg.Value))}
+			default:
+				return newError("argument to `%s` not supported, got %s", name, args[0].Type())
+			}
+		}),
+	},
+	{
+		"puts",
+		newBuiltin(func(name string, args ...Object) Object {
+			for _, arg := range args {
+				_, _ = fmt.Fprintln(Stdout, arg.Inspect())
+			}
+			return nil
+		}),
+	},
+	{
+		"first",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				if len(arg.Elements) > 0 {
+					return arg.Elements[0]
 				}
-				switch arg := args[0].(type) {
-				case *Array:
-					return &Integer{Value: int64(len(arg.Elements))}
-				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
-				default:
-					return newError("argument to `len` not supported, got %s",
-						args[0].Type())
+				return nil
+			default:
+				return wrongArgumentType(name, "Array", arg)
+			}
+		}),
+	},
+	{
+		"last",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				l := len(arg.Elements)
+				if l > 0 {
+					return arg.Elements[l-1]
 				}
-			},
-		},
+				return nil
+			default:
+				return wrongArgumentType(name, "Array", arg)
+			}
+		}),
 	},
 	{
-		"puts",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+		"rest",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				l := len(arg.Elements)
+				if l > 0 {
+					newElements := make([]Object, l-1, l-1)
+					copy(newElements, arg.Elements[1:])
+					return &Array{Elements: newElements}
 				}
 				return nil
-			},
-		},
+			default:
+				return wrongArgumentType(name, "Array", arg)
+			}
+		}),
 	},
 	{
-		"first",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return &Error{
-						Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
+		"push",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				l := len(arg.Elements)
+				newElements := make([]Object, l+1, l+1)
+				copy(newElements, arg.Elements)
+				newElements[l] = args[1]
+				return &Array{Elements: newElements}
+			default:
+				return wrongArgumentType(name, "Array", arg)
+			}
+		}),
+	},
+	{
+		"capacity",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(cap(arg.Elements))}
+			default:
+				return wrongArgumentType(name, "Array", arg)
+			}
+		}),
+	},
+	{
+		// assign 将 source 的键值复制到 target 中，修改并返回 target
+		"assign",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			target, ok := args[0].(*Hash)
+			if !ok {
+				return wrongArgumentType(name, "Hash", args[0])
+			}
+			source, ok := args[1].(*Hash)
+			if !ok {
+				return wrongArgumentType(name, "Hash", args[1])
+			}
+			for key, pair := range source.Pairs {
+				target.Pairs[key] = pair
+			}
+			return target
+		}),
+	},
+	{
+		// type 返回对象的类型名，带有字符串 "__type__" 键的哈希返回自定义类型名
+		"type",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			if hash, ok := args[0].(*Hash); ok {
+				if typeName, ok := hash.Field(TypeField); ok {
+					if str, ok := typeName.(*String); ok {
+						return &String{Value: str.Value}
 					}
 				}
-				switch arg := args[0].(type) {
-				case *Array:
-					if len(arg.Elements) > 0 {
-						return arg.Elements[0]
-					}
-					return nil
-				default:
-					return &Error{
-						Message: fmt.Sprintf("argument to `first` must be Array, got %s", arg.Type()),
-					}
+			}
+			return &String{Value: string(args[0].Type())}
+		}),
+	},
+	{
+		// sortedKeys 返回按自然顺序排序的哈希键：整数按数值、字符串按字典序、false 在 true 之前，
+		// 键类型不一致时返回错误
+		"sortedKeys",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return wrongArgumentType(name, "Hash", args[0])
+			}
+			keys := make([]Object, 0, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				if len(keys) > 0 && pair.Key.Type() != keys[0].Type() {
+					return newError("keys of `%s` argument must share one type, got %s and %s",
+						name, keys[0].Type(), pair.Key.Type())
 				}
-			}},
+				keys = append(keys, pair.Key)
+			}
+			sortNatural(keys)
+			return &Array{Elements: keys}
+		}),
 	},
 	{
-		"last",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return &Error{
-						Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
-					}
+		"toJSON",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			out, err := ToJSON(args[0])
+			if err != nil {
+				return newError("%s", err)
+			}
+			return &String{Value: out}
+		}),
+	},
+	{
+		// divmod 返回 [商, 余数]，与整数除法一致向零截断，满足 a == 商*b + 余数
+		"divmod",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			a, ok := args[0].(*Integer)
+			if !ok {
+				return wrongArgumentType(name, "Integer", args[0])
+			}
+			b, ok := args[1].(*Integer)
+			if !ok {
+				return wrongArgumentType(name, "Integer", args[1])
+			}
+			if b.Value == 0 {
+				return newError("division by zero")
+			}
+			return &Array{Elements: []Object{
+				&Integer{Value: a.Value / b.Value},
+				&Integer{Value: a.Value % b.Value},
+			}}
+		}),
+	},
+	{
+		// flatten 展开一层嵌套的数组，非数组元素保持不变
+		"flatten",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			elements := make([]Object, 0, len(arr.Elements))
+			for _, el := range arr.Elements {
+				if inner, ok := el.(*Array); ok {
+					elements = append(elements, inner.Elements...)
+				} else {
+					elements = append(elements, el)
 				}
-				switch arg := args[0].(type) {
-				case *Array:
-					l := len(arg.Elements)
-					if l > 0 {
-						return arg.Elements[l-1]
-					}
-					return nil
-				default:
-					return &Error{
-						Message: fmt.Sprintf("argument to `last` must be Array, got %s", arg.Type()),
-					}
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// flattenDeep 递归展开所有层级的嵌套数组
+		"flattenDeep",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			elements, err := flattenDeep(arr, make([]Object, 0, len(arr.Elements)), map[*Array]bool{})
+			if err != nil {
+				return err
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// chr 返回码点对应的单字符字符串
+		"chr",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			code, ok := args[0].(*Integer)
+			if !ok {
+				return wrongArgumentType(name, "Integer", args[0])
+			}
+			if code.Value < 0 || code.Value > utf8.MaxRune || !utf8.ValidRune(rune(code.Value)) {
+				return newError("argument to `%s` is not a valid code point: %d", name, code.Value)
+			}
+			return newValidString(name, string(rune(code.Value)))
+		}),
+	},
+	{
+		// repr 返回对象的调试表示，字符串带引号并转义，类型可以从输出的写法区分，见 Repr
+		"repr",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			return &String{Value: Repr(args[0])}
+		}),
+	},
+	{
+		// map 对数组的每个元素调用函数，返回由结果组成的新数组
+		"map",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			elements := make([]Object, len(arr.Elements))
+			for i, el := range arr.Elements {
+				result := call.Call(args[1], el)
+				if err, ok := result.(*Error); ok {
+					return err
 				}
-			},
-		},
+				elements[i] = result
+			}
+			return &Array{Elements: elements}
+		}),
 	},
 	{
-		"rest",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return &Error{
-						Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
-					}
+		// filter 返回函数结果为真值的元素组成的新数组
+		"filter",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			elements := make([]Object, 0, len(arr.Elements))
+			for _, el := range arr.Elements {
+				result := call.Call(args[1], el)
+				if err, ok := result.(*Error); ok {
+					return err
 				}
-				switch arg := args[0].(type) {
-				case *Array:
-					l := len(arg.Elements)
-					if l > 0 {
-						newElements := make([]Object, l-1)
-						copy(newElements, arg.Elements[1:])
-						return &Array{Elements: newElements}
-					}
-					return nil
-				default:
-					return &Error{
-						Message: fmt.Sprintf("argument to `rest` must be Array, got %s", arg.Type()),
-					}
+				if call.Truthiness.IsTruthy(result) {
+					elements = append(elements, el)
 				}
-			},
-		},
+			}
+			return &Array{Elements: elements}
+		}),
 	},
 	{
-		"push",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				if len(args) != 2 {
-					return &Error{
-						Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args)),
-					}
+		// reduce 以 init 为初始值，依次调用 fn(acc, el) 折叠数组
+		"reduce",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			if len(args) != 3 {
+				return wrongArgumentCount(len(args), 3)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			acc := args[1]
+			for _, el := range arr.Elements {
+				acc = call.Call(args[2], acc, el)
+				if err, ok := acc.(*Error); ok {
+					return err
 				}
-				switch arg := args[0].(type) {
-				case *Array:
-					l := len(arg.Elements)
-					newElements := make([]Object, l+1, l+1)
-					copy(newElements, arg.Elements)
-					newElements[l] = args[1]
-					return &Array{Elements: newElements}
-				default:
-					return &Error{
-						Message: fmt.Sprintf("argument to `push` must be Array, got %s", arg.Type()),
+			}
+			return acc
+		}),
+	},
+	{
+		// range 返回 [start, end) 内以 step 为步长的整数数组，range(n) 等价于 range(0, n)，
+		// step 为负数时倒序生成
+		"range",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1..3", len(args))
+			}
+			bounds := []int64{0, 0, 1}
+			for i, arg := range args {
+				n, ok := arg.(*Integer)
+				if !ok {
+					return wrongArgumentType(name, "Integer", arg)
+				}
+				bounds[i] = n.Value
+			}
+			start, end, step := bounds[0], bounds[1], bounds[2]
+			if len(args) == 1 {
+				start, end = 0, bounds[0]
+			}
+			if step == 0 {
+				return newError("step argument to `%s` must not be zero", name)
+			}
+			elements := []Object{}
+			for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+				elements = append(elements, &Integer{Value: i})
+				// 下一个值超出 int64 范围时结束，避免回绕后无限循环
+				if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+					break
+				}
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// bench 调用无参函数并计时，返回 {"result": 返回值, "ms": 耗时毫秒数}
+		"bench",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			start := Clock()
+			result := call.Call(args[0])
+			elapsed := Clock().Sub(start)
+			if err, ok := result.(*Error); ok {
+				return err
+			}
+			hash := &Hash{Pairs: make(map[HashKey]HashPair, 2)}
+			hash.SetField("result", result)
+			hash.SetField("ms", &Float{Value: float64(elapsed) / float64(time.Millisecond)})
+			return hash
+		}),
+	},
+	{
+		// str 返回对象的字符串表示，与 puts 的输出一致
+		"str",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			return &String{Value: args[0].Inspect()}
+		}),
+	},
+	{
+		// int 将十进制字符串解析为整数，浮点数向零截断，整数原样返回
+		"int",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				if math.IsNaN(arg.Value) || arg.Value >= math.MaxInt64 || arg.Value < math.MinInt64 {
+					return newError("argument to `%s` is out of integer range: %s", name, arg.Inspect())
+				}
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				value, err := strconv.ParseInt(arg.Value, 10, 64)
+				if err != nil {
+					return newError("argument to `%s` is not a valid integer: %q", name, arg.Value)
+				}
+				return &Integer{Value: value}
+			default:
+				return wrongArgumentType(name, "String, Float or Integer", arg)
+			}
+		}),
+	},
+	{
+		// sort 返回按自然顺序排序的新数组，元素必须是同一种整数、浮点数、字符串或布尔值；
+		// sort(arr, less) 改用比较函数 less(a, b) 判断 a 是否排在 b 之前，元素类型不限。
+		// 选项 {"reverse": true} 按逆序排序
+		"sort",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			args, opts := Options(args)
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. got=%d, want=1..2", len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			if err := checkOptions(name, opts, "reverse"); err != nil {
+				return err
+			}
+			reverse, err := boolOption(name, opts, "reverse", false)
+			if err != nil {
+				return err
+			}
+			elements := make([]Object, len(arr.Elements))
+			copy(elements, arr.Elements)
+			if len(args) == 2 {
+				sorted := sortWith(name, call, args[1], elements)
+				if sorted.Type() == ErrorObj || !reverse {
+					return sorted
+				}
+				slices.Reverse(elements)
+				return sorted
+			}
+			for _, el := range elements {
+				switch {
+				case el.Type() != elements[0].Type():
+					return newError("elements of `%s` argument must share one type, got %s and %s",
+						name, elements[0].Type(), el.Type())
+				case el.Type() != IntegerObj && el.Type() != FloatObj && el.Type() != StringObj && el.Type() != BooleanObj:
+					return newError("argument to `%s` cannot be ordered, got elements of type %s", name, el.Type())
+				}
+			}
+			sortNatural(elements)
+			if reverse {
+				slices.Reverse(elements)
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// split 按分隔符切分字符串，分隔符为空时按字符切分，
+		// 选项 {"limit": n} 最多切分为 n 段，最后一段包含剩余的部分
+		"split",
+		newBuiltin(func(name string, args ...Object) Object {
+			args, opts := Options(args)
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return wrongArgumentType(name, "String", args[0])
+			}
+			sep, ok := args[1].(*String)
+			if !ok {
+				return wrongArgumentType(name, "String", args[1])
+			}
+			if err := checkOptions(name, opts, "limit"); err != nil {
+				return err
+			}
+			limit, err := intOption(name, opts, "limit", -1)
+			if err != nil {
+				return err
+			}
+			if limit == 0 || limit < -1 {
+				return newError("option `limit` of `%s` must be positive, got %d", name, limit)
+			}
+			parts := strings.SplitN(str.Value, sep.Value, int(limit))
+			elements := make([]Object, len(parts))
+			for i, part := range parts {
+				elements[i] = &String{Value: part}
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// sortBy 返回按 keyFn(element) 的结果稳定排序的新数组，键必须都是整数或都是字符串
+		"sortBy",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return wrongArgumentCount(len(args), 2)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return wrongArgumentType(name, "Array", args[0])
+			}
+			keys := make([]Object, len(arr.Elements))
+			for i, el := range arr.Elements {
+				key := call.Call(args[1], el)
+				switch {
+				case key.Type() == ErrorObj:
+					return key
+				case key.Type() != IntegerObj && key.Type() != StringObj:
+					return newError("key of `%s` must be Integer or String, got %s", name, key.Type())
+				case i > 0 && key.Type() != keys[0].Type():
+					return newError("keys of `%s` must share one type, got %s and %s", name, keys[0].Type(), key.Type())
+				}
+				keys[i] = key
+			}
+			order := make([]int, len(keys))
+			for i := range order {
+				order[i] = i
+			}
+			sort.SliceStable(order, func(i, j int) bool {
+				return naturalLess(keys[order[i]], keys[order[j]])
+			})
+			elements := make([]Object, len(order))
+			for i, index := range order {
+				elements[i] = arr.Elements[index]
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// groupBy 返回以 keyFn(element) 为键、对应元素组成的数组为值的哈希
+		"groupBy",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			return groupElements(name, call, args, func(group, el Object) Object {
+				if group == nil {
+					return &Array{Elements: []Object{el}}
+				}
+				arr := group.(*Array)
+				arr.Elements = append(arr.Elements, el)
+				return arr
+			})
+		}),
+	},
+	{
+		// countBy 返回以 keyFn(element) 为键、对应元素个数为值的哈希
+		"countBy",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			return groupElements(name, call, args, func(group, el Object) Object {
+				if group == nil {
+					return &Integer{Value: 1}
+				}
+				return &Integer{Value: group.(*Integer).Value + 1}
+			})
+		}),
+	},
+	{
+		// iterate 从 seed 开始反复调用 fn，返回 [seed, fn(seed), fn(fn(seed)), ...] 的前 n 项
+		"iterate",
+		newHigherOrderBuiltin(func(name string, call Caller, args ...Object) Object {
+			if len(args) != 3 {
+				return wrongArgumentCount(len(args), 3)
+			}
+			switch args[0].(type) {
+			case *Function, *Closure, *Builtin:
+			default:
+				return wrongArgumentType(name, "Function", args[0])
+			}
+			n, ok := args[2].(*Integer)
+			if !ok {
+				return wrongArgumentType(name, "Integer", args[2])
+			}
+			if n.Value <= 0 {
+				return newError("count argument to `%s` must be positive, got %d", name, n.Value)
+			}
+			elements := []Object{args[1]}
+			for int64(len(elements)) < n.Value {
+				next := call.Call(args[0], elements[len(elements)-1])
+				if err, ok := next.(*Error); ok {
+					return err
+				}
+				elements = append(elements, next)
+			}
+			return &Array{Elements: elements}
+		}),
+	},
+	{
+		// keys 返回哈希的全部键，顺序与 Hash.SortedPairs 一致，键类型可以混合
+		"keys",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return wrongArgumentType(name, "Hash", args[0])
+			}
+			pairs := hash.SortedPairs()
+			keys := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+			return &Array{Elements: keys}
+		}),
+	},
+	{
+		// values 返回哈希的全部值，顺序与 keys 返回的键一一对应
+		"values",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return wrongArgumentType(name, "Hash", args[0])
+			}
+			pairs := hash.SortedPairs()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		}),
+	},
+	{
+		// bytes 返回字符串的 UTF-8 编码，或由 0 到 255 的整数数组构成的字节数组
+		"bytes",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				return &Bytes{Value: []byte(arg.Value)}
+			case *Array:
+				value := make([]byte, len(arg.Elements))
+				for i, el := range arg.Elements {
+					n, ok := el.(*Integer)
+					if !ok {
+						return newError("elements of `%s` argument must be Integer, got %s", name, el.Type())
+					}
+					if n.Value < 0 || n.Value > math.MaxUint8 {
+						return newError("byte value out of range: %d", n.Value)
 					}
+					value[i] = byte(n.Value)
 				}
-			},
-		},
+				return &Bytes{Value: value}
+			default:
+				return wrongArgumentType(name, "String or Array", args[0])
+			}
+		}),
+	},
+	{
+		// string 将字节数组按 UTF-8 解码为字符串，内容不是合法的 UTF-8 时返回错误
+		"string",
+		newBuiltin(func(name string, args ...Object) Object {
+			if len(args) != 1 {
+				return wrongArgumentCount(len(args), 1)
+			}
+			b, ok := args[0].(*Bytes)
+			if !ok {
+				return wrongArgumentType(name, "Bytes", args[0])
+			}
+			return newValidString(name, string(b.Value))
+		}),
 	},
 	{
 		"",
@@ -140,11 +723,237 @@ var Builtins = []struct {
 	},
 }
 
+// Options 按约定取出内置函数参数末尾的选项哈希，如 sort(arr, {"reverse": true})，
+// 返回其余的位置参数和选项，最后一个参数不是哈希时 opts 为 nil。
+// 位置参数本身可能是哈希的内置函数不能使用这一约定
+func Options(args []Object) (positional []Object, opts *Hash) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if hash, ok := args[len(args)-1].(*Hash); ok {
+		return args[:len(args)-1], hash
+	}
+	return args, nil
+}
+
+// checkOptions 检查选项哈希只包含 allowed 中的字符串键
+func checkOptions(name string, opts *Hash, allowed ...string) *Error {
+	if opts == nil {
+		return nil
+	}
+	for _, pair := range opts.Pairs {
+		key, ok := pair.Key.(*String)
+		if !ok || !slices.Contains(allowed, key.Value) {
+			return newError("unknown option %s for `%s`", Repr(pair.Key), name)
+		}
+	}
+	return nil
+}
+
+// boolOption 读取布尔选项，未设置时返回 def
+func boolOption(name string, opts *Hash, key string, def bool) (bool, *Error) {
+	if opts == nil {
+		return def, nil
+	}
+	value, ok := opts.Field(key)
+	if !ok {
+		return def, nil
+	}
+	b, ok := value.(*Boolean)
+	if !ok {
+		return false, newError("option `%s` of `%s` must be Boolean, got %s", key, name, value.Type())
+	}
+	return b.Value, nil
+}
+
+// intOption 读取整数选项，未设置时返回 def
+func intOption(name string, opts *Hash, key string, def int64) (int64, *Error) {
+	if opts == nil {
+		return def, nil
+	}
+	value, ok := opts.Field(key)
+	if !ok {
+		return def, nil
+	}
+	i, ok := value.(*Integer)
+	if !ok {
+		return 0, newError("option `%s` of `%s` must be Integer, got %s", key, name, value.Type())
+	}
+	return i.Value, nil
+}
+
+// groupElements 按 keyFn(element) 的结果对数组分组，add 将元素并入键已有的值（首次为 nil）并返回新值，
+// 键必须可以作为哈希键
+func groupElements(name string, call Caller, args []Object, add func(group, el Object) Object) Object {
+	if len(args) != 2 {
+		return wrongArgumentCount(len(args), 2)
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return wrongArgumentType(name, "Array", args[0])
+	}
+	hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for _, el := range arr.Elements {
+		key := call.Call(args[1], el)
+		if err, ok := key.(*Error); ok {
+			return err
+		}
+		hashable, ok := key.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+		var group Object
+		if pair, ok := hash.Pairs[hashable.HashKey()]; ok {
+			group = pair.Value
+		}
+		hash.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: add(group, el)}
+	}
+	return hash
+}
+
+// sortNatural 按自然顺序原地稳定排序同一类型的对象
+func sortNatural(elements []Object) {
+	sort.SliceStable(elements, func(i, j int) bool {
+		return naturalLess(elements[i], elements[j])
+	})
+}
+
+// sortWith 用比较函数 less 原地稳定排序 elements，返回排好序的数组。
+// less 必须是函数且返回布尔值，调用出错时停止排序并返回该错误
+func sortWith(name string, call Caller, less Object, elements []Object) Object {
+	switch less.(type) {
+	case *Function, *Closure, *Builtin:
+	default:
+		return wrongArgumentType(name, "Function", less)
+	}
+	var failed *Error
+	sort.SliceStable(elements, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		result := call.Call(less, elements[i], elements[j])
+		switch result := result.(type) {
+		case *Error:
+			failed = result
+		case *Boolean:
+			return result.Value
+		default:
+			failed = newError("comparator of `%s` must return Boolean, got %s", name, result.Type())
+		}
+		return false
+	})
+	if failed != nil {
+		return failed
+	}
+	return &Array{Elements: elements}
+}
+
+// naturalLess 按自然顺序比较同一类型的两个对象：数字按数值、字符串按字典序、false 在 true 之前
+func naturalLess(left, right Object) bool {
+	switch left := left.(type) {
+	case *Integer:
+		return left.Value < right.(*Integer).Value
+	case *Float:
+		return left.Value < right.(*Float).Value
+	case *String:
+		return left.Value < right.(*String).Value
+	case *Boolean:
+		return !left.Value && right.(*Boolean).Value
+	}
+	return false
+}
+
+// newValidString 由内置函数构造字符串，内容不是合法的 UTF-8 时返回错误
+func newValidString(name, value string) Object {
+	if !utf8.ValidString(value) {
+		return newError("result of `%s` is not valid UTF-8", name)
+	}
+	return &String{Value: value}
+}
+
+// flattenDeep 将数组中的元素递归展开追加到 out，visited 记录正在展开的数组以发现循环引用
+func flattenDeep(arr *Array, out []Object, visited map[*Array]bool) ([]Object, *Error) {
+	if visited[arr] {
+		return nil, newError("cannot flatten cyclic ARRAY")
+	}
+	visited[arr] = true
+	defer delete(visited, arr)
+	for _, el := range arr.Elements {
+		inner, ok := el.(*Array)
+		if !ok {
+			out = append(out, el)
+			continue
+		}
+		var err *Error
+		out, err = flattenDeep(inner, out, visited)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func init() {
+	for _, def := range Builtins {
+		def.Builtin.bind(def.Name)
+	}
+}
+
+// namedBuiltinFunction 内置函数的实现，name 为注册时的函数名，用于错误信息
+type namedBuiltinFunction func(name string, args ...Object) Object
+
+// higherOrderFunction 高阶内置函数的实现，通过 call 调用作为参数传入的函数
+type higherOrderFunction func(name string, call Caller, args ...Object) Object
+
+// newBuiltin 创建内置函数，注册名在初始化时绑定
+func newBuiltin(fn namedBuiltinFunction) *Builtin {
+	return &Builtin{named: fn}
+}
+
+// newHigherOrderBuiltin 创建需要回调 Monkey 函数的内置函数。
+// 内置函数本身不持有执行引擎，求值器和虚拟机通过 Builtin.Call 传入 Caller：
+// 求值器直接调用 applyFunction，虚拟机在当前栈上同步执行被调用的函数
+func newHigherOrderBuiltin(fn higherOrderFunction) *Builtin {
+	b := &Builtin{higherOrder: fn}
+	b.named = func(name string, args ...Object) Object {
+		return fn(name, noCaller, args...)
+	}
+	return b
+}
+
+// noCaller 在没有执行引擎时调用 Fn 使用的 Caller，调用函数总是返回错误
+var noCaller = Caller{
+	Call: func(fn Object, args ...Object) Object {
+		return newError("cannot call %s without an interpreter", fn.Type())
+	},
+}
+
+// bind 将内置函数绑定到注册名，生成供求值器和虚拟机调用的 Fn
+func (b *Builtin) bind(name string) {
+	if b.named == nil {
+		return
+	}
+	b.Name = name
+	b.Fn = func(args ...Object) Object {
+		return b.named(b.Name, args...)
+	}
+}
+
 // newError 返回一个错误对象
 func newError(format string, a ...any) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// wrongArgumentCount 返回参数个数错误
+func wrongArgumentCount(got, want int) *Error {
+	return newError("wrong number of arguments. got=%d, want=%d", got, want)
+}
+
+// wrongArgumentType 返回参数类型错误，want 为期望的类型名
+func wrongArgumentType(name, want string, got Object) *Error {
+	return newError("argument to `%s` must be %s, got %s", name, want, got.Type())
+}
+
 // GetBuiltinByName 根据名字获取内置函数
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
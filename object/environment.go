@@ -1,10 +1,19 @@
 package object
 
+import "sort"
+
+// smallEnvSize 小型环境按位置存储的变量上限，超过后改用 map
+const smallEnvSize = 8
+
+// DefaultMaxCallDepth 求值器默认允许的最大函数调用深度
+const DefaultMaxCallDepth = 10000
+
 // NewEnvironment 创建环境对象
 func NewEnvironment() *Environment {
 	return &Environment{
-		store: make(map[string]Object),
-		outer: nil,
+		store:    make(map[string]Object),
+		outer:    nil,
+		settings: newEnvSettings(),
 	}
 }
 
@@ -12,6 +21,37 @@ func NewEnvironment() *Environment {
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.settings = sharedSettings(outer)
+	return env
+}
+
+// sharedSettings 返回内层环境共享的外层设置，没有外层环境时创建默认设置
+func sharedSettings(outer *Environment) *envSettings {
+	if outer == nil {
+		return newEnvSettings()
+	}
+	return outer.settings
+}
+
+// NewSizedEnclosedEnvironment 创建预估变量数量的封闭环境对象，
+// 变量较少时按位置存储在切片中，避免为每次函数调用分配 map
+func NewSizedEnclosedEnvironment(outer *Environment, size int) *Environment {
+	if size > smallEnvSize {
+		return &Environment{store: make(map[string]Object, size), outer: outer, settings: sharedSettings(outer)}
+	}
+	return &Environment{
+		vars:     make([]envVar, 0, size),
+		outer:    outer,
+		settings: sharedSettings(outer),
+	}
+}
+
+// NewBlockEnvironment 创建块环境，用于循环等语句块：
+// 对外层环境中已有的变量重新绑定时修改外层变量，新的名字只在块内可见
+func NewBlockEnvironment(outer *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = outer.depth
+	env.block = true
 	return env
 }
 
@@ -19,19 +59,127 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	vars     []envVar     // 小型环境按位置存储的变量
+	depth    int          // 创建该环境的函数调用深度，顶层为 0
+	block    bool         // 块环境中重新绑定外层已有的变量时修改外层变量
+	settings *envSettings // 求值设置，由顶层环境创建，内层环境共享
+}
+
+// envSettings 一次求值的设置，不同的顶层环境互不影响
+type envSettings struct {
+	truthiness   Truthiness // 判断真假的规则
+	maxCallDepth int        // 允许的最大函数调用深度，超过时返回错误而不是耗尽 Go 栈
+}
+
+// newEnvSettings 创建默认设置
+func newEnvSettings() *envSettings {
+	return &envSettings{maxCallDepth: DefaultMaxCallDepth}
+}
+
+// envVar 小型环境中的一个变量
+type envVar struct {
+	name  string
+	value Object
+}
+
+// CallDepth 获取创建该环境的函数调用深度
+func (e *Environment) CallDepth() int {
+	return e.depth
+}
+
+// SetCallDepth 设置创建该环境的函数调用深度
+func (e *Environment) SetCallDepth(depth int) {
+	e.depth = depth
+}
+
+// Truthiness 获取求值时判断真假的规则
+func (e *Environment) Truthiness() Truthiness {
+	return e.settings.truthiness
+}
+
+// SetTruthiness 设置求值时判断真假的规则，对共享设置的所有环境生效
+func (e *Environment) SetTruthiness(t Truthiness) {
+	e.settings.truthiness = t
+}
+
+// MaxCallDepth 获取求值时允许的最大函数调用深度
+func (e *Environment) MaxCallDepth() int {
+	return e.settings.maxCallDepth
+}
+
+// SetMaxCallDepth 设置求值时允许的最大函数调用深度，对共享设置的所有环境生效
+func (e *Environment) SetMaxCallDepth(depth int) {
+	e.settings.maxCallDepth = depth
 }
 
 // Get 获取变量
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
+	obj, ok := e.get(name)
 	if !ok && e.outer != nil {
 		obj, ok = e.outer.Get(name)
 	}
 	return obj, ok
 }
 
-// Set 设置变量
+// get 在当前环境中查找变量，不查找外层环境
+func (e *Environment) get(name string) (Object, bool) {
+	for _, v := range e.vars {
+		if v.name == name {
+			return v.value, true
+		}
+	}
+	obj, ok := e.store[name]
+	return obj, ok
+}
+
+// Names 返回当前环境中定义的变量名，按名字排序，不包含外层环境
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.vars)+len(e.store))
+	for _, v := range e.vars {
+		names = append(names, v.name)
+	}
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set 设置变量，块环境中外层已有的变量会被修改而不是被遮蔽
 func (e *Environment) Set(name string, val Object) Object {
+	if e.block && !e.has(name) && e.outer.owns(name) {
+		return e.outer.Set(name, val)
+	}
+	return e.Declare(name, val)
+}
+
+// has 判断当前环境中是否定义了变量，不查找外层环境
+func (e *Environment) has(name string) bool {
+	_, ok := e.get(name)
+	return ok
+}
+
+// owns 判断变量是否定义在当前环境或块环境所属的外层环境中
+func (e *Environment) owns(name string) bool {
+	return e.has(name) || (e.block && e.outer.owns(name))
+}
+
+// Declare 在当前环境中定义变量，块环境中也会遮蔽外层的同名变量
+func (e *Environment) Declare(name string, val Object) Object {
+	for i := range e.vars {
+		if e.vars[i].name == name {
+			e.vars[i].value = val
+			return val
+		}
+	}
+	if e.store == nil {
+		if len(e.vars) < smallEnvSize {
+			e.vars = append(e.vars, envVar{name: name, value: val})
+			return val
+		}
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
-	return e.store[name]
+	return val
 }
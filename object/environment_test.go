@@ -0,0 +1,100 @@
+package object
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSizedEnclosedEnvironment(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	outer.Set("b", &Integer{Value: 2})
+
+	for _, size := range []int{0, 2, smallEnvSize, smallEnvSize + 1} {
+		env := NewSizedEnclosedEnvironment(outer, size)
+		env.Set("b", &Integer{Value: 20})
+		env.Set("c", &Integer{Value: 30})
+		env.Set("c", &Integer{Value: 31})
+
+		expected := map[string]int64{"a": 1, "b": 20, "c": 31}
+		for name, want := range expected {
+			obj, ok := env.Get(name)
+			if !ok {
+				t.Fatalf("size %d: %s not found", size, name)
+			}
+			if obj.(*Integer).Value != want {
+				t.Errorf("size %d: %s wrong value. got=%d, want=%d", size, name, obj.(*Integer).Value, want)
+			}
+		}
+		if obj, _ := outer.Get("b"); obj.(*Integer).Value != 2 {
+			t.Errorf("size %d: inner Set leaked into outer environment", size)
+		}
+		if _, ok := env.Get("missing"); ok {
+			t.Errorf("size %d: missing variable was found", size)
+		}
+	}
+}
+
+func TestSizedEnclosedEnvironmentSpillsToMap(t *testing.T) {
+	env := NewSizedEnclosedEnvironment(nil, 1)
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}
+	for i, name := range names {
+		env.Set(name, &Integer{Value: int64(i)})
+	}
+	for i, name := range names {
+		obj, ok := env.Get(name)
+		if !ok {
+			t.Fatalf("%s not found", name)
+		}
+		if obj.(*Integer).Value != int64(i) {
+			t.Errorf("%s wrong value. got=%d, want=%d", name, obj.(*Integer).Value, i)
+		}
+	}
+	env.Set("k", &Integer{Value: 100})
+	if obj, _ := env.Get("k"); obj.(*Integer).Value != 100 {
+		t.Errorf("k not overwritten. got=%d", obj.(*Integer).Value)
+	}
+}
+
+func TestBlockEnvironment(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	block := NewBlockEnvironment(outer)
+	inner := NewBlockEnvironment(block)
+
+	inner.Set("a", &Integer{Value: 10})
+	inner.Set("b", &Integer{Value: 2})
+	block.Declare("c", &Integer{Value: 3})
+	inner.Set("c", &Integer{Value: 30})
+
+	if obj, _ := outer.Get("a"); obj.(*Integer).Value != 10 {
+		t.Errorf("block Set did not update outer variable. got=%s", obj.Inspect())
+	}
+	if _, ok := outer.Get("b"); ok {
+		t.Errorf("new block variable leaked into outer environment")
+	}
+	if obj, _ := block.Get("c"); obj.(*Integer).Value != 30 {
+		t.Errorf("nested block Set did not update enclosing block. got=%s", obj.Inspect())
+	}
+
+	block.Declare("a", &Integer{Value: 100})
+	if obj, _ := outer.Get("a"); obj.(*Integer).Value != 10 {
+		t.Errorf("Declare modified outer variable. got=%s", obj.Inspect())
+	}
+}
+
+func TestEnvironmentNames(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("z", &Integer{Value: 1})
+	outer.Set("a", &Integer{Value: 2})
+	if got := strings.Join(outer.Names(), ","); got != "a,z" {
+		t.Errorf("outer names wrong. got=%s", got)
+	}
+
+	env := NewSizedEnclosedEnvironment(outer, 2)
+	env.Set("c", &Integer{Value: 3})
+	env.Set("b", &Integer{Value: 4})
+	if got := strings.Join(env.Names(), ","); got != "b,c" {
+		t.Errorf("enclosed names wrong. got=%s", got)
+	}
+}
@@ -0,0 +1,98 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ToJSON 将对象序列化为 JSON，数组和哈希递归序列化，哈希的键必须是字符串，按键排序输出
+func ToJSON(obj Object) (string, error) {
+	var out bytes.Buffer
+	err := writeJSON(&out, obj, make(map[Object]bool))
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// writeJSON 将对象以 JSON 写入 out，visited 记录当前路径上的容器以拒绝循环引用
+func writeJSON(out *bytes.Buffer, obj Object, visited map[Object]bool) error {
+	switch obj := obj.(type) {
+	case *Null:
+		out.WriteString("null")
+	case *Boolean:
+		out.WriteString(fmt.Sprintf("%t", obj.Value))
+	case *Integer:
+		out.WriteString(fmt.Sprintf("%d", obj.Value))
+	case *Float:
+		b, err := json.Marshal(obj.Value)
+		if err != nil {
+			return fmt.Errorf("cannot serialize %s to JSON", obj.Inspect())
+		}
+		out.Write(b)
+	case *String:
+		writeJSONString(out, obj.Value)
+	case *Array:
+		if visited[obj] {
+			return fmt.Errorf("cannot serialize cyclic ARRAY to JSON")
+		}
+		visited[obj] = true
+		defer delete(visited, obj)
+
+		out.WriteString("[")
+		for i, element := range obj.Elements {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			if err := writeJSON(out, element, visited); err != nil {
+				return err
+			}
+		}
+		out.WriteString("]")
+	case *Hash:
+		if visited[obj] {
+			return fmt.Errorf("cannot serialize cyclic HASH to JSON")
+		}
+		visited[obj] = true
+		defer delete(visited, obj)
+
+		keys := make([]string, 0, len(obj.Pairs))
+		values := make(map[string]Object, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				return fmt.Errorf("JSON object keys must be strings, got %s", pair.Key.Type())
+			}
+			keys = append(keys, key.Value)
+			values[key.Value] = pair.Value
+		}
+		sort.Strings(keys)
+
+		out.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			writeJSONString(out, key)
+			out.WriteString(":")
+			if err := writeJSON(out, values[key], visited); err != nil {
+				return err
+			}
+		}
+		out.WriteString("}")
+	default:
+		return fmt.Errorf("cannot serialize %s to JSON", obj.Type())
+	}
+	return nil
+}
+
+// writeJSONString 以 JSON 字符串的形式写入 s，不转义 HTML 字符
+func writeJSONString(out *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	// Encode 会追加换行符
+	out.Truncate(out.Len() - 1)
+}
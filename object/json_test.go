@@ -0,0 +1,67 @@
+package object
+
+import "testing"
+
+func TestToJSON(t *testing.T) {
+	str := func(s string) *String { return &String{Value: s} }
+	hash := func(pairs ...Object) *Hash {
+		h := &Hash{Pairs: map[HashKey]HashPair{}}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Pairs[pairs[i].(Hashable).HashKey()] = HashPair{Key: pairs[i], Value: pairs[i+1]}
+		}
+		return h
+	}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{&Integer{Value: -3}, "-3"},
+		{&Float{Value: 2.5}, "2.5"},
+		{&Boolean{Value: true}, "true"},
+		{&Null{}, "null"},
+		{str(`say "hi" <b>`), `"say \"hi\" <b>"`},
+		{&Array{}, "[]"},
+		{hash(), "{}"},
+		{
+			hash(
+				str("meta"), hash(str("n"), &Integer{Value: 2}),
+				str("items"), &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}},
+			),
+			`{"items":[1,2],"meta":{"n":2}}`,
+		},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 5; i++ {
+			got, err := ToJSON(tt.obj)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", tt.obj.Inspect(), err)
+			}
+			if got != tt.expected {
+				t.Fatalf("wrong JSON. got=%q, want=%q", got, tt.expected)
+			}
+		}
+	}
+
+	self := hash()
+	self.Pairs[str("self").HashKey()] = HashPair{Key: str("self"), Value: self}
+	errorTests := []struct {
+		obj      Object
+		expected string
+	}{
+		{hash(&Integer{Value: 1}, str("a")), "JSON object keys must be strings, got INTEGER"},
+		{&Array{Elements: []Object{hash(&Boolean{Value: true}, str("a"))}}, "JSON object keys must be strings, got BOOLEAN"},
+		{&Builtin{}, "cannot serialize BUILTIN to JSON"},
+		{self, "cannot serialize cyclic HASH to JSON"},
+	}
+	for _, tt := range errorTests {
+		_, err := ToJSON(tt.obj)
+		if err == nil {
+			t.Errorf("expected error for %s", tt.obj.Type())
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. got=%q, want=%q", err.Error(), tt.expected)
+		}
+	}
+}
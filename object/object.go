@@ -3,20 +3,29 @@ package object
 import (
 	"fmt"
 	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"monkey/ast"
 	"monkey/code"
+	"monkey/token"
 )
 
 const (
 	IntegerObj          TypeObject = "INTEGER"
+	FloatObj            TypeObject = "FLOAT"
 	BooleanObj          TypeObject = "BOOLEAN"
 	NullObj             TypeObject = "NULL"
 	ReturnValueObj      TypeObject = "RETURN_VALUE"
+	BreakValueObj       TypeObject = "BREAK_VALUE"
+	ContinueValueObj    TypeObject = "CONTINUE_VALUE"
 	ErrorObj            TypeObject = "ERROR"
 	FunctionObj         TypeObject = "FUNCTION"
 	StringObj           TypeObject = "STRING"
+	BytesObj            TypeObject = "BYTES"
 	builtinObj          TypeObject = "BUILTIN"
 	ArrayObj            TypeObject = "ARRAY"
 	HashObj             TypeObject = "HASH"
@@ -44,7 +53,8 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
-// Integer 整数对象
+// Integer 整数对象，与 Go 一致，除法向零截断（-5 / 4 == -1），
+// 余数的符号与被除数相同
 type Integer struct {
 	Value int64 // 整数值
 }
@@ -66,6 +76,50 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// IntPow 计算 base 的 exp 次幂，exp 必须非负，溢出时与 Go 的 int64 一样回绕
+func IntPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// Float 浮点数对象
+type Float struct {
+	Value float64 // 浮点数值
+}
+
+// 定义 Float 对象实现 Object 接口
+var _ Object = (*Float)(nil)
+
+// Type 返回对象类型
+func (f *Float) Type() TypeObject { return FloatObj }
+
+// Inspect 返回对象字符串表示，整数值的浮点数保留 .0 以区别于整数
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// FloatValue 返回整数或浮点数对象的浮点数值，用于整数和浮点数混合运算时的类型提升
+func FloatValue(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
 // Boolean 布尔对象
 type Boolean struct {
 	Value bool // 布尔值
@@ -106,6 +160,50 @@ func (*Null) Type() TypeObject { return NullObj }
 // Inspect 返回对象字符串表示
 func (*Null) Inspect() string { return "null" }
 
+// Truthiness 判断真假的规则。虚拟机和求值环境各自保存一份，
+// 同时运行的多个引擎可以使用不同的规则而互不影响
+type Truthiness struct {
+	EmptyIsFalsy bool // 空数组、空哈希、空字符串和数值 0 也为假，与 Python 和 JavaScript 类似
+}
+
+// IsTruthy 按默认规则判断对象是否为真，见 Truthiness.IsTruthy
+func IsTruthy(obj Object) bool {
+	return Truthiness{}.IsTruthy(obj)
+}
+
+// IsTruthy 判断对象在条件、!、&& 和 || 中是否为真，求值器和虚拟机共用：
+// false、null 和错误为假，其余对象（默认包括 0 和空字符串）为真，开启 EmptyIsFalsy 时空值也为假。
+// 内置函数返回的错误是普通的值，&& 和 || 的结果为决定结果的操作数，
+// 因此 first(1) || 5 的结果为 5；运算符等产生的运行时错误仍然中止执行，不会进入判断
+func (t Truthiness) IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null, *Error:
+		return false
+	}
+	return !t.EmptyIsFalsy || !isEmpty(obj)
+}
+
+// isEmpty 判断对象是否为空数组、空哈希、空字符串或数值 0
+func isEmpty(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value == 0
+	case *Float:
+		return obj.Value == 0
+	case *String:
+		return obj.Value == ""
+	case *Bytes:
+		return len(obj.Value) == 0
+	case *Array:
+		return len(obj.Elements) == 0
+	case *Hash:
+		return len(obj.Pairs) == 0
+	}
+	return false
+}
+
 // ReturnValue 返回对象
 type ReturnValue struct {
 	Value Object // 返回值
@@ -120,9 +218,34 @@ func (rv *ReturnValue) Type() TypeObject { return ReturnValueObj }
 // Inspect 返回对象字符串表示
 func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
+// BreakValue break 语句的结果，沿语句块向外传递直到所在的循环
+type BreakValue struct{}
+
+// 定义 BreakValue 对象实现 Object 接口
+var _ Object = (*BreakValue)(nil)
+
+// Type 返回对象类型
+func (bv *BreakValue) Type() TypeObject { return BreakValueObj }
+
+// Inspect 返回对象字符串表示
+func (bv *BreakValue) Inspect() string { return "break" }
+
+// ContinueValue continue 语句的结果，沿语句块向外传递直到所在的循环
+type ContinueValue struct{}
+
+// 定义 ContinueValue 对象实现 Object 接口
+var _ Object = (*ContinueValue)(nil)
+
+// Type 返回对象类型
+func (cv *ContinueValue) Type() TypeObject { return ContinueValueObj }
+
+// Inspect 返回对象字符串表示
+func (cv *ContinueValue) Inspect() string { return "continue" }
+
 // Error 错误对象
 type Error struct {
-	Message string // 错误信息
+	Message string         // 错误信息
+	Pos     token.Position // 出错的源代码位置，行号为 0 表示未知
 }
 
 // 定义 Error 对象实现 Object 接口
@@ -131,8 +254,13 @@ var _ Object = (*Error)(nil)
 // Type 返回对象类型
 func (e *Error) Type() TypeObject { return ErrorObj }
 
-// Inspect 返回对象字符串表示
-func (e *Error) Inspect() string { return "ErrorObj: " + e.Message }
+// Inspect 返回对象字符串表示，位置已知时在信息前加上 [line 行:列]
+func (e *Error) Inspect() string {
+	if e.Pos.Line == 0 {
+		return "ErrorObj: " + e.Message
+	}
+	return fmt.Sprintf("ErrorObj: [line %s] %s", e.Pos, e.Message)
+}
 
 // Function 函数对象
 type Function struct {
@@ -163,7 +291,8 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
-// String 字符串对象
+// String 字符串对象，内置函数只产生合法的 UTF-8 字符串，
+// 由字节或码点构造出非法编码时返回错误而不是保留原始字节
 type String struct {
 	Value string // 字符串值
 }
@@ -187,12 +316,44 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
+// Bytes 字节数组对象，可以保存任意二进制数据
+type Bytes struct {
+	Value []byte // 字节内容
+}
+
+// 定义 Bytes 对象实现 Object 接口
+var _ Object = (*Bytes)(nil)
+
+// Type 返回对象类型
+func (b *Bytes) Type() TypeObject { return BytesObj }
+
+// Inspect 返回对象字符串表示，形如 b"abc"，不可打印的字节以转义形式输出
+func (b *Bytes) Inspect() string { return "b" + strconv.Quote(string(b.Value)) }
+
 // BuiltinFunction 自定义函数
 type BuiltinFunction func(args ...Object) Object
 
 // Builtin 自定义函数对象
 type Builtin struct {
-	Fn BuiltinFunction // 自定义函数
+	Name string          // 注册的函数名
+	Fn   BuiltinFunction // 自定义函数
+
+	named       namedBuiltinFunction // 接收注册名的实现
+	higherOrder higherOrderFunction  // 需要回调 Monkey 函数的实现，普通内置函数为 nil
+}
+
+// Caller 由求值器或虚拟机提供给 map 等高阶内置函数
+type Caller struct {
+	Call       func(fn Object, args ...Object) Object // 调用 Monkey 函数，调用失败时返回 *Error
+	Truthiness Truthiness                             // 引擎判断真假的规则，filter 等按它判断回调的结果
+}
+
+// Call 调用内置函数，高阶内置函数通过 call 回调执行引擎，普通内置函数忽略 call
+func (b *Builtin) Call(call Caller, args ...Object) Object {
+	if b.higherOrder != nil {
+		return b.higherOrder(b.Name, call, args...)
+	}
+	return b.Fn(args...)
 }
 
 // 定义 Builtin 对象实现 Object 接口
@@ -217,15 +378,71 @@ func (a *Array) Type() TypeObject { return ArrayObj }
 
 // Inspect 返回对象字符串表示
 func (a *Array) Inspect() string {
-	var out strings.Builder
-	elements := make([]string, len(a.Elements))
-	for i, element := range a.Elements {
-		elements[i] = element.Inspect()
+	return inspectNested(a, 0, make(map[Object]bool), Object.Inspect)
+}
+
+// Inspect 输出容器时的限制，超过限制的部分以 ... 表示
+var (
+	InspectMaxDepth    = 32  // 最大嵌套深度
+	InspectMaxElements = 100 // 每个容器最多输出的元素个数
+)
+
+// Repr 返回对象的调试表示，与 Inspect 的区别是字符串（包括容器中的字符串）
+// 带引号并转义特殊字符。类型通过字面量的写法区分而不输出类型名：1 为整数，"1" 为字符串，
+// 1.0 为浮点数，b"1" 为字节数组
+func Repr(obj Object) string {
+	return inspectNested(obj, 0, make(map[Object]bool), reprLeaf)
+}
+
+// reprLeaf 返回非容器对象的调试表示
+func reprLeaf(obj Object) string {
+	if str, ok := obj.(*String); ok {
+		return strconv.Quote(str.Value)
+	}
+	return obj.Inspect()
+}
+
+// inspectNested 按深度和元素个数限制返回对象字符串表示，leaf 用于输出非容器对象，
+// visited 记录当前路径上的容器，遇到循环引用时输出 ... 而不是无限递归
+func inspectNested(obj Object, depth int, visited map[Object]bool, leaf func(Object) string) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if depth >= InspectMaxDepth || visited[obj] {
+			return "[...]"
+		}
+		visited[obj] = true
+		defer delete(visited, obj)
+
+		n := min(len(obj.Elements), InspectMaxElements)
+		elements := make([]string, 0, n+1)
+		for _, element := range obj.Elements[:n] {
+			elements = append(elements, inspectNested(element, depth+1, visited, leaf))
+		}
+		if len(obj.Elements) > n {
+			elements = append(elements, "...")
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *Hash:
+		if depth >= InspectMaxDepth || visited[obj] {
+			return "{...}"
+		}
+		visited[obj] = true
+		defer delete(visited, obj)
+
+		n := min(len(obj.Pairs), InspectMaxElements)
+		pairs := make([]string, 0, n+1)
+		for _, pair := range obj.SortedPairs() {
+			if len(pairs) == n {
+				pairs = append(pairs, "...")
+				break
+			}
+			pairs = append(pairs, fmt.Sprintf("%s: %s",
+				inspectNested(pair.Key, depth+1, visited, leaf), inspectNested(pair.Value, depth+1, visited, leaf)))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	default:
+		return leaf(obj)
 	}
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-	return out.String()
 }
 
 // HashPair 哈希键值对
@@ -234,6 +451,13 @@ type HashPair struct {
 	Value Object // 哈希值
 }
 
+// 哈希中用于运算符重载的方法名
+const (
+	AddMethod = "__add__"  // 重载 +
+	EqMethod  = "__eq__"   // 重载 == 和 !=
+	TypeField = "__type__" // 自定义类型名
+)
+
 // Hash 哈希对象
 type Hash struct {
 	Pairs map[HashKey]HashPair // 哈希键值对
@@ -247,15 +471,194 @@ func (h *Hash) Type() TypeObject { return HashObj }
 
 // Inspect 返回对象字符串表示
 func (h *Hash) Inspect() string {
-	var out strings.Builder
-	pairs := make([]string, len(h.Pairs))
+	return inspectNested(h, 0, make(map[Object]bool), Object.Inspect)
+}
+
+// Field 获取字符串键对应的值
+func (h *Hash) Field(name string) (Object, bool) {
+	pair, ok := h.Pairs[(&String{Value: name}).HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return pair.Value, true
+}
+
+// SortedPairs 按稳定顺序返回键值对：先按键类型名排序（BOOLEAN、INTEGER、STRING），
+// 同类型的键再按自然顺序排序。键取自 HashPair.Key 中保存的原始对象
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
 	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+		pairs = append(pairs, pair)
 	}
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-	return out.String()
+	sort.Slice(pairs, func(i, j int) bool {
+		left, right := pairs[i].Key, pairs[j].Key
+		if left.Type() != right.Type() {
+			return left.Type() < right.Type()
+		}
+		return naturalLess(left, right)
+	})
+	return pairs
+}
+
+// SetField 设置字符串键对应的值
+func (h *Hash) SetField(name string, value Object) {
+	key := &String{Value: name}
+	h.Pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+}
+
+// SetIndex 原地修改数组元素或哈希键值，求值器和虚拟机共用，
+// 数组越界返回错误，哈希中不存在的键会被插入
+func SetIndex(container, index, value Object) error {
+	switch container := container.(type) {
+	case *Array:
+		i, ok := index.(*Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(container.Elements)) {
+			return fmt.Errorf("index out of range: %d (length %d)", i.Value, len(container.Elements))
+		}
+		container.Elements[i.Value] = value
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		container.Pairs[key.HashKey()] = HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", container.Type())
+	}
+	return nil
+}
+
+// Index 取出数组元素、字符串中的字符、字节数组中的字节或哈希中的值，求值器和虚拟机共用。
+// 数组、字符串和字节数组的下标越界（包括负数）或哈希中不存在该键时返回 nil
+func Index(container, index Object) (Object, error) {
+	switch container := container.(type) {
+	case *Array:
+		i, ok := index.(*Integer)
+		if !ok {
+			break
+		}
+		if i.Value < 0 || i.Value >= int64(len(container.Elements)) {
+			return nil, nil
+		}
+		return container.Elements[i.Value], nil
+	case *String:
+		i, ok := index.(*Integer)
+		if !ok {
+			break
+		}
+		char, err := StringIndex(container, i.Value)
+		if err != nil || char == nil {
+			return nil, err
+		}
+		return char, nil
+	case *Bytes:
+		i, ok := index.(*Integer)
+		if !ok {
+			break
+		}
+		if i.Value < 0 || i.Value >= int64(len(container.Value)) {
+			return nil, nil
+		}
+		return &Integer{Value: int64(container.Value[i.Value])}, nil
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		pair, ok := container.Pairs[key.HashKey()]
+		if !ok {
+			return nil, nil
+		}
+		return pair.Value, nil
+	}
+	return nil, fmt.Errorf("index operator not supported: %s", container.Type())
+}
+
+// StringIndex 按字节下标取出字符串中的单个字符，越界时返回 nil，
+// 下标落在多字节字符中间时返回错误
+func StringIndex(str *String, index int64) (*String, error) {
+	if index < 0 || index >= int64(len(str.Value)) {
+		return nil, nil
+	}
+	value := str.Value[index : index+1]
+	if !utf8.ValidString(value) {
+		return nil, fmt.Errorf("string index %d splits a UTF-8 character", index)
+	}
+	return &String{Value: value}, nil
+}
+
+// Method 取出方法调用 receiver.name() 中的方法，求值器和虚拟机共用：
+// 接收者必须是哈希，并且有名为 name 的字段
+func Method(receiver Object, name string) (Object, error) {
+	hash, ok := receiver.(*Hash)
+	if !ok {
+		return nil, fmt.Errorf("index operator not supported: %s", receiver.Type())
+	}
+	method, ok := hash.Field(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined method %s on %s", name, receiver.Type())
+	}
+	return method, nil
+}
+
+// Slice 返回数组或字符串 [start:end) 范围的新对象，求值器和虚拟机共用。
+// 边界为 Null 表示省略，负数从末尾倒数，超出范围的边界会被截断到 [0, len]
+func Slice(container, start, end Object) (Object, error) {
+	var length int64
+	switch container := container.(type) {
+	case *Array:
+		length = int64(len(container.Elements))
+	case *String:
+		length = int64(len(container.Value))
+	case *Bytes:
+		length = int64(len(container.Value))
+	default:
+		return nil, fmt.Errorf("slice operator not supported: %s", container.Type())
+	}
+	low, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	high, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if high < low {
+		high = low
+	}
+	switch container := container.(type) {
+	case *Array:
+		elements := make([]Object, high-low)
+		copy(elements, container.Elements[low:high])
+		return &Array{Elements: elements}, nil
+	case *Bytes:
+		return &Bytes{Value: slices.Clone(container.Value[low:high])}, nil
+	default:
+		value := container.(*String).Value[low:high]
+		if !utf8.ValidString(value) {
+			return nil, fmt.Errorf("string slice [%d:%d] splits a UTF-8 character", low, high)
+		}
+		return &String{Value: value}, nil
+	}
+}
+
+// sliceBound 将切片边界换算为 [0, length] 内的下标，省略时返回 fallback
+func sliceBound(bound Object, fallback, length int64) (int64, error) {
+	if bound.Type() == NullObj {
+		return fallback, nil
+	}
+	i, ok := bound.(*Integer)
+	if !ok {
+		return 0, fmt.Errorf("slice bound must be INTEGER, got %s", bound.Type())
+	}
+	value := i.Value
+	if value < 0 {
+		value += length
+	}
+	return min(max(value, 0), length), nil
 }
 
 // CompiledFunction 编译的函数对象
@@ -263,6 +666,7 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Positions     code.LineTable // 指令对应的源代码位置，用于报告运行时错误
 }
 
 // 定义 Function 对象实现 Object 接口
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "hello"}
@@ -19,3 +23,263 @@ func TestStringHashKey(t *testing.T) {
 	}
 
 }
+
+func TestCapacityAfterAppend(t *testing.T) {
+	capacity := GetBuiltinByName("capacity")
+	arr := &Array{Elements: make([]Object, 0, 1)}
+
+	last := int64(0)
+	for i := 0; i < 10; i++ {
+		arr.Elements = append(arr.Elements, &Integer{Value: int64(i)})
+		result, ok := capacity.Fn(arr).(*Integer)
+		if !ok {
+			t.Fatalf("capacity did not return Integer")
+		}
+		if result.Value < int64(len(arr.Elements)) {
+			t.Fatalf("capacity smaller than length. got=%d, len=%d", result.Value, len(arr.Elements))
+		}
+		if result.Value < last {
+			t.Fatalf("capacity shrank after append. got=%d, before=%d", result.Value, last)
+		}
+		last = result.Value
+	}
+	if last <= 1 {
+		t.Errorf("capacity did not grow after appends. got=%d", last)
+	}
+
+	if _, ok := capacity.Fn(&String{Value: "a"}).(*Error); !ok {
+		t.Errorf("capacity of non-array should return Error")
+	}
+}
+
+func TestBuiltinErrorsUseRegisteredName(t *testing.T) {
+	for _, def := range Builtins {
+		if def.Name != "" && def.Builtin.Name != def.Name {
+			t.Errorf("builtin %q bound to name %q", def.Name, def.Builtin.Name)
+		}
+	}
+
+	tests := []struct {
+		builtin  string
+		args     []Object
+		expected string
+	}{
+		{"first", []Object{&Integer{Value: 1}}, "argument to `head` must be Array, got INTEGER"},
+		{"len", []Object{&Integer{Value: 1}}, "argument to `head` not supported, got INTEGER"},
+		{"assign", []Object{&Hash{}, &Integer{Value: 1}}, "argument to `head` must be Hash, got INTEGER"},
+		{"push", []Object{}, "wrong number of arguments. got=0, want=2"},
+	}
+	for _, tt := range tests {
+		// 模拟在注册表中将内置函数改名
+		renamed := newBuiltin(GetBuiltinByName(tt.builtin).named)
+		renamed.bind("head")
+		errObj, ok := renamed.Fn(tt.args...).(*Error)
+		if !ok {
+			t.Fatalf("%s: expected Error", tt.builtin)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%s: wrong message. want=%q, got=%q", tt.builtin, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestNewValidString(t *testing.T) {
+	tests := []struct {
+		bytes    []byte
+		expected string
+	}{
+		{[]byte("abc"), "abc"},
+		{[]byte("猴子"), "猴子"},
+		{[]byte{}, ""},
+		{[]byte{0xff}, "ErrorObj: result of `test` is not valid UTF-8"},
+		{[]byte("猴子")[:2], "ErrorObj: result of `test` is not valid UTF-8"},
+		{[]byte{0xed, 0xa0, 0x80}, "ErrorObj: result of `test` is not valid UTF-8"},
+	}
+	for _, tt := range tests {
+		if got := newValidString("test", string(tt.bytes)).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for % x. got=%q, want=%q", tt.bytes, got, tt.expected)
+		}
+	}
+}
+
+func TestIsTruthy(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		expected bool
+	}{
+		{&Boolean{Value: true}, true},
+		{&Boolean{Value: false}, false},
+		{&Null{}, false},
+		{&Error{Message: "boom"}, false},
+		{&Integer{Value: 0}, true},
+		{&String{Value: ""}, true},
+		{&Array{}, true},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, true},
+	}
+	for _, tt := range tests {
+		if got := IsTruthy(tt.obj); got != tt.expected {
+			t.Errorf("IsTruthy(%s) wrong. got=%t, want=%t", tt.obj.Inspect(), got, tt.expected)
+		}
+	}
+
+	emptyIsFalsy := Truthiness{EmptyIsFalsy: true}
+	emptyTests := []struct {
+		obj      Object
+		expected bool
+	}{
+		{&Integer{Value: 0}, false},
+		{&Integer{Value: -1}, true},
+		{&Float{Value: 0}, false},
+		{&String{Value: ""}, false},
+		{&String{Value: "0"}, true},
+		{&Array{}, false},
+		{&Array{Elements: []Object{&Null{}}}, true},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, false},
+		{&Boolean{Value: true}, true},
+		{&Null{}, false},
+	}
+	for _, tt := range emptyTests {
+		if got := emptyIsFalsy.IsTruthy(tt.obj); got != tt.expected {
+			t.Errorf("EmptyIsFalsy: IsTruthy(%s) wrong. got=%t, want=%t", tt.obj.Inspect(), got, tt.expected)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	opts := &Hash{Pairs: map[HashKey]HashPair{}}
+	opts.SetField("reverse", &Boolean{Value: true})
+	args := []Object{&Array{}, opts}
+
+	positional, got := Options(args)
+	if len(positional) != 1 || got != opts {
+		t.Errorf("trailing hash not taken as options. positional=%d, opts=%v", len(positional), got)
+	}
+	positional, got = Options(args[:1])
+	if len(positional) != 1 || got != nil {
+		t.Errorf("options found without trailing hash. positional=%d, opts=%v", len(positional), got)
+	}
+	if positional, got = Options(nil); len(positional) != 0 || got != nil {
+		t.Errorf("options found in empty arguments")
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  Hashable
+		equal bool
+	}{
+		{"same strings", &String{Value: "a"}, &String{Value: "a"}, true},
+		{"different strings", &String{Value: "a"}, &String{Value: "b"}, false},
+		{"same integers", &Integer{Value: 1}, &Integer{Value: 1}, true},
+		{"different integers", &Integer{Value: 1}, &Integer{Value: 2}, false},
+		{"same booleans", &Boolean{Value: true}, &Boolean{Value: true}, true},
+		{"different booleans", &Boolean{Value: true}, &Boolean{Value: false}, false},
+		{"integer and boolean", &Integer{Value: 1}, &Boolean{Value: true}, false},
+		{"integer and string", &Integer{Value: 0}, &String{Value: ""}, false},
+	}
+	for _, tt := range tests {
+		if (tt.a.HashKey() == tt.b.HashKey()) != tt.equal {
+			t.Errorf("%s: HashKey equality wrong. want equal=%t", tt.name, tt.equal)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{&Integer{Value: -5}, "-5"},
+		{&Integer{Value: 0}, "0"},
+		{&Integer{Value: math.MinInt64}, "-9223372036854775808"},
+		{&Integer{Value: math.MaxInt64}, "9223372036854775807"},
+		{&Float{Value: 2.5}, "2.5"},
+		{&Float{Value: 4}, "4.0"},
+		{&Float{Value: -0.25}, "-0.25"},
+		{&Float{Value: 1e21}, "1e+21"},
+		{&Boolean{Value: true}, "true"},
+		{&Null{}, "null"},
+		{&String{Value: "hello"}, "hello"},
+		{&Error{Message: "boom"}, "ErrorObj: boom"},
+		{&Array{}, "[]"},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, "[1, a]"},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, "{}"},
+		{
+			&Hash{Pairs: map[HashKey]HashPair{
+				(&String{Value: "foo"}).HashKey(): {Key: &String{Value: "foo"}, Value: &Integer{Value: 1}},
+			}},
+			"{foo: 1}",
+		},
+	}
+	for _, tt := range tests {
+		if tt.obj.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. got=%q, want=%q", tt.obj.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestHashInspect(t *testing.T) {
+	tests := []struct {
+		keys     []Object
+		expected string
+	}{
+		{[]Object{&String{Value: "foo"}}, "{foo: 0}"},
+		{[]Object{&String{Value: "foo"}, &String{Value: "bar"}, &String{Value: "baz"}}, "{bar: 1, baz: 2, foo: 0}"},
+		{[]Object{&String{Value: "b"}, &Integer{Value: 10}, &Boolean{Value: true}, &Integer{Value: 9}}, "{true: 2, 9: 3, 10: 1, b: 0}"},
+		{[]Object{&String{Value: "x10"}, &String{Value: "x9"}}, "{x10: 0, x9: 1}"},
+	}
+	for _, tt := range tests {
+		hash := &Hash{Pairs: map[HashKey]HashPair{}}
+		for i, key := range tt.keys {
+			hash.Pairs[key.(Hashable).HashKey()] = HashPair{Key: key, Value: &Integer{Value: int64(i)}}
+		}
+		if got := hash.Inspect(); got != tt.expected {
+			t.Errorf("wrong hash Inspect. got=%q, want=%q", got, tt.expected)
+		}
+	}
+}
+
+func TestInspectLimits(t *testing.T) {
+	large := &Array{}
+	for i := 0; i < InspectMaxElements+50; i++ {
+		large.Elements = append(large.Elements, &Integer{Value: int64(i)})
+	}
+	inspected := large.Inspect()
+	if !strings.HasSuffix(inspected, ", 99, ...]") {
+		t.Errorf("large array not truncated. got=%q", inspected)
+	}
+	if strings.Contains(inspected, "100") {
+		t.Errorf("large array rendered elements past the limit. got=%q", inspected)
+	}
+
+	deep := Object(&Integer{Value: 1})
+	for i := 0; i < InspectMaxDepth+5; i++ {
+		deep = &Array{Elements: []Object{deep}}
+	}
+	expected := strings.Repeat("[", InspectMaxDepth) + "[...]" + strings.Repeat("]", InspectMaxDepth)
+	if deep.Inspect() != expected {
+		t.Errorf("deep array not truncated. got=%q", deep.Inspect())
+	}
+}
+
+func TestInspectCycles(t *testing.T) {
+	arr := &Array{}
+	arr.Elements = []Object{&Integer{Value: 1}, arr}
+	if arr.Inspect() != "[1, [...]]" {
+		t.Errorf("self-referential array wrong. got=%q", arr.Inspect())
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	key := &String{Value: "self"}
+	hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: hash}
+	if hash.Inspect() != "{self: {...}}" {
+		t.Errorf("self-referential hash wrong. got=%q", hash.Inspect())
+	}
+
+	shared := &Array{Elements: []Object{&Integer{Value: 2}}}
+	twice := &Array{Elements: []Object{shared, shared}}
+	if twice.Inspect() != "[[2], [2]]" {
+		t.Errorf("shared element treated as a cycle. got=%q", twice.Inspect())
+	}
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"monkey/ast"
 	"monkey/lexer"
@@ -12,17 +13,24 @@ import (
 const (
 	_           int = iota
 	lowest          // 最低优先级
+	assign          // =
+	logicalOr       // ||
+	logicalAnd      // &&
 	equals          // ==
 	lessGreater     // ！=
 	sum             // +
 	product         // *
 	prefix          // -X or !X
+	power           // X ** Y
 	call            // function call
 	index           // array index
 )
 
 // 优先级
 var precedences = map[token.TypeToken]int{
+	token.ASSIGN:   assign,
+	token.OR:       logicalOr,
+	token.AND:      logicalAnd,
 	token.EQ:       equals,
 	token.NOT_EQ:   equals,
 	token.LT:       lessGreater,
@@ -31,10 +39,15 @@ var precedences = map[token.TypeToken]int{
 	token.MINUS:    sum,
 	token.SLASH:    product,
 	token.ASTERISK: product,
+	token.POW:      power,
 	token.LPAREN:   call,
 	token.LBRACKET: index,
+	token.DOT:      index,
 }
 
+// DefaultMaxArguments 函数调用默认允许的最大参数个数，与 OpCall 的单字节操作数一致
+const DefaultMaxArguments = 255
+
 type (
 	prefixParseFunc func() ast.Expression               // 前缀解析函数
 	infixParseFunc  func(ast.Expression) ast.Expression // 中缀解析函数
@@ -50,13 +63,21 @@ type Parser struct {
 
 	prefixParseFns map[token.TypeToken]prefixParseFunc // 前缀解析函数
 	infixParseFns  map[token.TypeToken]infixParseFunc  // 中缀解析函数
+
+	maxArguments int // 函数调用允许的最大参数个数
+	loopDepth    int // 当前所在循环体的嵌套层数，函数体内重新从 0 开始
+
+	comments    []string // 紧邻 peekToken 之前的注释
+	curComments []string // 紧邻 curToken 之前的注释
+	lexErrors   int      // 已经并入 errors 的词法错误个数
 }
 
 // New 创建解析器
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: make([]string, 0),
+		l:            l,
+		errors:       make([]string, 0),
+		maxArguments: DefaultMaxArguments,
 	}
 
 	// 初始化当前和下一个token
@@ -67,12 +88,15 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = map[token.TypeToken]prefixParseFunc{}
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.WHILE, p.parseLoopExpression)
+	p.registerPrefix(token.FOR, p.parseLoopExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
@@ -84,16 +108,26 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.POW, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parsePropertyExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 
 	return p
 }
 
+// SetMaxArguments 设置函数调用允许的最大参数个数
+func (p *Parser) SetMaxArguments(n int) {
+	p.maxArguments = n
+}
+
 // Errors 获取错误信息
 func (p *Parser) Errors() []string {
 	return p.errors
@@ -105,10 +139,39 @@ func (p *Parser) peekError(t token.TypeToken) {
 	p.errors = append(p.errors, msg)
 }
 
-// nextToken 获取下一个token
+// nextToken 获取下一个token，注释不作为token返回，而是收集起来附加到随后的语句
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	p.curComments = p.comments
+	p.comments = nil
 	p.peekToken = p.l.NextToken()
+	p.collectComments()
+	if errs := p.l.Errors(); len(errs) > p.lexErrors {
+		p.errors = append(p.errors, errs[p.lexErrors:]...)
+		p.lexErrors = len(errs)
+	}
+}
+
+// collectComments 跳过 peekToken 位置的注释，只保留与下一个token之间没有空行的一组注释，
+// 与上一个token同一行的行尾注释被丢弃
+func (p *Parser) collectComments() {
+	line := 0
+	for p.peekToken.Type == token.COMMENT {
+		tok := p.peekToken
+		text := strings.TrimSpace(strings.TrimPrefix(tok.Literal, "//"))
+		switch {
+		case tok.Pos.Line == p.curToken.Pos.Line:
+		case line != 0 && tok.Pos.Line != line+1:
+			p.comments = []string{text}
+		default:
+			p.comments = append(p.comments, text)
+		}
+		line = tok.Pos.Line
+		p.peekToken = p.l.NextToken()
+	}
+	if line != 0 && p.peekToken.Pos.Line != line+1 {
+		p.comments = nil
+	}
 }
 
 // ParseProgram 解析程序
@@ -125,13 +188,29 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
-// parseStatement 解析语句
+// parseStatement 解析语句，并附加语句之前紧邻的注释
 func (p *Parser) parseStatement() ast.Statement {
+	comments := p.curComments
+	stmt := p.parseBareStatement()
+	if stmt != nil && len(comments) > 0 {
+		ast.AttachComments(stmt, comments)
+	}
+	return stmt
+}
+
+// parseBareStatement 根据当前token解析语句
+func (p *Parser) parseBareStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseLoopStatement(p.parseWhileStatement)
+	case token.FOR:
+		return p.parseLoopStatement(p.parseForStatement)
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -251,7 +330,7 @@ func (p *Parser) parseIdentifier() ast.Expression {
 // parseIntegerLiteral 解析整数字面量
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	value, err := strconv.ParseInt(strings.ReplaceAll(p.curToken.Literal, "_", ""), 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
 		p.errors = append(p.errors, msg)
@@ -261,6 +340,19 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+// parseFloatLiteral 解析浮点数字面量
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.curToken.Literal, "_", ""), 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
 // parseBoolean 解析布尔值
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
@@ -268,6 +360,11 @@ func (p *Parser) parseBoolean() ast.Expression {
 
 // parseGroupedExpression 解析括号表达式
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		p.errors = append(p.errors, "empty parenthesized expression")
+		return nil
+	}
 	p.nextToken()
 	exp := p.parseExpression(lowest)
 	if !p.expectPeek(token.RPAREN) {
@@ -288,11 +385,28 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	expression := &ast.InfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
 	precedence := p.curPrecedence()
+	if p.curTokenIs(token.POW) {
+		// ** 右结合：2 ** 3 ** 2 解析为 2 ** (3 ** 2)
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 	return expression
 }
 
+// parseAssignExpression 解析索引赋值表达式，赋值右结合，左侧只能是索引表达式
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	target, ok := left.(*ast.IndexExpression)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("invalid assignment target: %s", left))
+		return nil
+	}
+	expression := &ast.AssignExpression{Token: p.curToken, Target: target}
+	p.nextToken()
+	expression.Value = p.parseExpression(lowest)
+	return expression
+}
+
 // parseIfExpression 解析if表达式
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
@@ -324,6 +438,123 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parseWhileStatement 解析while语句
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(lowest)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	if !p.curTokenIs(token.RBRACE) {
+		return nil
+	}
+	return stmt
+}
+
+// parseLoopStatement 解析语句位置的循环，与let和return语句一样可以省略结尾的分号
+func (p *Parser) parseLoopStatement(parse func() ast.Statement) ast.Statement {
+	stmt := parse()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseLoopExpression 解析出现在表达式位置的while或for循环
+func (p *Parser) parseLoopExpression() ast.Expression {
+	exp := &ast.LoopExpression{Token: p.curToken}
+	if p.curTokenIs(token.WHILE) {
+		exp.Loop = p.parseWhileStatement()
+	} else {
+		exp.Loop = p.parseForStatement()
+	}
+	if exp.Loop == nil {
+		return nil
+	}
+	return exp
+}
+
+// parseLoopBody 解析循环体，循环体内允许 break 和 continue
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+// parseLoopControlStatement 解析break或continue语句，只能出现在循环体内
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	var stmt ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		stmt = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		stmt = &ast.ContinueStatement{Token: p.curToken}
+	}
+	if p.loopDepth == 0 {
+		p.errors = append(p.errors, fmt.Sprintf("%s outside loop", p.curToken.Literal))
+	}
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseForStatement 解析for语句
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Init = p.parseForClause()
+	if stmt.Init == nil {
+		return nil
+	}
+	if !p.curTokenIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(lowest)
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Post = p.parseForClause()
+	if stmt.Post == nil {
+		return nil
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	if !p.curTokenIs(token.RBRACE) {
+		return nil
+	}
+	return stmt
+}
+
+// parseForClause 解析for语句的初始化或后置语句，只允许let语句和表达式语句
+func (p *Parser) parseForClause() ast.Statement {
+	if p.curTokenIs(token.LET) {
+		return p.parseLetStatement()
+	}
+	stmt := p.parseExpressionStatement()
+	if stmt.Expression == nil {
+		return nil
+	}
+	return stmt
+}
+
 // parseBlockStatement 解析块语句
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
@@ -336,6 +567,10 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	if p.curTokenIs(token.EOF) {
+		msg := fmt.Sprintf("expected } to close block opened at %s", block.Token.Pos)
+		p.errors = append(p.errors, msg)
+	}
 	return block
 }
 
@@ -349,7 +584,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
+	// 函数体内的 break 和 continue 不能跳出外层的循环
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
 	return lit
 }
 
@@ -379,20 +618,51 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if len(exp.Arguments) > p.maxArguments {
+		msg := fmt.Sprintf("too many arguments in call to %s: got=%d, max=%d",
+			function.String(), len(exp.Arguments), p.maxArguments)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
 	return exp
 }
 
-// parseIndexExpression 解析索引表达式
+// parseIndexExpression 解析索引表达式，下标中出现冒号时解析为切片表达式
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 	p.nextToken()
-	exp.Index = p.parseExpression(lowest)
+	var index ast.Expression
+	if !p.curTokenIs(token.COLON) {
+		index = p.parseExpression(lowest)
+		if !p.peekTokenIs(token.COLON) {
+			if !p.expectPeek(token.RBRACKET) {
+				return nil
+			}
+			return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+		}
+		p.nextToken()
+	}
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: index}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(lowest)
+	}
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
 	return exp
 }
 
+// parsePropertyExpression 解析属性访问表达式
+func (p *Parser) parsePropertyExpression(left ast.Expression) ast.Expression {
+	exp := &ast.PropertyExpression{Token: p.curToken, Left: left}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
+
 // parseStringLiteral 解析字符串字面量
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
@@ -417,12 +687,20 @@ func (p *Parser) parseExpressionList(end token.TypeToken) []ast.Expression {
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
+		if p.peekTokenIs(end) {
+			msg := fmt.Sprintf("expected expression after , got %s instead", end)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
 		p.nextToken()
 		list = append(list, p.parseExpression(lowest))
 	}
-	if !p.expectPeek(end) {
+	if !p.peekTokenIs(end) {
+		msg := fmt.Sprintf("expected , or %s in list, got %s instead", end, p.peekToken.Type)
+		p.errors = append(p.errors, msg)
 		return nil
 	}
+	p.nextToken()
 	return list
 }
 
@@ -2,7 +2,9 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"monkey/ast"
 	"monkey/lexer"
@@ -110,6 +112,100 @@ func TestIntegerLiteralExpression(t *testing.T) {
 
 }
 
+func TestPrefixedIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF;", 255},
+		{"0b1010;", 10},
+		{"0o17;", 15},
+		{"0XfF;", 255},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("input %q: literal.Value not %d. got=%d", tt.input, tt.expected, literal.Value)
+		}
+	}
+
+	for _, input := range []string{"0x;", "0b;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		want := fmt.Sprintf("could not parse %q as integer", strings.TrimSuffix(input, ";"))
+		if errs := p.Errors(); len(errs) == 0 || errs[0] != want {
+			t.Errorf("input %q: expected error %q, got=%v", input, want, errs)
+		}
+	}
+}
+
+func TestDigitSeparators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"1_000;", int64(1000)},
+		{"1_000_000;", int64(1000000)},
+		{"0b1010_1010;", int64(170)},
+		{"3_141.592_6;", 3141.5926},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		switch expected := tt.expected.(type) {
+		case int64:
+			literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok || literal.Value != expected {
+				t.Errorf("input %q: expected integer %d, got=%s", tt.input, expected, stmt.Expression)
+			}
+		case float64:
+			literal, ok := stmt.Expression.(*ast.FloatLiteral)
+			if !ok || literal.Value != expected {
+				t.Errorf("input %q: expected float %g, got=%s", tt.input, expected, stmt.Expression)
+			}
+		}
+	}
+
+	for _, input := range []string{"1_;", "1__0;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		want := fmt.Sprintf("invalid digit separator in %s at 1:1", strings.TrimSuffix(input, ";"))
+		if errs := p.Errors(); len(errs) == 0 || errs[0] != want {
+			t.Errorf("input %q: expected error %q, got=%v", input, want, errs)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	program := New(lexer.New(`3.14;`)).ParseProgram()
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d\n", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != 3.14 {
+		t.Errorf("literal.Value not %f. got=%f", 3.14, literal.Value)
+	}
+	if literal.TokenLiteral() != "3.14" {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", "3.14", literal.TokenLiteral())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -160,6 +256,9 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{input: "5 - 5;", leftValue: 5, operator: "-", rightValue: 5},
 		{input: "5 * 5;", leftValue: 5, operator: "*", rightValue: 5},
 		{input: "5 / 5;", leftValue: 5, operator: "/", rightValue: 5},
+		{input: "5 ** 5;", leftValue: 5, operator: "**", rightValue: 5},
+		{"true && false", true, "&&", false},
+		{"true || false", true, "||", false},
 		{input: "5 > 5;", leftValue: 5, operator: ">", rightValue: 5},
 		{input: "5 < 5;", leftValue: 5, operator: "<", rightValue: 5},
 		{input: "5 == 5;", leftValue: 5, operator: "==", rightValue: 5},
@@ -201,6 +300,36 @@ func TestOperatorsPrecedenceParsing(t *testing.T) {
 		{
 			"-a * b", "((-a) * b)",
 		},
+		{
+			"- -5", "(-(-5))",
+		},
+		{
+			"a || b && c", "(a || (b && c))",
+		},
+		{
+			"a && b || c", "((a && b) || c)",
+		},
+		{
+			"a == b && c < d", "((a == b) && (c < d))",
+		},
+		{
+			"!a && b", "((!a) && b)",
+		},
+		{
+			"-2 ** 2", "(-(2 ** 2))",
+		},
+		{
+			"(-2) ** 2", "((-2) ** 2)",
+		},
+		{
+			"2 ** 3 ** 2", "(2 ** (3 ** 2))",
+		},
+		{
+			"a * b ** c", "(a * (b ** c))",
+		},
+		{
+			"a ** f(b)", "(a ** f(b))",
+		},
 		{
 			"!-a", "(!(-a))",
 		},
@@ -273,6 +402,15 @@ func TestOperatorsPrecedenceParsing(t *testing.T) {
 		{
 			"[][a]", "([][a])",
 		},
+		{
+			"a * b.c", "(a * (b.c))",
+		},
+		{
+			"-a.b", "(-(a.b))",
+		},
+		{
+			"a.b.c(1, 2)", "((a.b).c)(1, 2)",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -371,6 +509,157 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < 10) { let x = x + 1; }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statement. got=%d", len(stmt.Body.Statements))
+	}
+	if !testLetStatement(t, stmt.Body.Statements[0], "x") {
+		return
+	}
+	if program.String() != "while(x < 10) let x = (x + 1);" {
+		t.Errorf("wrong String(). got=%q", program.String())
+	}
+
+	p = New(lexer.New(`while (false) { }; 2`))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("trailing semicolon after while not skipped. got=%d statements", len(program.Statements))
+	}
+}
+
+func TestLoopExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = while (a) { b };", "let x = whilea b;"},
+		{"let y = for (let i = 0; i < 2; let i = i + 1) { i };", "let y = for(let i = 0; (i < 2); let i = (i + 1)) i;"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.LetStatement)
+		if _, ok := stmt.Value.(*ast.LoopExpression); !ok {
+			t.Fatalf("stmt.Value is not ast.LoopExpression. got=%T", stmt.Value)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("wrong String(). expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (let i = 0; i < 10; let i = i + 1) { puts(i); }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+	}
+	if !testLetStatement(t, stmt.Init, "i") {
+		return
+	}
+	if !testInfixExpression(t, stmt.Condition, "i", "<", 10) {
+		return
+	}
+	if !testLetStatement(t, stmt.Post, "i") {
+		return
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statement. got=%d", len(stmt.Body.Statements))
+	}
+	if program.String() != "for(let i = 0; (i < 10); let i = (i + 1)) puts(i)" {
+		t.Errorf("wrong String(). got=%q", program.String())
+	}
+
+	p = New(lexer.New(`for (let i = 0; i < 1; let i = i + 1) { }; 2`))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("trailing semicolon after for not skipped. got=%d statements", len(program.Statements))
+	}
+
+	for _, input := range []string{"for (let i = 0 i < 3; let i = i + 1) {}", "for (let i = 0; i < 3) {}", "for () {}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestLoopControlStatements(t *testing.T) {
+	input := `while (true) { for (let i = 0; i < 1; let i = i + 1) { continue; } break; }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.WhileStatement)
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("second statement is not ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+	inner := stmt.Body.Statements[0].(*ast.ForStatement)
+	if _, ok := inner.Body.Statements[0].(*ast.ContinueStatement); !ok {
+		t.Errorf("for body is not ast.ContinueStatement. got=%T", inner.Body.Statements[0])
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "break outside loop"},
+		{"if (true) { continue; }", "continue outside loop"},
+		{"while (true) { fn() { break; } }", "break outside loop"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.expected {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestUnclosedBlocks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (x) { 1", "expected } to close block opened at 1:8"},
+		{"if (x) { 1 } else {", "expected } to close block opened at 1:19"},
+		{"let f = fn(a) {\n  a + 1;\n", "expected } to close block opened at 1:15"},
+		{"while (true) { if (x) { 1 }", "expected } to close block opened at 1:14"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.expected {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y }`
 	l := lexer.New(input)
@@ -462,6 +751,36 @@ func TestCallExpressionParsing(t *testing.T) {
 	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
 
+func TestCallExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input        string
+		maxArguments int
+		expected     string
+	}{
+		{"add(1,)", DefaultMaxArguments, "expected expression after , got ) instead"},
+		{"add(1 2)", DefaultMaxArguments, "expected , or ) in list, got INT instead"},
+		{"[1, 2", DefaultMaxArguments, "expected , or ] in list, got EOF instead"},
+		{"add(1, 2, 3)", 2, "too many arguments in call to add: got=3, max=2"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.SetMaxArguments(tt.maxArguments)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected error for %q, got none", tt.input)
+			continue
+		}
+		if p.Errors()[0] != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors()[0])
+		}
+	}
+
+	p := New(lexer.New("add(1, 2)"))
+	p.SetMaxArguments(2)
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world"`
 
@@ -480,6 +799,46 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"line1\nline2"`, "line1\nline2"},
+		{`"a\tb\rc"`, "a\tb\rc"},
+		{`"a\"b"`, `a"b`},
+		{`"back\\slash"`, `back\slash`},
+		{`"nul\0"`, "nul\x00"},
+		{`"\\\""`, `\"`},
+		{`"猴\n子"`, "猴\n子"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.StringLiteral)
+		if !ok {
+			t.Fatalf("exp is not *ast.StringLiteral. Got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("wrong value for %s. got=%q, want=%q", tt.input, literal.Value, tt.expected)
+		}
+	}
+
+	p := New(lexer.New("let s = \"a\\xb\";\nlet t = \"\\q\";"))
+	p.ParseProgram()
+	expected := []string{"unknown escape sequence \\x at 1:11", "unknown escape sequence \\q at 2:10"}
+	if len(p.Errors()) != len(expected) {
+		t.Fatalf("wrong errors. got=%q", p.Errors())
+	}
+	for i, msg := range expected {
+		if p.Errors()[i] != msg {
+			t.Errorf("errors[%d] wrong. got=%q, want=%q", i, p.Errors()[i], msg)
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	l := lexer.New(input)
@@ -518,6 +877,57 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:2]", "(a[1:2])"},
+		{"a[:2]", "(a[:2])"},
+		{"a[1:]", "(a[1:])"},
+		{"a[:]", "(a[:])"},
+		{"a[i + 1:-1][0]", "((a[(i + 1):(-1)])[0])"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if got := stmt.Expression.String(); got != tt.expected {
+			t.Errorf("wrong slice for %s: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestParsingIndexAssignment(t *testing.T) {
+	input := "m[0][1] = a[2] = 5 + 1"
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	assign, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.AssignExpression. Got=%T", stmt.Expression)
+	}
+	if !testIntegerLiteral(t, assign.Target.Index, 1) {
+		return
+	}
+	if _, ok := assign.Value.(*ast.AssignExpression); !ok {
+		t.Fatalf("assignment is not right associative. value=%T", assign.Value)
+	}
+	if program.String() != "((m[0])[1]) = (a[2]) = (5 + 1)" {
+		t.Errorf("wrong String(). got=%q", program.String())
+	}
+
+	for _, input := range []string{"x = 1", "f() = 1", "a[0] + 1 = 2"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || !strings.HasPrefix(p.Errors()[0], "invalid assignment target") {
+			t.Errorf("expected invalid assignment target error for %q. got=%v", input, p.Errors())
+		}
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2}`
 	l := lexer.New(input)
@@ -630,6 +1040,92 @@ func TestFunctionLiteralWithName(t *testing.T) {
 	}
 }
 
+func TestParsingGroupedExpressions(t *testing.T) {
+	tests := []struct {
+		input          string
+		expected       string
+		expectedErrors []string
+	}{
+		{"(5)", "5", nil},
+		{"((5))", "5", nil},
+		{"()", "", []string{"empty parenthesized expression"}},
+		{"1 + ()", "", []string{"empty parenthesized expression"}},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(tt.expectedErrors) == 0 {
+			checkParserErrors(t, p)
+			if program.String() != tt.expected {
+				t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+			}
+			continue
+		}
+		if len(p.Errors()) != len(tt.expectedErrors) {
+			t.Errorf("wrong number of errors for %q. want=%v, got=%v", tt.input, tt.expectedErrors, p.Errors())
+			continue
+		}
+		for i, msg := range tt.expectedErrors {
+			if p.Errors()[i] != msg {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, msg, p.Errors()[i])
+			}
+		}
+	}
+}
+
+func TestParsingFromReader(t *testing.T) {
+	input := `let add = fn(a, b) { a + b }; add(1, 2 * 3); "世界"[0]`
+	expected := New(lexer.New(input)).ParseProgram()
+	p := New(lexer.NewReader(iotest.OneByteReader(strings.NewReader(input))))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != expected.String() {
+		t.Errorf("reader program differs. expected=%q, got=%q", expected.String(), program.String())
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b;
+};
+add(1, [2][0]).name;`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	function := let.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	infix := body.Expression.(*ast.InfixExpression)
+	property := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.PropertyExpression)
+	call := property.Left.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{program, "1:1"},
+		{let, "1:1"},
+		{let.Name, "1:5"},
+		{function, "1:11"},
+		{function.Parameters[1], "1:17"},
+		{function.Body, "1:20"},
+		{infix, "2:3"},
+		{infix.Right, "2:7"},
+		{property, "4:1"},
+		{call, "4:1"},
+		{call.Arguments[0], "4:5"},
+		{index, "4:8"},
+		{index.Index, "4:12"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.expected {
+			t.Errorf("wrong position for %q. expected=%s, got=%s", tt.node.String(), tt.expected, got)
+		}
+	}
+}
+
 // testLetStatement 测试解析let表达式
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
@@ -770,3 +1266,42 @@ func testInfixExpression(t *testing.T, exp ast.Expression, left any, operator st
 	}
 	return true
 }
+
+func TestLeadingComments(t *testing.T) {
+	input := `// add returns the sum of a and b.
+//
+//   add(1, 2) == 3
+let add = fn(a, b) {
+	// the body
+	a + b; // trailing
+};
+
+// detached by the blank line
+
+let x = add(1, 2); // not a doc comment
+x
+// at the end
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
+	}
+	expected := []string{"add returns the sum of a and b.\n\nadd(1, 2) == 3", "", ""}
+	for i, stmt := range program.Statements {
+		if doc := ast.Doc(stmt); doc != expected[i] {
+			t.Errorf("statements[%d] doc wrong. expected=%q, got=%q", i, expected[i], doc)
+		}
+	}
+	fn := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if doc := ast.Doc(fn.Body.Statements[0]); doc != "the body" {
+		t.Errorf("body statement doc wrong. got=%q", doc)
+	}
+	if doc := ast.Doc(fn); doc != "" {
+		t.Errorf("expressions should not carry comments. got=%q", doc)
+	}
+	if program.String() != "let add = fn<add>(a, b) (a + b);let x = add(1, 2);x" {
+		t.Errorf("comments leaked into String(). got=%q", program.String())
+	}
+}
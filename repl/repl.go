@@ -2,9 +2,13 @@ package repl
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"monkey/ast"
 	"monkey/compiler"
 	"monkey/evaluator"
 	"monkey/lexer"
@@ -18,65 +22,209 @@ const elephant = `
 		( ͡° ͜ʖ ͡°)
 `
 
-func StartNew(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// plainElephant 输出不支持 UTF-8 时使用的纯 ASCII 横幅
+const plainElephant = `
+		( o_o )
+`
 
-	var constants []object.Object
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+// ANSI 颜色，结果和错误使用不同的颜色
+const (
+	colorResult = "\x1b[32m"
+	colorError  = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
+// 出错的阶段，用于 :errors json 模式输出的 stage 字段
+const (
+	stageParse   = "parse"
+	stageCompile = "compile"
+	stageVM      = "vm"
+	stageEval    = "eval"
+)
+
+// errorPrefixes 文本模式下非解析阶段错误的前缀
+var errorPrefixes = map[string]string{
+	stageCompile: "Compiler error",
+	stageVM:      "VM error",
+	stageEval:    "Eval error",
+}
+
+// options REPL 的显示设置，由环境变量 MONKEY_PROMPT、MONKEY_COLOR 和 MONKEY_BANNER 初始化，
+// 可在会话中通过 :prompt、:color、:errors 和 :bytecode 元命令修改
+type options struct {
+	prompt       string
+	color        bool
+	banner       string // 解析错误前输出的横幅
+	jsonErrors   bool   // 错误以每行一个 JSON 对象的形式输出，便于工具解析
+	showBytecode bool   // 虚拟机执行前输出编译得到的指令和常量池
+}
+
+// newOptions 根据环境变量创建显示设置，颜色默认只在输出为终端时开启
+func newOptions(out io.Writer) *options {
+	o := &options{prompt: prompt, color: IsTerminal(out), banner: elephant}
+	if !localeSupportsUTF8() {
+		o.banner = plainElephant
+	}
+	switch os.Getenv("MONKEY_BANNER") {
+	case "plain":
+		o.banner = plainElephant
+	case "fancy":
+		o.banner = elephant
+	}
+	if p := os.Getenv("MONKEY_PROMPT"); p != "" {
+		o.prompt = p
 	}
+	switch os.Getenv("MONKEY_COLOR") {
+	case "on":
+		o.color = true
+	case "off":
+		o.color = false
+	}
+	return o
+}
 
-	for {
-		_, err := fmt.Fprintf(out, prompt)
-		if err != nil {
-			return
+// IsTerminal 判断输入或输出是否为终端，只有 *os.File 可能是终端
+func IsTerminal(stream any) bool {
+	f, ok := stream.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// localeSupportsUTF8 根据 LC_ALL、LC_CTYPE 和 LANG 判断输出是否使用 UTF-8 编码，
+// 与 C 库一致取第一个非空的变量
+func localeSupportsUTF8() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			locale = strings.ToLower(locale)
+			return strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8")
 		}
-		scanned := scanner.Scan()
-		if !scanned {
-			return
+	}
+	return false
+}
+
+// handleCommand 处理修改显示设置的元命令
+func (o *options) handleCommand(out io.Writer, name, arg string) {
+	switch name {
+	case ":prompt":
+		if arg == "" {
+			arg = prompt
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
+		o.prompt = arg
+	case ":color":
+		switch strings.TrimSpace(arg) {
+		case "on":
+			o.color = true
+		case "off":
+			o.color = false
+		default:
+			o.writeError(out, "usage: :color on|off\n")
 		}
-		comp := compiler.NewWithState(symbolTable, constants)
-		err = comp.Compile(program)
-		if err != nil {
-			_, _ = fmt.Fprintf(out, "Compiler error: %s\n", err)
-			continue
+	case ":errors":
+		switch strings.TrimSpace(arg) {
+		case "json":
+			o.jsonErrors = true
+		case "text":
+			o.jsonErrors = false
+		default:
+			o.writeError(out, "usage: :errors json|text\n")
 		}
-
-		code := comp.Bytecode()
-		constants = code.Constants
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			_, _ = fmt.Fprintf(out, "VM error: %s\n", err)
-			continue
+	case ":bytecode":
+		switch strings.TrimSpace(arg) {
+		case "":
+			o.showBytecode = !o.showBytecode
+		case "on":
+			o.showBytecode = true
+		case "off":
+			o.showBytecode = false
+		default:
+			o.writeError(out, "usage: :bytecode [on|off]\n")
 		}
-		stackTop := machine.LastPoppedStackElem()
-		_, err = io.WriteString(out, stackTop.Inspect())
-		if err != nil {
-			continue
+	case ":step":
+		o.writeError(out, ":step requires the vm engine\n")
+	default:
+		o.writeError(out, fmt.Sprintf("unknown command: %s\n", name))
+	}
+}
+
+// errorReport :errors json 模式下输出的一条错误
+type errorReport struct {
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+	Input   int    `json:"input"` // 出错的输入在会话中的序号，源代码位置已包含在 message 中
+}
+
+// reportErrors 输出某一阶段的错误，input 为出错的输入在会话中的序号。
+// JSON 模式下每条错误输出一行且不加颜色，文本模式下解析错误带横幅，其余错误带阶段前缀
+func (o *options) reportErrors(out io.Writer, stage string, input int, messages ...string) {
+	if o.jsonErrors {
+		enc := json.NewEncoder(out)
+		for _, msg := range messages {
+			_ = enc.Encode(errorReport{Stage: stage, Message: msg, Input: input})
 		}
-		_, err = io.WriteString(out, "\n")
-		if err != nil {
-			continue
+		return
+	}
+	if stage == stageParse {
+		printParserErrors(out, o, messages)
+		return
+	}
+	for _, msg := range messages {
+		o.writeError(out, fmt.Sprintf("%s: %s\n", errorPrefixes[stage], msg))
+	}
+}
+
+// writeResult 输出结果，开启颜色时使用结果颜色
+func (o *options) writeResult(out io.Writer, s string) error {
+	return o.write(out, colorResult, s)
+}
+
+// writeError 输出错误，开启颜色时使用错误颜色
+func (o *options) writeError(out io.Writer, s string) {
+	_ = o.write(out, colorError, s)
+}
+
+// write 输出文本，开启颜色时用指定颜色包裹，换行符留在颜色之外
+func (o *options) write(out io.Writer, color, s string) error {
+	if o.color {
+		body := strings.TrimSuffix(s, "\n")
+		s = color + body + colorReset + s[len(body):]
+	}
+	_, err := io.WriteString(out, s)
+	return err
+}
+
+// writeObject 输出 stage 阶段得到的结果，错误对象使用错误颜色，JSON 模式下与其他错误一样按 JSON 输出
+func (o *options) writeObject(out io.Writer, stage string, input int, obj object.Object) error {
+	if obj.Type() == object.ErrorObj {
+		if o.jsonErrors {
+			o.reportErrors(out, stage, input, strings.TrimPrefix(obj.Inspect(), "ErrorObj: "))
+			return nil
 		}
+		return o.write(out, colorError, obj.Inspect()+"\n")
 	}
+	return o.writeResult(out, obj.Inspect()+"\n")
+}
+
+// StartNew 启动使用编译器和虚拟机执行的 REPL
+func StartNew(in io.Reader, out io.Writer) {
+	start(in, out, engineVM)
 }
+
+// Start 启动使用求值器执行的 REPL
 func Start(in io.Reader, out io.Writer) {
+	start(in, out, engineEval)
+}
+
+// start 启动 REPL，会话中可以通过 :engine 切换执行引擎
+func start(in io.Reader, out io.Writer, engine string) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	opts := newOptions(out)
+	s := newSession(engine)
 
-	for {
-		_, err := fmt.Fprintf(out, prompt)
+	for lineNo := 1; ; lineNo++ {
+		_, err := io.WriteString(out, opts.prompt)
 		if err != nil {
 			return
 		}
@@ -85,40 +233,277 @@ func Start(in io.Reader, out io.Writer) {
 			return
 		}
 		line := scanner.Text()
+		name, arg, _ := strings.Cut(line, " ")
+		step := name == ":step" && s.engine == engineVM
+		showAST := name == ":ast"
+		if step || showAST {
+			line = arg
+			if strings.TrimSpace(line) == "" {
+				opts.writeError(out, fmt.Sprintf("usage: %s <code>\n", name))
+				continue
+			}
+		} else {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			handled, quit := s.handleCommand(out, opts, line)
+			if quit {
+				return
+			}
+			if handled {
+				continue
+			}
+		}
 		l := lexer.New(line)
 		p := parser.New(l)
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			opts.reportErrors(out, stageParse, lineNo, p.Errors()...)
+			continue
+		}
+		if showAST {
+			_, _ = fmt.Fprintf(out, "%s\n%s", program.String(), ast.Tree(program))
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			_, err = io.WriteString(out, evaluated.Inspect())
-			if err != nil {
+		if len(program.Statements) == 0 {
+			continue
+		}
+		if s.engine == engineEval {
+			if !s.eval(out, opts, lineNo, program) {
 				return
 			}
-			_, err = io.WriteString(out, "\n")
-			if err != nil {
-				return
+			continue
+		}
+		var stepper *bufio.Scanner
+		if step {
+			stepper = scanner
+		}
+		if !s.run(out, opts, lineNo, program, stepper) {
+			return
+		}
+	}
+}
+
+// 可以在会话中切换的执行引擎
+const (
+	engineVM   = "vm"
+	engineEval = "eval"
+)
+
+// session REPL 会话的状态，两种引擎的全局状态都保留，切换引擎时迁移已定义的全局变量
+type session struct {
+	engine string
+
+	env *object.Environment // 求值器的全局环境
+
+	symbolTable *compiler.SymbolTable // 虚拟机的全局符号表、常量池和全局变量
+	constants   []object.Object
+	globals     []object.Object
+}
+
+// newSession 创建使用指定引擎的会话
+func newSession(engine string) *session {
+	s := &session{engine: engine, globals: make([]object.Object, vm.GlobalsSize)}
+	s.reset()
+	return s
+}
+
+// handleCommand 处理以 : 开头的元命令，会话相关的命令在这里处理，显示设置交给 options。
+// handled 为 false 表示该行不是元命令，quit 为 true 表示应结束会话
+func (s *session) handleCommand(out io.Writer, opts *options, line string) (handled, quit bool) {
+	if !strings.HasPrefix(line, ":") {
+		return false, false
+	}
+	name, arg, _ := strings.Cut(line, " ")
+	switch name {
+	case ":quit", ":exit":
+		return true, true
+	case ":reset":
+		s.reset()
+	case ":help":
+		writeHelp(out)
+	case ":engine":
+		s.useEngine(out, opts, strings.TrimSpace(arg))
+	default:
+		opts.handleCommand(out, name, arg)
+	}
+	return true, false
+}
+
+// reset 清空两种引擎中定义的全局变量和常量池，回到会话开始时的状态
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbolTable.DefineBuiltin(i, v.Name)
+	}
+	s.constants = nil
+	clear(s.globals)
+}
+
+// commandHelp :help 列出的元命令及说明
+var commandHelp = [][2]string{
+	{":help", "show this help"},
+	{":quit, :exit", "leave the REPL"},
+	{":reset", "forget all definitions"},
+	{":engine vm|eval", "switch the execution engine"},
+	{":step <code>", "run code one instruction at a time (vm engine)"},
+	{":ast <code>", "print the parsed AST without running it"},
+	{":bytecode [on|off]", "print compiled bytecode before running (vm engine)"},
+	{":prompt [text]", "change the prompt"},
+	{":color on|off", "toggle colored output"},
+	{":errors json|text", "choose the error format"},
+}
+
+// writeHelp 输出元命令和内置函数的列表
+func writeHelp(out io.Writer) {
+	var help strings.Builder
+	help.WriteString("commands:\n")
+	for _, command := range commandHelp {
+		_, _ = fmt.Fprintf(&help, "  %-18s %s\n", command[0], command[1])
+	}
+	names := make([]string, 0, len(object.Builtins))
+	for _, builtin := range object.Builtins {
+		if builtin.Name != "" {
+			names = append(names, builtin.Name)
+		}
+	}
+	_, _ = fmt.Fprintf(&help, "builtins:\n  %s\n", strings.Join(names, ", "))
+	_, _ = io.WriteString(out, help.String())
+}
+
+// useEngine 切换执行引擎，并把已定义的全局变量迁移到新引擎中。
+// 求值器不能调用虚拟机的闭包，这些函数不会迁移到求值器
+func (s *session) useEngine(out io.Writer, opts *options, engine string) {
+	switch engine {
+	case s.engine:
+	case engineEval:
+		var skipped []string
+		for _, symbol := range s.symbolTable.GlobalSymbols() {
+			value := s.globals[symbol.Index]
+			if _, ok := value.(*object.Closure); ok {
+				skipped = append(skipped, symbol.Name)
+				continue
 			}
+			if value != nil {
+				s.env.Set(symbol.Name, value)
+			}
+		}
+		if len(skipped) != 0 {
+			opts.writeError(out, fmt.Sprintf("functions not carried over to eval: %s\n", strings.Join(skipped, ", ")))
 		}
+	case engineVM:
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			symbol := s.symbolTable.Define(name)
+			s.globals[symbol.Index] = value
+		}
+	default:
+		opts.writeError(out, "usage: :engine vm|eval\n")
+		return
 	}
+	s.engine = engine
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	_, err := io.WriteString(out, elephant+"\n")
+// eval 用求值器执行一行输入并输出结果，返回 false 表示输出失败、应结束会话
+func (s *session) eval(out io.Writer, opts *options, lineNo int, program *ast.Program) bool {
+	evaluated, err := evaluator.SafeEval(program, s.env)
 	if err != nil {
-		return
+		opts.reportErrors(out, stageEval, lineNo, err.Error())
+		return true
+	}
+	if evaluated != nil {
+		return opts.writeObject(out, stageEval, lineNo, evaluated) == nil
 	}
-	_, err = io.WriteString(out, "parser errors:\n")
+	return true
+}
+
+// run 编译一行输入并在虚拟机上执行，输出结果。stepper 不为 nil 时逐条执行指令，
+// 从中读取用户的回车；返回 false 表示输入结束、应结束会话
+func (s *session) run(out io.Writer, opts *options, lineNo int, program *ast.Program, stepper *bufio.Scanner) bool {
+	comp := compiler.NewWithState(s.symbolTable, s.constants)
+	err := comp.Compile(program)
 	if err != nil {
-		return
+		opts.reportErrors(out, stageCompile, lineNo, err.Error())
+		return true
 	}
-	for _, msg := range errors {
-		_, err := io.WriteString(out, "\t"+msg+"\n")
-		if err != nil {
-			return
+
+	code := comp.Bytecode()
+	s.constants = code.Constants
+	if opts.showBytecode {
+		_, _ = fmt.Fprintf(out, "Instructions:\n%sConstants:\n%s", code.Instructions, code.ConstantsString())
+	}
+	machine := vm.NewWithGlobalsStore(code, s.globals)
+	if stepper != nil {
+		err = stepRun(stepper, out, machine)
+		if err == io.EOF {
+			return false
+		}
+	} else {
+		err = machine.SafeRun()
+	}
+	if err != nil {
+		opts.reportErrors(out, stageVM, lineNo, err.Error())
+		return true
+	}
+	if !producesValue(program) {
+		return true
+	}
+	stackTop := machine.LastPoppedStackElem()
+	if stackTop == nil {
+		return true
+	}
+	_ = opts.writeObject(out, stageVM, lineNo, stackTop)
+	return true
+}
+
+// stepRun 逐条执行指令，每执行一条输出该指令和执行后的栈，并等待用户按回车继续，
+// 等待时输入结束返回 io.EOF
+func stepRun(scanner *bufio.Scanner, out io.Writer, machine *vm.VM) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("vm panicked: %v", r)
+		}
+	}()
+	for {
+		ins, ip := machine.Position()
+		done, stepErr := machine.Step()
+		if stepErr != nil {
+			return stepErr
+		}
+		elements := make([]string, len(machine.Stack()))
+		for i, obj := range machine.Stack() {
+			elements[i] = obj.Inspect()
 		}
+		_, _ = fmt.Fprintf(out, "%s\n  stack: [%s]\n", ins.InstructionAt(ip), strings.Join(elements, ", "))
+		if done {
+			return nil
+		}
+		if !scanner.Scan() {
+			return io.EOF
+		}
+	}
+}
+
+// producesValue 判断程序是否以表达式、return 或循环语句结尾，只有这样才有结果可输出，
+// 与求值器对 let 语句和空输入不输出任何内容的行为保持一致
+func producesValue(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement, *ast.WhileStatement, *ast.ForStatement:
+		return true
+	}
+	return false
+}
+
+func printParserErrors(out io.Writer, opts *options, errors []string) {
+	var msg strings.Builder
+	msg.WriteString(opts.banner + "\n")
+	msg.WriteString("parser errors:\n")
+	for _, e := range errors {
+		msg.WriteString("\t" + e + "\n")
 	}
+	opts.writeError(out, msg.String())
 }
@@ -0,0 +1,337 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEnginesProduceSameOutput(t *testing.T) {
+	script := strings.Join([]string{
+		`let x = 5;`,
+		`x`,
+		`let y = x * 2;`,
+		`y + 1`,
+		``,
+		`"mon" + "key"`,
+		`let add = fn(a, b) { a + b };`,
+		`add(x, y)`,
+		`[1, 2, 3]`,
+		`let z = 1; z`,
+		`z; let w = 2;`,
+		`return x + 1;`,
+	}, "\n") + "\n"
+
+	var evalOut, vmOut bytes.Buffer
+	Start(strings.NewReader(script), &evalOut)
+	StartNew(strings.NewReader(script), &vmOut)
+
+	if evalOut.String() != vmOut.String() {
+		t.Fatalf("engines disagree.\neval:\n%s\nvm:\n%s", evalOut.String(), vmOut.String())
+	}
+
+	expected := strings.Repeat(prompt, 2) + "5\n" +
+		strings.Repeat(prompt, 2) + "11\n" +
+		strings.Repeat(prompt, 2) + "monkey\n" +
+		strings.Repeat(prompt, 2) + "15\n" +
+		prompt + "[1, 2, 3]\n" +
+		prompt + "1\n" +
+		strings.Repeat(prompt, 2) + "6\n" +
+		prompt
+	if vmOut.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", vmOut.String(), expected)
+	}
+}
+
+func TestBlankLinesAreSkipped(t *testing.T) {
+	script := "\n   \n\t\n1\n \t \n"
+	expected := strings.Repeat(prompt, 4) + "1\n" + strings.Repeat(prompt, 2)
+
+	for name, start := range map[string]func(in io.Reader, out io.Writer){
+		"eval": Start,
+		"vm":   StartNew,
+	} {
+		var out bytes.Buffer
+		start(strings.NewReader(script), &out)
+		if out.String() != expected {
+			t.Errorf("%s: wrong output.\ngot:\n%q\nwant:\n%q", name, out.String(), expected)
+		}
+	}
+}
+
+func TestEvalPanicDoesNotEndSession(t *testing.T) {
+	script := "1 / 0\n1 + 1\n"
+	expected := prompt + "Eval error: evaluation panicked: runtime error: integer divide by zero\n" +
+		prompt + "2\n" + prompt
+
+	var out bytes.Buffer
+	Start(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+}
+
+func TestVMPanicDoesNotEndSession(t *testing.T) {
+	script := "1 / 0\n1 + 1\n"
+	expected := prompt + "VM error: vm panicked at ip 6: runtime error: integer divide by zero\n" +
+		prompt + "2\n" + prompt
+
+	var out bytes.Buffer
+	StartNew(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+}
+
+func TestColorOption(t *testing.T) {
+	t.Setenv("MONKEY_COLOR", "")
+	t.Setenv("MONKEY_BANNER", "fancy")
+	script := "1\n:color on\n2\nlet = 1\n:color off\n3\n"
+
+	for name, start := range map[string]func(in io.Reader, out io.Writer){
+		"eval": Start,
+		"vm":   StartNew,
+	} {
+		var out bytes.Buffer
+		start(strings.NewReader(script), &out)
+		got := out.String()
+		before, after, _ := strings.Cut(got, colorResult)
+		if before != prompt+"1\n"+strings.Repeat(prompt, 2) {
+			t.Errorf("%s: output colored before :color on. got=%q", name, got)
+		}
+		if !strings.HasPrefix(after, "2"+colorReset+"\n") {
+			t.Errorf("%s: result not colored. got=%q", name, got)
+		}
+		if !strings.Contains(got, colorError+elephant) {
+			t.Errorf("%s: parser errors not colored. got=%q", name, got)
+		}
+		if !strings.HasSuffix(got, colorReset+"\n"+strings.Repeat(prompt, 2)+"3\n"+prompt) {
+			t.Errorf("%s: output colored after :color off. got=%q", name, got)
+		}
+	}
+
+	t.Setenv("MONKEY_COLOR", "on")
+	var out bytes.Buffer
+	StartNew(strings.NewReader("1\n"), &out)
+	if !strings.Contains(out.String(), colorResult+"1"+colorReset) {
+		t.Errorf("MONKEY_COLOR=on did not enable color. got=%q", out.String())
+	}
+}
+
+func TestPromptOption(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	script := ":prompt monkey> \n1\n:prompt\n2\n:unknown\n"
+	expected := prompt + "monkey> 1\nmonkey> " + prompt + "2\n" + prompt + "unknown command: :unknown\n" + prompt
+
+	var out bytes.Buffer
+	Start(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+
+	t.Setenv("MONKEY_PROMPT", "$ ")
+	out.Reset()
+	StartNew(strings.NewReader("1\n"), &out)
+	if out.String() != "$ 1\n$ " {
+		t.Errorf("MONKEY_PROMPT not used. got=%q", out.String())
+	}
+}
+
+func TestPlainBanner(t *testing.T) {
+	tests := []struct {
+		lang, banner string
+		expected     string
+	}{
+		{"C", "", plainElephant},
+		{"", "", plainElephant},
+		{"en_US.UTF-8", "", elephant},
+		{"zh_CN.utf8", "", elephant},
+		{"en_US.UTF-8", "plain", plainElephant},
+		{"C", "fancy", elephant},
+	}
+	for _, tt := range tests {
+		t.Setenv("LC_ALL", "")
+		t.Setenv("LC_CTYPE", "")
+		t.Setenv("LANG", tt.lang)
+		t.Setenv("MONKEY_BANNER", tt.banner)
+		var out bytes.Buffer
+		StartNew(strings.NewReader("let = 1\n"), &out)
+		if !strings.HasPrefix(out.String(), prompt+tt.expected+"\nparser errors:\n") {
+			t.Errorf("LANG=%q MONKEY_BANNER=%q: wrong banner. got=%q", tt.lang, tt.banner, out.String())
+		}
+	}
+
+	if strings.ContainsFunc(plainElephant, func(r rune) bool { return r > 127 }) {
+		t.Errorf("plain banner is not ASCII: %q", plainElephant)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	t.Setenv("MONKEY_COLOR", "on")
+	script := "1\n:errors json\nlet = 1\n-true\n:errors text\n-true\n:errors xml\n"
+	expected := prompt + colorResult + "1" + colorReset + "\n" + prompt + prompt +
+		`{"stage":"parse","message":"expected next token to be IDENT, got = instead","input":3}` + "\n" +
+		`{"stage":"parse","message":"no prefix parse function for = found","input":3}` + "\n" + prompt +
+		`{"stage":"vm","message":"[line 1:1] unsupported type for negation: BOOLEAN","input":4}` + "\n" + prompt + prompt +
+		colorError + "VM error: [line 1:1] unsupported type for negation: BOOLEAN" + colorReset + "\n" + prompt +
+		colorError + "usage: :errors json|text" + colorReset + "\n" + prompt
+
+	var out bytes.Buffer
+	StartNew(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+
+	// 内置函数返回的错误值和求值器的错误对象也按 JSON 输出
+	script = ":errors json\nlen(1)\n-true\n"
+	tests := []struct {
+		start    func(io.Reader, io.Writer)
+		expected string
+	}{
+		{StartNew, prompt + prompt +
+			`{"stage":"vm","message":"argument to ` + "`len`" + ` not supported, got INTEGER","input":2}` + "\n" + prompt +
+			`{"stage":"vm","message":"[line 1:1] unsupported type for negation: BOOLEAN","input":3}` + "\n" + prompt},
+		{Start, prompt + prompt +
+			`{"stage":"eval","message":"argument to ` + "`len`" + ` not supported, got INTEGER","input":2}` + "\n" + prompt +
+			`{"stage":"eval","message":"[line 1:1] unsupported operator: -BOOLEAN","input":3}` + "\n" + prompt},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		tt.start(strings.NewReader(script), &out)
+		if out.String() != tt.expected {
+			t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), tt.expected)
+		}
+	}
+}
+
+func TestStepCommand(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	t.Setenv("MONKEY_COLOR", "off")
+	script := "let x = 1\n:step x + 2\n\n\n\nx\n:step\n"
+	expected := prompt + prompt +
+		"0000 OpGetGlobal 0\n  stack: [1]\n" +
+		"0003 OpConstant 1\n  stack: [1, 2]\n" +
+		"0006 OpAdd\n  stack: [3]\n" +
+		"0007 OpPop\n  stack: []\n" +
+		"3\n" + prompt + "1\n" + prompt +
+		"usage: :step <code>\n" + prompt
+
+	var out bytes.Buffer
+	StartNew(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+
+	out.Reset()
+	StartNew(strings.NewReader(":step 1 + 2\n"), &out)
+	if !strings.HasSuffix(out.String(), "0000 OpConstant 0\n  stack: [1]\n") {
+		t.Errorf("stepping should stop when input ends. got=%q", out.String())
+	}
+}
+
+func TestASTCommand(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	t.Setenv("MONKEY_COLOR", "off")
+	script := ":ast 1 + 2 * x\nx\n:ast let = 1\n:ast\n"
+	for _, startFn := range []func(io.Reader, io.Writer){Start, StartNew} {
+		var out bytes.Buffer
+		startFn(strings.NewReader(script), &out)
+		got := out.String()
+		tree := prompt + "(1 + (2 * x))\n" +
+			"Program\n" +
+			"  Statements[0]: ExpressionStatement\n" +
+			"    Expression: InfixExpression +\n" +
+			"      Left: IntegerLiteral 1\n" +
+			"      Right: InfixExpression *\n" +
+			"        Left: IntegerLiteral 2\n" +
+			"        Right: Identifier x\n" +
+			prompt
+		if !strings.HasPrefix(got, tree) {
+			t.Errorf(":ast printed the wrong tree. got=%q", got)
+		}
+		for _, want := range []string{"identifier not found: x", "parser errors:", "usage: :ast <code>\n"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("output missing %q. got=%q", want, got)
+			}
+		}
+	}
+}
+
+func TestBytecodeCommand(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	t.Setenv("MONKEY_COLOR", "off")
+	script := ":bytecode\n1 + 2\n:bytecode off\n3\n:bytecode maybe\n"
+	expected := prompt + prompt +
+		"Instructions:\n" +
+		"0000 OpConstant 0\n" +
+		"0003 OpConstant 1\n" +
+		"0006 OpAdd\n" +
+		"0007 OpPop\n" +
+		"Constants:\n" +
+		"0000 INTEGER 1\n" +
+		"0001 INTEGER 2\n" +
+		"3\n" + prompt + prompt + "3\n" + prompt +
+		"usage: :bytecode [on|off]\n" + prompt
+
+	var out bytes.Buffer
+	StartNew(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+}
+
+func TestEngineCommand(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	t.Setenv("MONKEY_COLOR", "off")
+	script := strings.Join([]string{
+		`let x = 2`,
+		`:engine eval`,
+		`x * 3`,
+		`let f = fn(a) { a + x }`,
+		`:step f(1)`,
+		`:engine vm`,
+		`f(1)`,
+		`let g = fn() { x }`,
+		`:engine eval`,
+		`g`,
+		`:engine lisp`,
+	}, "\n") + "\n"
+	expected := strings.Repeat(prompt, 3) + "6\n" + strings.Repeat(prompt, 2) +
+		":step requires the vm engine\n" + strings.Repeat(prompt, 2) + "3\n" + strings.Repeat(prompt, 2) +
+		"functions not carried over to eval: g\n" + prompt +
+		"ErrorObj: [line 1:1] identifier not found: g\n" + prompt +
+		"usage: :engine vm|eval\n" + prompt
+
+	var out bytes.Buffer
+	StartNew(strings.NewReader(script), &out)
+	if out.String() != expected {
+		t.Errorf("wrong output.\ngot:\n%q\nwant:\n%q", out.String(), expected)
+	}
+}
+
+func TestSessionCommands(t *testing.T) {
+	t.Setenv("MONKEY_PROMPT", "")
+	t.Setenv("MONKEY_COLOR", "off")
+	for _, startFn := range []func(io.Reader, io.Writer){Start, StartNew} {
+		script := "let x = 1\n\"s\"\n:reset\nx\nlet y = 2\ny\n:quit\n3\n"
+		var out bytes.Buffer
+		startFn(strings.NewReader(script), &out)
+		got := out.String()
+		if !strings.Contains(got, "identifier not found: x") {
+			t.Errorf(":reset did not forget x. got=%q", got)
+		}
+		if !strings.HasSuffix(got, "2\n"+prompt) {
+			t.Errorf(":quit did not end the session. got=%q", got)
+		}
+	}
+
+	var out bytes.Buffer
+	StartNew(strings.NewReader(":help\n:exit\n"), &out)
+	for _, want := range []string{"commands:\n", "  :quit, :exit", ":reset", "builtins:\n  len, puts, first", "sortBy"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf(":help output missing %q. got=%q", want, out.String())
+		}
+	}
+}
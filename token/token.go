@@ -1,11 +1,15 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // 从 // 到行尾的注释
 
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
 	ASSIGN   = "="
@@ -13,14 +17,18 @@ const (
 	MINUS    = "-"
 	BANG     = "!"
 	ASTERISK = "*"
+	POW      = "**"
 	SLASH    = "/"
 	LT       = "<"
 	GT       = ">"
 
 	EQ     = "=="
 	NOT_EQ = "!="
+	AND    = "&&"
+	OR     = "||"
 
 	COMMA     = ","
+	DOT       = "."
 	SEMICOLON = ";"
 	COLON     = ":"
 
@@ -38,15 +46,41 @@ const (
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 )
 
 // TypeToken 标记类型
 type TypeToken string
 
+// String 返回标记类型的字符串
+func (t TypeToken) String() string {
+	return string(t)
+}
+
+// Position 标记在源代码中的位置，行和列均从 1 开始，列按字节计算
+type Position struct {
+	Line   int
+	Column int
+}
+
+// String 以 line:column 的形式返回位置
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 // Token 标记
 type Token struct {
 	Type    TypeToken
 	Literal string
+	Pos     Position // 标记第一个字符的位置
+}
+
+// String 以 {Type Literal} 的形式返回标记的字符串
+func (t Token) String() string {
+	return fmt.Sprintf("{%s %s}", t.Type, t.Literal)
 }
 
 // New 创建标记
@@ -60,13 +94,17 @@ func NewString(typeToken TypeToken, str string) Token {
 }
 
 var keywords = map[string]TypeToken{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 // LookupIdent 返回关键字或标识符的类型
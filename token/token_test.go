@@ -0,0 +1,53 @@
+package token
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLookupIdent(t *testing.T) {
+	tests := []struct {
+		ident    string
+		expected TypeToken
+	}{
+		{"fn", FUNCTION},
+		{"let", LET},
+		{"true", TRUE},
+		{"false", FALSE},
+		{"if", IF},
+		{"else", ELSE},
+		{"return", RETURN},
+		{"foobar", IDENT},
+		{"Let", IDENT},
+		{"fn1", IDENT},
+		{"_", IDENT},
+	}
+	for _, tt := range tests {
+		if got := LookupIdent(tt.ident); got != tt.expected {
+			t.Errorf("LookupIdent(%q) wrong. got=%q, want=%q", tt.ident, got, tt.expected)
+		}
+	}
+}
+
+func TestTokenString(t *testing.T) {
+	tests := []struct {
+		tok      Token
+		expected string
+	}{
+		{NewString(LET, "let"), "{LET let}"},
+		{New(PLUS, '+'), "{+ +}"},
+		{NewString(IDENT, "x"), "{IDENT x}"},
+		{NewString(EOF, ""), "{EOF }"},
+	}
+	for _, tt := range tests {
+		if tt.tok.String() != tt.expected {
+			t.Errorf("wrong token string. got=%q, want=%q", tt.tok.String(), tt.expected)
+		}
+		if fmt.Sprint(tt.tok) != tt.expected {
+			t.Errorf("wrong formatted token. got=%q, want=%q", fmt.Sprint(tt.tok), tt.expected)
+		}
+	}
+	if fmt.Sprintf("%s", TypeToken(INT)) != "INT" {
+		t.Errorf("wrong token type string. got=%q", TypeToken(INT).String())
+	}
+}
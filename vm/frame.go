@@ -9,6 +9,8 @@ type Frame struct {
 	cl          *object.Closure
 	ip          int
 	basePointer int
+
+	loopBases []int // 进入需要回退栈的循环时的栈指针，break 和 continue 跳转前据此丢弃表达式中途的操作数
 }
 
 // NewFrame 创建一个新的Frame
@@ -1,11 +1,17 @@
 package vm
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 
 	"monkey/code"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/object"
+	"monkey/token"
 )
 
 const (
@@ -14,230 +20,461 @@ const (
 	MaxFrames   = 1024
 )
 
+// 与求值器共用布尔值和空值单例，注入的求值器函数返回的对象也能按指针比较
 var (
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = evaluator.True
+	False = evaluator.False
+	Null  = evaluator.Null
 )
 
 type VM struct {
 	constants   []object.Object
+	globalNames []string // 全局变量名，读取尚未赋值的全局变量时用于报告错误
 	stack       []object.Object
 	sp          int // 始终指向栈中下一个空闲位置，栈顶元素为 stack[sp-1]
 	globals     []object.Object
 	frames      []Frame
 	framesIndex int
+
+	ownsGlobals bool // 全局变量存储是否来自对象池
+	lastIP      int  // 最近开始执行的指令位置，供 SafeRun 报告
+
+	callCounts map[*object.CompiledFunction]int // 开启性能分析后每个函数的调用次数
+
+	breakpoints map[int]bool // 设置了断点的指令位置
+	paused      bool         // Run 是否停在断点处，再次调用 Run 时先执行该位置的指令
+
+	truthiness object.Truthiness // 判断真假的规则
+}
+
+// ErrBreakpoint Run 执行到断点时返回的错误，再次调用 Run 从断点处继续执行
+var ErrBreakpoint = errors.New("breakpoint")
+
+// RuntimeError 带有源代码位置的运行时错误，位置来自编译器生成的行号表
+type RuntimeError struct {
+	Pos token.Position
+	Err error
 }
 
+// Error 返回以 [line 行:列] 开头的错误信息
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("[line %s] %s", e.Pos, e.Err)
+}
+
+// Unwrap 返回不带位置的原始错误
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// 栈、全局变量和帧数组的对象池，供大量短程序复用
+var (
+	stackPool   = sync.Pool{New: func() any { return make([]object.Object, StackSize) }}
+	globalsPool = sync.Pool{New: func() any { return make([]object.Object, GlobalsSize) }}
+	framesPool  = sync.Pool{New: func() any { return make([]Frame, MaxFrames) }}
+)
+
 // New 创建一个新的虚拟机
 func New(bytecode *compiler.Bytecode) *VM {
+	vm := newVM(bytecode)
+	vm.globals = globalsPool.Get().([]object.Object)
+	vm.ownsGlobals = true
+	return vm
+}
+
+// NewWithGlobalsStore 创建一个新的虚拟机，并允许自定义全局变量存储
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	vm := newVM(bytecode)
+	vm.globals = globals
+	return vm
+}
+
+// newVM 创建虚拟机，栈和帧数组取自对象池
+func newVM(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{
 		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
 	}
 	mainClosure := &object.Closure{
 		Fn: mainFn,
 	}
 	mainFrame := NewFrame(mainClosure, 0)
-	frames := make([]Frame, MaxFrames)
+	frames := framesPool.Get().([]Frame)
 	frames[0] = mainFrame
 	return &VM{
 		constants:   bytecode.Constants,
-		stack:       make([]object.Object, StackSize),
+		globalNames: bytecode.GlobalNames,
+		stack:       stackPool.Get().([]object.Object),
 		sp:          0,
-		globals:     make([]object.Object, GlobalsSize),
 		frames:      frames,
 		framesIndex: 1,
 	}
 }
 
-// NewWithGlobalsStore 创建一个新的虚拟机，并允许自定义全局变量存储
-func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = globals
-	return vm
+// Release 清空并归还栈、帧数组和自有的全局变量存储，调用后不能再使用该虚拟机。
+// 通过 NewWithGlobalsStore 传入的全局变量存储由调用方管理，不会被清空
+func (vm *VM) Release() {
+	clear(vm.stack)
+	stackPool.Put(vm.stack)
+	clear(vm.frames)
+	framesPool.Put(vm.frames)
+	if vm.ownsGlobals {
+		clear(vm.globals)
+		globalsPool.Put(vm.globals)
+	}
+	vm.stack = nil
+	vm.frames = nil
+	vm.globals = nil
+	vm.sp = 0
+	vm.framesIndex = 0
 }
 
-// Run 执行字节码
+// Run 执行字节码，逐条调用 Step 直到程序执行完毕或出错。
+// 当前帧的下一条指令位于断点时暂停并返回 ErrBreakpoint，此时可以通过 Stack 检查栈
 func (vm *VM) Run() error {
-	var ip int
-	var ins code.Instructions
-	var op code.Opcode
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[ip])
-		switch op {
-		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op)
-			if err != nil {
-				return err
-			}
-		case code.OpPop:
+	for {
+		if !vm.paused && vm.breakpoints[vm.currentFrame().ip+1] && !vm.finished(0) {
+			vm.paused = true
+			return ErrBreakpoint
+		}
+		vm.paused = false
+		done, err := vm.Step()
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// SetBreakpoint 在指令位置 offset 处设置断点，任何帧执行到该位置时 Run 都会暂停
+func (vm *VM) SetBreakpoint(offset int) {
+	if vm.breakpoints == nil {
+		vm.breakpoints = make(map[int]bool)
+	}
+	vm.breakpoints[offset] = true
+}
+
+// EnableProfiling 开启性能分析，记录每个编译函数被调用的次数
+func (vm *VM) EnableProfiling() {
+	if vm.callCounts == nil {
+		vm.callCounts = make(map[*object.CompiledFunction]int)
+	}
+}
+
+// SetTruthiness 设置判断真假的规则，只影响这个虚拟机
+func (vm *VM) SetTruthiness(t object.Truthiness) {
+	vm.truthiness = t
+}
+
+// CallCounts 返回开启性能分析后每个编译函数的调用次数，未开启时返回 nil
+func (vm *VM) CallCounts() map[*object.CompiledFunction]int {
+	return vm.callCounts
+}
+
+// SafeRun 执行字节码，并将执行过程中意外的 panic 转换为带有出错指令位置的错误
+func (vm *VM) SafeRun() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("vm panicked at ip %d: %v", vm.lastIP, r)
+		}
+	}()
+	return vm.Run()
+}
+
+// Step 执行下一条指令，返回程序是否已经执行完毕，执行完毕后再次调用不做任何操作。
+// 调用函数的指令只压入新帧，函数体在之后的 Step 中逐条执行；内置函数回调的函数在一次 Step 中执行完
+func (vm *VM) Step() (bool, error) {
+	if vm.finished(0) {
+		return true, nil
+	}
+	err := vm.step()
+	if err != nil {
+		return false, err
+	}
+	return vm.finished(0), nil
+}
+
+// Position 返回当前帧的指令和下一条待执行指令的位置
+func (vm *VM) Position() (code.Instructions, int) {
+	frame := vm.currentFrame()
+	return frame.Instructions(), frame.ip + 1
+}
+
+// Stack 返回栈中当前的元素，栈底在前
+func (vm *VM) Stack() []object.Object {
+	return vm.stack[:vm.sp]
+}
+
+// run 执行字节码，直到帧数回落到 depth 或指令执行完毕，供内置函数同步回调函数时使用
+func (vm *VM) run(depth int) error {
+	for !vm.finished(depth) {
+		err := vm.step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finished 判断帧数是否已回落到 depth 或当前帧的指令已执行完毕
+func (vm *VM) finished(depth int) bool {
+	return vm.framesIndex <= depth || vm.currentFrame().ip >= len(vm.currentFrame().Instructions())-1
+}
+
+// step 执行当前帧中的下一条指令，出错时根据行号表为错误加上出错指令的源代码位置。
+// 内置函数回调的函数中出错时，错误已带有回调中出错的位置，不再重复添加
+func (vm *VM) step() error {
+	fn, ip := vm.currentFrame().cl.Fn, vm.currentFrame().ip+1
+	err := vm.execute()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*RuntimeError); ok {
+		return err
+	}
+	pos, ok := fn.Positions.Lookup(ip)
+	if !ok {
+		return err
+	}
+	return &RuntimeError{Pos: pos, Err: err}
+}
+
+// execute 执行当前帧中的下一条指令
+func (vm *VM) execute() error {
+	vm.currentFrame().ip++
+	ip := vm.currentFrame().ip
+	vm.lastIP = ip
+	ins := vm.currentFrame().Instructions()
+	op := code.Opcode(ins[ip])
+	switch op {
+	case code.OpConstant:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+
+		err := vm.push(vm.constants[constIndex])
+		if err != nil {
+			return err
+		}
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpPow:
+		err := vm.executeBinaryOperation(op)
+		if err != nil {
+			return err
+		}
+	case code.OpPop:
+		vm.pop()
+	case code.OpTrue:
+		err := vm.push(True)
+		if err != nil {
+			return err
+		}
+	case code.OpFalse:
+		err := vm.push(False)
+		if err != nil {
+			return err
+		}
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		err := vm.executeComparison(op)
+		if err != nil {
+			return err
+		}
+	case code.OpBang:
+		err := vm.executeBangOperator()
+		if err != nil {
+			return err
+		}
+	case code.OpMinus:
+		err := vm.executeMinusOperator()
+		if err != nil {
+			return err
+		}
+	case code.OpJump:
+		pos := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip = int(pos) - 1
+	case code.OpJumpNotTruthy:
+		pos := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+
+		if !vm.isTruthy(vm.pop()) {
+			vm.currentFrame().ip = int(pos) - 1
+		}
+	case code.OpJumpTruthyOrPop, code.OpJumpNotTruthyOrPop:
+		pos := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+
+		if vm.isTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyOrPop) {
+			vm.currentFrame().ip = int(pos) - 1
+		} else {
 			vm.pop()
-		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
-		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op)
-			if err != nil {
-				return err
-			}
-		case code.OpBang:
-			err := vm.executeBangOperator()
-			if err != nil {
-				return err
-			}
-		case code.OpMinus:
-			err := vm.executeMinusOperator()
-			if err != nil {
-				return err
-			}
-		case code.OpJump:
-			pos := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip = int(pos - 1)
-		case code.OpJumpNotTruthy:
-			pos := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				vm.currentFrame().ip = int(pos - 1)
-			}
-		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
-		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-
-			vm.globals[globalIndex] = vm.pop()
-		case code.OpGetGlobal:
-			index := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.globals[index])
-			if err != nil {
-				return err
-			}
-		case code.OpArray:
-			arrLen := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-
-			elements := vm.buildArray(vm.sp-arrLen, vm.sp)
-			vm.sp = vm.sp - arrLen
-			err := vm.push(elements)
-			if err != nil {
-				return err
-			}
-		case code.OpHash:
-			numElements := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-
-			hash, err := vm.buildHash(vm.sp-int(numElements), vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - int(numElements)
-			err = vm.push(hash)
-			if err != nil {
-				return err
-			}
-		case code.OpIndex:
-			index := vm.pop()
-			left := vm.pop()
-			err := vm.executeIndexExpression(left, index)
-			if err != nil {
-				return err
-			}
-		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			err := vm.executeCall(int(numArgs))
-			if err != nil {
-				return err
-			}
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			err := vm.push(returnValue)
-			if err != nil {
-				return err
-			}
-		case code.OpReturn:
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
-		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
-		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
-			if err != nil {
-				return err
-			}
-		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			definition := object.Builtins[builtinIndex]
-			err := vm.push(definition.Builtin)
-			if err != nil {
-				return err
-			}
-		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
-
-			err := vm.pushClosure(int(constIndex), int(numFree))
-			if err != nil {
-				return err
-			}
-		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			err := vm.push(vm.currentFrame().cl.Free[freeIndex])
-			if err != nil {
-				return err
-			}
-		case code.OpCurrentClosure:
-			err := vm.push(vm.currentFrame().cl)
-			if err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unknown opcode: %d", op)
 		}
+	case code.OpNull:
+		err := vm.push(Null)
+		if err != nil {
+			return err
+		}
+	case code.OpSetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+
+		vm.globals[globalIndex] = vm.pop()
+	case code.OpGetGlobal:
+		index := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		// 提升的函数名在 let 执行前已有位置，此时读取与求值器一样报告未定义
+		if vm.globals[index] == nil {
+			return fmt.Errorf("identifier not found: %s", vm.globalName(int(index)))
+		}
+		err := vm.push(vm.globals[index])
+		if err != nil {
+			return err
+		}
+	case code.OpArray:
+		arrLen := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+
+		elements := vm.buildArray(vm.sp-arrLen, vm.sp)
+		vm.sp = vm.sp - arrLen
+		err := vm.push(elements)
+		if err != nil {
+			return err
+		}
+	case code.OpHash:
+		numElements := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+
+		hash, err := vm.buildHash(vm.sp-int(numElements), vm.sp)
+		if err != nil {
+			return err
+		}
+		vm.sp = vm.sp - int(numElements)
+		err = vm.push(hash)
+		if err != nil {
+			return err
+		}
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+		err := vm.executeIndexExpression(left, index)
+		if err != nil {
+			return err
+		}
+	case code.OpSetIndex:
+		value := vm.pop()
+		index := vm.pop()
+		left := vm.pop()
+		err := object.SetIndex(left, index, value)
+		if err != nil {
+			return err
+		}
+		err = vm.push(value)
+		if err != nil {
+			return err
+		}
+	case code.OpSlice:
+		end := vm.pop()
+		start := vm.pop()
+		left := vm.pop()
+		result, err := object.Slice(left, start, end)
+		if err != nil {
+			return err
+		}
+		err = vm.push(result)
+		if err != nil {
+			return err
+		}
+	case code.OpCall:
+		numArgs := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		err := vm.executeCall(int(numArgs))
+		if err != nil {
+			return err
+		}
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+		if vm.framesIndex == 1 {
+			// 顶层 return 与求值器一致：结束程序，返回值作为最后弹出的元素
+			vm.sp = 0
+			vm.stack[vm.sp] = returnValue
+			vm.currentFrame().ip = len(ins) - 1
+			return nil
+		}
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer - 1
+		err := vm.push(returnValue)
+		if err != nil {
+			return err
+		}
+	case code.OpReturn:
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer - 1
+		err := vm.push(Null)
+		if err != nil {
+			return err
+		}
+	case code.OpSetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		frame := vm.currentFrame()
+		vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+	case code.OpGetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		frame := vm.currentFrame()
+		err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+		if err != nil {
+			return err
+		}
+	case code.OpGetBuiltin:
+		builtinIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		definition := object.Builtins[builtinIndex]
+		err := vm.push(definition.Builtin)
+		if err != nil {
+			return err
+		}
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		numFree := code.ReadUint8(ins[ip+3:])
+		vm.currentFrame().ip += 3
+
+		err := vm.pushClosure(int(constIndex), int(numFree))
+		if err != nil {
+			return err
+		}
+	case code.OpGetFree:
+		freeIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		err := vm.push(vm.currentFrame().cl.Free[freeIndex])
+		if err != nil {
+			return err
+		}
+	case code.OpEnterLoop:
+		frame := vm.currentFrame()
+		frame.loopBases = append(frame.loopBases, vm.sp)
+	case code.OpExitLoop:
+		frame := vm.currentFrame()
+		frame.loopBases = frame.loopBases[:len(frame.loopBases)-1]
+	case code.OpUnwindLoop:
+		bases := vm.currentFrame().loopBases
+		vm.sp = bases[len(bases)-1]
+	case code.OpCurrentClosure:
+		err := vm.push(vm.currentFrame().cl)
+		if err != nil {
+			return err
+		}
+	case code.OpGetMethod:
+		nameIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+
+		receiver := vm.pop()
+		err := vm.executeGetMethod(receiver, vm.constants[nameIndex])
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown opcode: %d", op)
 	}
 	return nil
 }
@@ -284,26 +521,61 @@ func (vm *VM) pop() object.Object {
 	return obj
 }
 
-// LastPoppedStackElem 返回最近弹出的栈元素
+// LastPoppedStackElem 返回最近弹出的栈元素，没有弹出过元素时返回 Null
 func (vm *VM) LastPoppedStackElem() object.Object {
-	return vm.stack[vm.sp]
+	if obj := vm.stack[vm.sp]; obj != nil {
+		return obj
+	}
+	return Null
 }
 
 // executeBinaryOperation 执行二元操作
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
+	if op == code.OpAdd {
+		if handled, err := vm.executeHashOperator(object.AddMethod, left, right, false); handled {
+			return err
+		}
+	}
 	leftType := left.Type()
 	rightType := right.Type()
 	switch {
 	case leftType == object.IntegerObj && rightType == object.IntegerObj:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case leftType == object.FloatObj || rightType == object.FloatObj:
+		leftVal, okLeft := object.FloatValue(left)
+		rightVal, okRight := object.FloatValue(right)
+		if okLeft && okRight {
+			return vm.executeBinaryFloatOperation(op, leftVal, rightVal)
+		}
 	case leftType == object.StringObj && rightType == object.StringObj:
 		return vm.executeBinaryStringOperation(op, left, right)
 	}
 	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
 }
 
+// executeHashOperator 调用左侧哈希中定义的运算符方法，返回是否已处理
+func (vm *VM) executeHashOperator(name string, left, right object.Object, negate bool) (bool, error) {
+	l, okL := left.(*object.Hash)
+	_, okR := right.(*object.Hash)
+	if !okL || !okR {
+		return false, nil
+	}
+	method, ok := l.Field(name)
+	if !ok {
+		return false, nil
+	}
+	result, err := vm.callFunction(method, left, right)
+	if err != nil {
+		return true, err
+	}
+	if negate {
+		result = nativeBoolToBooleanObject(!vm.isTruthy(result))
+	}
+	return true, vm.push(result)
+}
+
 // executeBinaryIntegerOperation 执行二元整数操作
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
 	leftVal := left.(*object.Integer).Value
@@ -318,12 +590,37 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 		result = leftVal * rightVal
 	case code.OpDiv:
 		result = leftVal / rightVal
+	case code.OpPow:
+		if rightVal < 0 {
+			return fmt.Errorf("negative exponent: %d", rightVal)
+		}
+		result = object.IntPow(leftVal, rightVal)
 	default:
 		return fmt.Errorf("unknown operator: %c", op)
 	}
 	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation 执行二元浮点数操作，整数操作数已提升为浮点数
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right float64) error {
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = left + right
+	case code.OpSub:
+		result = left - right
+	case code.OpMul:
+		result = left * right
+	case code.OpDiv:
+		result = left / right
+	case code.OpPow:
+		result = math.Pow(left, right)
+	default:
+		return fmt.Errorf("unknown operator: %c", op)
+	}
+	return vm.push(&object.Float{Value: result})
+}
+
 // executeBinaryStringOperation 执行二元字符串操作
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
@@ -338,21 +635,65 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
+	if op == code.OpEqual || op == code.OpNotEqual {
+		handled, err := vm.executeHashOperator(object.EqMethod, left, right, op == code.OpNotEqual)
+		if handled {
+			return err
+		}
+	}
 	leftType := left.Type()
 	rightType := right.Type()
 	if leftType == object.IntegerObj && rightType == object.IntegerObj {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if leftType == object.FloatObj || rightType == object.FloatObj {
+		leftVal, okLeft := object.FloatValue(left)
+		rightVal, okRight := object.FloatValue(right)
+		if okLeft && okRight {
+			return vm.executeFloatComparison(op, leftVal, rightVal)
+		}
+	}
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(left == right))
+		return vm.push(nativeBoolToBooleanObject(valuesEqual(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(left != right))
+		return vm.push(nativeBoolToBooleanObject(!valuesEqual(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)", op, leftType, rightType)
 	}
 }
 
+// valuesEqual 判断 == 两侧的对象是否相等，字符串和字节数组按内容比较，其余对象按引用比较
+func valuesEqual(left, right object.Object) bool {
+	if l, ok := left.(*object.String); ok {
+		if r, ok := right.(*object.String); ok {
+			return l.Value == r.Value
+		}
+	}
+	if l, ok := left.(*object.Bytes); ok {
+		if r, ok := right.(*object.Bytes); ok {
+			return bytes.Equal(l.Value, r.Value)
+		}
+	}
+	return left == right
+}
+
+// executeFloatComparison 执行浮点数比较
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right float64) error {
+	var result bool
+	switch op {
+	case code.OpEqual:
+		result = left == right
+	case code.OpNotEqual:
+		result = left != right
+	case code.OpGreaterThan:
+		result = left > right
+	default:
+		return fmt.Errorf("unknown operator: %c", op)
+	}
+	return vm.push(nativeBoolToBooleanObject(result))
+}
+
 // executeIntegerComparison 执行整数比较
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
 	leftVal := left.(*object.Integer).Value
@@ -382,22 +723,15 @@ func nativeBoolToBooleanObject(input bool) object.Object {
 
 // executeBangOperator 执行逻辑非操作
 func (vm *VM) executeBangOperator() error {
-	operand := vm.pop()
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
-	default:
-		return vm.push(False)
-	}
+	return vm.push(nativeBoolToBooleanObject(!vm.isTruthy(vm.pop())))
 }
 
-// executeMinusOperator 执行负号操作
+// executeMinusOperator 执行负号操作，-math.MinInt64 与求值器一样回绕为 math.MinInt64
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
+	if float, ok := operand.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -float.Value})
+	}
 	if operand.Type() != object.IntegerObj {
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
@@ -405,24 +739,16 @@ func (vm *VM) executeMinusOperator() error {
 	return vm.push(&object.Integer{Value: -value})
 }
 
-// isTruthy 判断对象是否为真
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Null:
-		return false
-	default:
-		return true
-	}
+// isTruthy 按虚拟机的设置判断对象是否为真，规则见 object.Truthiness
+func (vm *VM) isTruthy(obj object.Object) bool {
+	return vm.truthiness.IsTruthy(obj)
 }
 
 // buildArray 从栈中构建一个数组对象
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
-	elements := make([]object.Object, endIndex-startIndex)
-	for i := startIndex; i < endIndex; i++ {
-		elements[i-startIndex] = vm.stack[i]
-	}
+	n := endIndex - startIndex
+	elements := make([]object.Object, n, n)
+	copy(elements, vm.stack[startIndex:endIndex])
 	return &object.Array{Elements: elements}
 }
 
@@ -441,43 +767,37 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 	return &object.Hash{Pairs: hashedPairs}, nil
 }
 
-// executeIndexExpression 执行索引表达式
-func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
-		return vm.executeArrayIndex(left, index)
-	case left.Type() == object.HashObj:
-		return vm.executeHashIndex(left, index)
-	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+// globalName 返回全局变量的名字，没有记录名字时返回它的位置
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) && vm.globalNames[index] != "" {
+		return vm.globalNames[index]
 	}
+	return fmt.Sprintf("global %d", index)
 }
 
-// executeArrayIndex 执行数组索引
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	if idx < 0 || idx > int64(len(arrayObject.Elements)-1) {
+// executeIndexExpression 执行索引表达式，越界或不存在的键压入 Null
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	result, err := object.Index(left, index)
+	if err != nil {
+		return err
+	}
+	if result == nil {
 		return vm.push(Null)
 	}
-	return vm.push(arrayObject.Elements[idx])
+	return vm.push(result)
 }
 
-// executeHashIndex 执行哈希索引
-func (vm *VM) executeHashIndex(hash, index object.Object) error {
-	hashObject := hash.(*object.Hash)
-
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+// executeGetMethod 取出接收者中的方法，依次压入方法和接收者
+func (vm *VM) executeGetMethod(receiver, name object.Object) error {
+	method, err := object.Method(receiver, name.(*object.String).Value)
+	if err != nil {
+		return err
 	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
-	if !ok {
-		return vm.push(Null)
+	err = vm.push(method)
+	if err != nil {
+		return err
 	}
-
-	return vm.push(pair.Value)
+	return vm.push(receiver)
 }
 
 // executeCall 执行函数调用
@@ -488,6 +808,8 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.Function:
+		return vm.callEvaluatorFunction(callee, numArgs)
 	default:
 		return fmt.Errorf("calling %s is not supported", callee.Type())
 	}
@@ -498,16 +820,45 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
+	if vm.callCounts != nil {
+		vm.callCounts[cl.Fn]++
+	}
 	frame := NewFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 	return nil
 }
 
-// callBuiltin 调用内置函数
+// callEvaluatorFunction 调用宿主注入的求值器函数，使用其捕获的环境以树遍历方式执行函数体
+func (vm *VM) callEvaluatorFunction(fn *object.Function, numArgs int) error {
+	if numArgs != len(fn.Parameters) {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), numArgs)
+	}
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	result := evaluator.ApplyFunction(fn, args)
+	vm.sp -= numArgs + 1
+	return vm.push(result)
+}
+
+// callBuiltin 调用内置函数，高阶内置函数回调的函数在参数之上的栈空间中同步执行，
+// 回调中的运行时错误会中止内置函数并原样返回
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	var callErr error
+	call := object.Caller{Truthiness: vm.truthiness}
+	call.Call = func(fn object.Object, args ...object.Object) object.Object {
+		result, err := vm.callFunction(fn, args...)
+		if err != nil {
+			callErr = err
+			return &object.Error{Message: err.Error()}
+		}
+		return result
+	}
+	result := builtin.Call(call, args...)
+	if callErr != nil {
+		return callErr
+	}
 	vm.sp -= numArgs + 1
 	if result == nil {
 		result = Null
@@ -534,3 +885,27 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 		Free: free,
 	})
 }
+
+// callFunction 在当前虚拟机中同步调用函数并返回结果
+func (vm *VM) callFunction(fn object.Object, args ...object.Object) (object.Object, error) {
+	err := vm.push(fn)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		err = vm.push(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	depth := vm.framesIndex
+	err = vm.executeCall(len(args))
+	if err != nil {
+		return nil, err
+	}
+	err = vm.run(depth)
+	if err != nil {
+		return nil, err
+	}
+	return vm.pop(), nil
+}
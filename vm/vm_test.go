@@ -1,14 +1,21 @@
 package vm
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"testing"
+	"time"
 
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
 )
 
 type vmTestCase struct {
@@ -31,6 +38,36 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-5", -5},
 		{"-10", -10},
 		{"50 / 2 * 2 + 10 + -5", 55},
+		{"0 - 0", 0},
+		{"- -5", 5},
+		{"2 ** 10", 1024},
+		{"2 ** 0", 1},
+		{"-2 ** 2", -4},
+		{"(-2) ** 2", 4},
+		{"2 ** 3 ** 2", 512},
+		{"2 * 3 ** 2", 18},
+		{"-(-5)", 5},
+		{"-9223372036854775807 - 1", math.MinInt64},
+		{"-(-9223372036854775807 - 1)", math.MinInt64},
+	}
+	runVMTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"-0.5", -0.5},
+		{"2 + 0.5", 2.5},
+		{"0.5 + 2", 2.5},
+		{"5.0 / 2.0", 2.5},
+		{"5 / 2.0", 2.5},
+		{"1.5 * 2", 3.0},
+		{"10 - 0.25", 9.75},
+		{"2.0 ** 3", 8.0},
+		{"1.5 < 2", true},
+		{"2.5 > 2.4", true},
+		{"1.0 == 1", true},
+		{"0.1 != 0.1", false},
 	}
 	runVMTests(t, tests)
 }
@@ -63,6 +100,124 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false) { 5; })", true},
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "a"`, false},
+		{`"a" != "b"`, true},
+	}
+	runVMTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{`if (false) { 1 } || "x"`, "x"},
+		{"if (false) { 1 } && 2", Null},
+		{"1 < 2 && 2 < 3", true},
+		{`false && first(1)`, false},
+		{`true || first(1)`, true},
+		{"let x = 0; let f = fn() { x > 1 }; true && f()", false},
+		{"let f = fn(a, b) { a || b }; [f(0, 1), f(false, 2)]", []int{0, 2}},
+	}
+	runVMTests(t, tests)
+}
+
+// 内置函数返回的错误是值，在条件中为假，可以用 || 回退到默认值
+func TestErrorFallbacks(t *testing.T) {
+	tests := []vmTestCase{
+		{`!first(1)`, true},
+		{`first(1) || 5`, 5},
+		{`let e = len(1); e || 5`, 5},
+		{`if (first(1)) { 1 } else { 2 }`, 2},
+		{`let r = divmod(7, 0); if (r) { r[0] } else { -1 }`, -1},
+		{`let r = divmod(7, 2); r || [-1, -1]`, []int{3, 1}},
+		{`type(len(1))`, "ERROR"},
+		{`let orElse = fn(v, d) { v || d }; orElse(first(1), 5) + orElse(first([4]), 0)`, 9},
+	}
+	runVMTests(t, tests)
+}
+
+func TestEmptyIsFalsy(t *testing.T) {
+	tests := []vmTestCase{
+		{`if ([]) { 1 } else { 2 }`, 1},
+		{`if (0) { 1 } else { 2 }`, 1},
+		{`!""`, false},
+	}
+	runVMTests(t, tests)
+
+	emptyTests := []vmTestCase{
+		{`if ([]) { 1 } else { 2 }`, 2},
+		{`if (0) { 1 } else { 2 }`, 2},
+		{`if ({}) { 1 } else { 2 }`, 2},
+		{`if ([0]) { 1 } else { 2 }`, 1},
+		{`!""`, true},
+		{`0 || false`, false},
+		{`let n = 3; let total = 0; while (n) { let total = total + n; let n = n - 1; } total`, 6},
+		{`filter([0, 1, "", 2], fn(x) { x })`, []int{1, 2}},
+	}
+	runVMTestsWith(t, emptyTests, func(vm *VM) {
+		vm.SetTruthiness(object.Truthiness{EmptyIsFalsy: true})
+	})
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { let i = i + 1; } i", 10},
+		{"let i = 0; let sum = 0; while (i < 5) { let i = i + 1; let sum = sum + i; } sum", 15},
+		{"let f = fn(n) { while (n > 0) { let n = n - 1; } n }; f(3)", 0},
+		{"fn() { let i = 0; while (true) { if (i > 3) { return i; } let i = i + 1; } }()", 4},
+		{"while (false) { 1 }", Null},
+		{"fn() { while (false) {} }()", Null},
+	}
+	runVMTests(t, tests)
+}
+
+// 循环出现在表达式位置时的值总是 null，循环体最后一个表达式的值被丢弃
+func TestLoopResults(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = while (false) {}; x", Null},
+		{"let i = 0; let x = while (i < 3) { let i = i + 1; i }; if (x) { 1 } else { i }", 3},
+		{"let x = for (let i = 0; i < 3; let i = i + 1) { if (i == 1) { break; } i }; if (x) { 1 } else { 2 }", 2},
+		{"fn() { let r = while (true) { return 7; }; r }()", 7},
+		{"let x = 1 + 2; [while (false) {}, x][1]", 3},
+		{"len([while (false) {}, for (let i = 0; i < 1; let i = i + 1) {}])", 2},
+		{"fn() { while (false) {} }()", Null},
+	}
+	runVMTests(t, tests)
+}
+
+func TestForLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = fn(n) { let s = 0; for (let i = 1; i < n + 1; let i = i + 1) { let s = s + i; if (i == n) { return s; } } }; sum(4)", 10},
+		{"fn() { for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (i * 3 + j == 7) { return i * 10 + j; } } } }()", 21},
+		{"let i = 5; for (let i = 0; i < 3; let i = i + 1) {} i", 5},
+		{"let sum = 0; for (let i = 1; i < 5; let i = i + 1) { let sum = sum + i; } sum", 10},
+		{"fn(n) { let total = 0; for (let i = 0; i < n; let i = i + 1) { for (let j = 0; j < n; let j = j + 1) { let total = total + 1; } } total }(3)", 9},
+		{"let f = fn() { for (let i = 0; i < 3; let i = i + 1) { if (i == 2) { return fn() { i * 100 }; } } }; f()()", 200},
+		{"let x = 0; for (let i = 0; i < 3; let i = i + 1) { let y = i; } x", 0},
+		{"for (let i = 0; i < 3; let i = i + 1) { i }", Null},
+	}
+	runVMTests(t, tests)
+}
+
+func TestBreakAndContinue(t *testing.T) {
+	tests := []vmTestCase{
+		{"let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 2) { continue; } if (i == 5) { break; } let s = s + i; } s", 8},
+		{"let n = 0; while (true) { let n = n + 1; if (n > 3) { break; } } n", 4},
+		{"let n = 0; let odd = 0; while (n < 6) { let n = n + 1; if (n == n / 2 * 2) { continue; } let odd = odd + 1; } odd", 3},
+		{"let c = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (j == 1) { break; } let c = c + 1; } } c", 3},
+		{"fn() { for (let i = 0; i < 10; let i = i + 1) { if (i == 4) { break; } } 7 }()", 7},
+		{"while (true) { break; }", Null},
+		// break 和 continue 在表达式中途跳出时不能在栈上留下操作数
+		{"let i = 0; while (i < 5000) { let i = i + 1; let x = 1 + if (true) { continue; } else { 2 }; } i", 5000},
+		{"let s = 0; for (let i = 0; i < 3000; let i = i + 1) { let s = s + [i, if (i > 1) { continue; } else { 1 }][1]; } s", 2},
+		{"let n = 0; let r = [1, 2, while (true) { let n = n + 1; let y = n * if (n < 100) { continue; } else { break; }; }]; [n, len(r)]", []int{100, 3}},
 	}
 	runVMTests(t, tests)
 }
@@ -92,6 +247,18 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+// TestRebindingReusesSlot 同一作用域中重复的 let 写入原来的位置，之前定义的函数读取全局变量时看到新的值，与求值器一致
+func TestRebindingReusesSlot(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; let x = x + 1; x", 2},
+		{"let x = 1; let f = fn() { x }; let x = 2; f()", 2},
+		{"let a = [1]; let f = fn() { a }; let a = push(a, 2); f()", []int{1, 2}},
+		{"fn() { let x = 1; let x = x * 10; x }()", 10},
+		{"fn(x) { let x = x + 1; x }(1)", 2},
+	}
+	runVMTests(t, tests)
+}
+
 func TestStringExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{`"monkey"`, "monkey"},
@@ -147,6 +314,121 @@ func TestIndexExpressions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestStringIndexAndSlice(t *testing.T) {
+	tests := []vmTestCase{
+		{`"hello"[0]`, "h"},
+		{`"hello"[4]`, "o"},
+		{`"hello"[5]`, Null},
+		{`"hello"[-1]`, Null},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:2]`, "he"},
+		{`"hello"[3:]`, "lo"},
+		{`"hello"[-3:-1]`, "ll"},
+		{`"hello"[-99:99]`, "hello"},
+		{`"hello"[4:1]`, ""},
+		{`[1, 2, 3, 4][1:3]`, []int{2, 3}},
+		{`[1, 2, 3][-2:]`, []int{2, 3}},
+		{`[1, 2, 3][:]`, []int{1, 2, 3}},
+		{`let a = [1, 2]; let b = a[:]; b[0] = 9; a`, []int{1, 2}},
+		{`"猴子"[0:3]`, "猴"},
+	}
+	runVMTests(t, tests)
+
+	errorTests := []vmTestCase{
+		{`"猴子"[0]`, "[line 1:1] string index 0 splits a UTF-8 character"},
+		{`"猴子"[1:]`, "[line 1:1] string slice [1:6] splits a UTF-8 character"},
+		{`[1]["a":]`, "[line 1:1] slice bound must be INTEGER, got STRING"},
+		{`{}[0:1]`, "[line 1:1] slice operator not supported: HASH"},
+	}
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %s: want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map(["a", "bc"], len)`, []int{1, 2}},
+		{`let k = 10; map([1, 2], fn(x) { return x + k; })`, []int{11, 12}},
+		{`let f = fn(n) { map([n, n + 1], fn(x) { x * n }) }; f(3)`, []int{9, 12}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`reduce(map([1, 2, 3], fn(x) { x * x }), 0, fn(a, b) { a + b }) + 1`, 15},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "argument to `map` must be Array, got INTEGER"}},
+	}
+	runVMTests(t, tests)
+
+	errorTests := []vmTestCase{
+		{`map([1, "a"], fn(x) { x - 1 })`, "[line 1:25] unsupported types for binary operation: STRING INTEGER"},
+		{`map([1], fn(a, b) { a })`, "[line 1:1] wrong number of arguments: want=2, got=1"},
+		{`map([1], 1)`, "[line 1:1] calling INTEGER is not supported"},
+	}
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %s: want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestBenchBuiltin(t *testing.T) {
+	clock := object.Clock
+	defer func() { object.Clock = clock }()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	object.Clock = func() time.Time {
+		now = now.Add(2 * time.Millisecond)
+		return now
+	}
+	tests := []vmTestCase{
+		{`let r = bench(fn() { 1 + 2 }); r["result"]`, 3},
+		{`bench(fn() { 0 })["ms"]`, 2.0},
+		{`let f = fn(n) { if (n < 2) { n } else { f(n - 1) + f(n - 2) } }; bench(fn() { f(10) })["result"]`, 55},
+		{`bench(fn() { bench(fn() { 0 })["ms"] })["ms"]`, 6.0},
+	}
+	runVMTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{`let a = [1, 2, 3]; a[0] = 99; a`, []int{99, 2, 3}},
+		{`let a = [1, 2, 3]; a[2] = a[1] * 10`, 20},
+		{`let h = {"x": 1}; h["y"] = 2; h["x"] = h["x"] + 10; [h["x"], h["y"]]`, []int{11, 2}},
+		{`let m = [[1, 2], [3]]; m[0][1] = m[1][0] = 7; m[0][1] + m[1][0]`, 14},
+		{`let a = [0]; let f = fn(arr) { arr[0] = 5; }; f(a); a[0]`, 5},
+		{`let a = [0, 0, 0]; for (let i = 0; i < 3; let i = i + 1) { a[i] = i * i; } a`, []int{0, 1, 4}},
+	}
+	runVMTests(t, tests)
+
+	errorTests := []vmTestCase{
+		{`[1, 2][2] = 3`, "[line 1:1] index out of range: 2 (length 2)"},
+		{`[1][-1] = 3`, "[line 1:1] index out of range: -1 (length 1)"},
+		{`{}[fn() {}] = 1`, "[line 1:1] unusable as hash key: CLOSURE"},
+		{`let s = "ab"; s[0] = 1`, "[line 1:15] index assignment not supported: STRING"},
+	}
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %s: want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -197,6 +479,18 @@ func TestFunctionsWithReturnStatement(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 10;", 10},
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{"if (true) { return 1; } 2;", 1},
+		{"let x = 3; return x * 2; 99;", 6},
+		{"let f = fn() { return 1; }; return f() + 1; 99;", 2},
+	}
+	runVMTests(t, tests)
+}
+
 func TestFunctionsWithoutReturnValue(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -219,6 +513,31 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestFunctionsEndingInNonExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn() { let x = 1; }()", Null},
+		{"let f = fn() { let x = 1; }; f(); f()", Null},
+		{"fn() { if (true) { let y = 2; } }()", Null},
+		{"fn() { if (false) { 1 } else { let y = 2; } }()", Null},
+		{"if (true) {}", Null},
+		{"fn(a) { let x = a; x }(3)", 3},
+		{"fn() { 1; let x = 2; }()", Null},
+	}
+	runVMTests(t, tests)
+}
+
+func TestReturnInsideLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn() { [if (true) { return 5; }, 2] }()", 5},
+		{"fn() { [1, if (true) { return 5; }][0] }()", 5},
+		{`fn() { {"a": if (true) { return 5; }} }()`, 5},
+		{"fn() { len(if (true) { return 5; }) }()", 5},
+		{"fn() { 1 + if (true) { return 5; } }()", 5},
+		{"fn() { let x = if (true) { return 5; }; 10 }()", 5},
+	}
+	runVMTests(t, tests)
+}
+
 func TestFirstClassFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -366,15 +685,15 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() {1;}(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `[line 1:1] wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a) {a;}();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `[line 1:1] wrong number of arguments: want=1, got=0`,
 		},
 		{
 			input:    `fn(a,b) {a+b;}(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `[line 1:1] wrong number of arguments: want=2, got=1`,
 		},
 	}
 	for _, tt := range tests {
@@ -436,6 +755,86 @@ func TestBuiltinFunctions(t *testing.T) {
 				Message: "argument to `push` must be ARRAY, got INTEGER",
 			},
 		},
+		{`sortedKeys({10: "a", -1: "b", 2: "c"})`, []int{-1, 2, 10}},
+		{`sortedKeys({"b": 1, "a": 2})[0]`, "a"},
+		{`sortedKeys({})`, []int{}},
+		{`sortedKeys([1])`,
+			&object.Error{
+				Message: "argument to `sortedKeys` must be Hash, got ARRAY",
+			},
+		},
+		{`toJSON({"items": [1, 2], "meta": {"n": 2}})`, `{"items":[1,2],"meta":{"n":2}}`},
+		{`toJSON({1: "a"})`,
+			&object.Error{
+				Message: "JSON object keys must be strings, got INTEGER",
+			},
+		},
+		{`flatten([[1, 2], [3]])`, []int{1, 2, 3}},
+		{`flatten([[1, [2]], 3])[1][0]`, 2},
+		{`flattenDeep([1, [2, [3, [4]]]])`, []int{1, 2, 3, 4}},
+		{`flattenDeep([])`, []int{}},
+		{`flatten("a")`,
+			&object.Error{
+				Message: "argument to `flatten` must be Array, got STRING",
+			},
+		},
+		{`chr(65) + chr(66)`, "AB"},
+		{`repr([1, "1", {"k": chr(10)}])`, `[1, "1", {"k": "\n"}]`},
+		{`str(123) + "!"`, "123!"},
+		{`sort([3, 1, 2], {"reverse": true})`, []int{3, 2, 1}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort([1, 2], fn(a, b) { "yes" })`, &object.Error{Message: "comparator of `sort` must return Boolean, got STRING"}},
+		{`sortBy([3, 10, 200, 1], fn(x) { -len(str(x)) })`, []int{200, 10, 3, 1}},
+		{`sortBy([{"n": "b"}, {"n": "a"}], fn(h) { h["n"] })[0]["n"]`, "a"},
+		{`sortBy([1], fn(x) { 1.5 })`, &object.Error{Message: "key of `sortBy` must be Integer or String, got FLOAT"}},
+		{`groupBy([1, 2, 3, 4], fn(x) { x - x / 2 * 2 })[1]`, []int{1, 3}},
+		{`countBy(["a", "bb", "cc"], len)[2]`, 2},
+		{`iterate(fn(x) { x * 2 }, 1, 5)`, []int{1, 2, 4, 8, 16}},
+		{`iterate(fn(n) { if (n / 2 * 2 == n) { n / 2 } else { 3 * n + 1 } }, 3, 8)`, []int{3, 10, 5, 16, 8, 4, 2, 1}},
+		{`iterate(fn(x) { x }, 1, -1)`, &object.Error{Message: "count argument to `iterate` must be positive, got -1"}},
+		{`keys({3: 0, 10: 0, 1: 0})`, []int{1, 3, 10}},
+		{`values({3: 30, "a": 0, true: 7, 1: 10})`, []int{7, 10, 30, 0}},
+		{`countBy([1], fn(x) { fn() {} })`, &object.Error{Message: "unusable as hash key: CLOSURE"}},
+		{`len(split("a,b,c", ",", {"limit": 2}))`, 2},
+		{`int("42") + int(1.9)`, 43},
+		{`int("nope")`, &object.Error{Message: "argument to `int` is not a valid integer: \"nope\""}},
+		{`range(0, 10, 2)`, []int{0, 2, 4, 6, 8}},
+		{`range(3, 0, -1)`, []int{3, 2, 1}},
+		{`range(4, 4)`, []int{}},
+		{`reduce(range(1, 5), 0, fn(a, b) { a + b })`, 10},
+		{`range(1, 2, 0)`, &object.Error{Message: "step argument to `range` must not be zero"}},
+		{`chr(55296)`,
+			&object.Error{
+				Message: "argument to `chr` is not a valid code point: 55296",
+			},
+		},
+		{`divmod(7, 2)`, []int{3, 1}},
+		{`divmod(-5, 4)`, []int{-1, -1}},
+		{`divmod(5, -4)`, []int{-1, 1}},
+		{`divmod(1, 0)`,
+			&object.Error{
+				Message: "division by zero",
+			},
+		},
+		{`type(1)`, "INTEGER"},
+		{`type({"a": 1})`, "HASH"},
+		{`type({"__type__": "Point", "x": 1})`, "Point"},
+		{`type({"__type__": 1})`, "HASH"},
+		{`capacity([1, 2, 3])`, 3},
+		{`let base = {"a": 1}; assign(base, {"b": 2}); base["a"] + base["b"]`, 3},
+		{`assign({"a": 1}, {"a": 5})["a"]`, 5},
+		{`let proto = {"greet": fn(self) { self.name }}; assign({"name": 1}, proto).greet()`, 1},
+		{`assign({}, 1)`,
+			&object.Error{
+				Message: "argument to `assign` must be Hash, got INTEGER",
+			},
+		},
+		{`capacity([])`, 0},
+		{`capacity("abc")`,
+			&object.Error{
+				Message: "argument to `capacity` must be Array, got STRING",
+			},
+		},
 	}
 
 	runVMTests(t, tests)
@@ -515,6 +914,21 @@ func TestClosures(t *testing.T) {
 		`,
 			expected: 99,
 		},
+		{
+			input: `
+		let makeAll = fn() {
+			let fns = [0, 0, 0];
+			for (let i = 0; i < 3; let i = i + 1) {
+				let base = i * 10;
+				fns[i] = fn(x) { fn() { base + x } };
+			}
+			fns
+		};
+		let fns = makeAll();
+		fns[0](1)() + fns[2](2)();
+		`,
+			expected: 23,
+		},
 	}
 	runVMTests(t, tests)
 }
@@ -566,10 +980,60 @@ func TestRecursiveFunctions(t *testing.T) {
 			`,
 			expected: 0,
 		},
+		{
+			input: `
+			let makeFactorial = fn() {
+				let factorial = fn(n) { if (n == 0) { 1 } else { n * factorial(n - 1) } };
+				factorial
+			};
+			makeFactorial()(5);
+			`,
+			expected: 120,
+		},
 	}
 	runVMTests(t, tests)
 }
 
+func TestMutualRecursion(t *testing.T) {
+	defs := `
+	let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+	let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+	`
+	tests := []vmTestCase{
+		{defs + "isEven(4)", true},
+		{defs + "isOdd(4)", false},
+		{defs + "isOdd(7)", true},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f()", 1},
+	}
+	runVMTests(t, tests)
+
+	// 提升的函数名在 let 执行之前读取时报告未定义，而不是得到 nil
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"g(); let g = fn() { 1 };", "[line 1:1] identifier not found: g"},
+		{"let f = fn() { g() }; f(); let g = fn() { 1 };", "[line 1:16] identifier not found: g"},
+		{"let x = g; let g = fn() { 1 }; x", "[line 1:9] identifier not found: g"},
+		{"g; let g = fn() { 1 }; 2", "[line 1:1] identifier not found: g"},
+	}
+	for _, tt := range errorTests {
+		for _, elide := range []bool{false, true} {
+			comp := compiler.New()
+			if elide {
+				comp.EnablePureElision()
+			}
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err := New(comp.Bytecode()).Run()
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("wrong VM error for %s (elide=%t): want=%q, got=%v", tt.input, elide, tt.expected, err)
+			}
+		}
+	}
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -593,8 +1057,468 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestMethodCalls(t *testing.T) {
+	person := `
+	let person = {
+		"name": "Monkey",
+		"greet": fn(self, greeting) { greeting + ", " + self.name },
+		"rename": fn(self, name) { {"name": name, "greet": self.greet} }
+	};
+	`
+	tests := []vmTestCase{
+		{person + `person.name`, "Monkey"},
+		{person + `person.greet("Hello")`, "Hello, Monkey"},
+		{person + `person.rename("Gorilla").greet("Hi")`, "Hi, Gorilla"},
+		{person + `person["greet"](person, "Hey")`, "Hey, Monkey"},
+		{person + `let f = fn() { person.greet("Yo") }; f()`, "Yo, Monkey"},
+	}
+	runVMTests(t, tests)
+}
+
+func TestHashOperatorMethods(t *testing.T) {
+	vector := `
+	let vec = fn(x, y) {
+		{
+			"x": x,
+			"y": y,
+			"__add__": fn(a, b) { vec(a["x"] + b["x"], a["y"] + b["y"]) },
+			"__eq__": fn(a, b) { a["x"] == b["x"] }
+		}
+	};
+	`
+	tests := []vmTestCase{
+		{vector + `let v = vec(1, 2) + vec(3, 4); v["x"] * 10 + v["y"]`, 46},
+		{vector + `let v = vec(1, 2) + vec(3, 4) + vec(5, 6); v["x"] * 10 + v["y"]`, 102},
+		{vector + `vec(1, 2) == vec(1, 5)`, true},
+		{vector + `vec(1, 2) == vec(3, 2)`, false},
+		{vector + `vec(1, 2) != vec(3, 2)`, true},
+		{vector + `let add = fn(a, b) { a + b }; let v = add(vec(1, 1), vec(2, 2)); v["y"]`, 3},
+		{`{"a": 1} == {"a": 1}`, false},
+	}
+	runVMTests(t, tests)
+}
+
+func TestLastPoppedStackElemWithoutPops(t *testing.T) {
+	tests := []vmTestCase{
+		{"", Null},
+		{"   ", Null},
+	}
+	runVMTests(t, tests)
+}
+
+func TestCallingEvaluatorFunctions(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("factor", &object.Integer{Value: 3})
+	fn := evaluator.Eval(parse(`fn(x) { x * factor }`), env)
+	maybe := evaluator.Eval(parse(`fn(x) { if (x) { 1 } }`), env)
+	if _, ok := fn.(*object.Function); !ok {
+		t.Fatalf("evaluator did not return Function. got=%T", fn)
+	}
+
+	tests := []vmTestCase{
+		{`triple(5)`, 15},
+		{`let apply = fn(f, x) { f(x) }; apply(triple, 7)`, 21},
+		{`triple(1) + triple(2)`, 9},
+		{`!maybe(false)`, true},
+		{`!maybe(true)`, false},
+	}
+	for _, tt := range tests {
+		symbolTable := compiler.NewSymbolTable()
+		for i, v := range object.Builtins {
+			symbolTable.DefineBuiltin(i, v.Name)
+		}
+		globals := make([]object.Object, GlobalsSize)
+		globals[symbolTable.Define("triple").Index] = fn
+		globals[symbolTable.Define("maybe").Index] = maybe
+
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithGlobalsStore(comp.Bytecode(), globals)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestZeroArgumentBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`puts()`, Null},
+		{`[puts(), 1][1]`, 1},
+		{`let f = fn() { puts() }; f()`, Null},
+		{`let f = fn(a) { let b = puts(); a }; f(7)`, 7},
+		{`len()`,
+			&object.Error{
+				Message: "wrong number of arguments. got=0, want=1",
+			},
+		},
+	}
+	runVMTests(t, tests)
+
+	// 直接调用时参数和内置函数本身都要从栈上移除，只留下结果
+	machine := New(&compiler.Bytecode{})
+	if err := machine.push(&object.Integer{Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := machine.push(object.GetBuiltinByName("puts")); err != nil {
+		t.Fatal(err)
+	}
+	if err := machine.callBuiltin(object.GetBuiltinByName("puts"), 0); err != nil {
+		t.Fatalf("callBuiltin error: %s", err)
+	}
+	if machine.sp != 2 {
+		t.Errorf("wrong stack pointer after zero-argument call. got=%d, want=2", machine.sp)
+	}
+	testExpectedObject(t, Null, machine.stack[1])
+	testExpectedObject(t, 1, machine.stack[0])
+}
+
+func TestSafeRunRecoversFromPanics(t *testing.T) {
+	bytecode := &compiler.Bytecode{
+		Instructions: code.Concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 5),
+			code.Make(code.OpPop),
+		),
+		Constants: []object.Object{&object.Integer{Value: 1}},
+	}
+	err := New(bytecode).SafeRun()
+	if err == nil {
+		t.Fatalf("expected error for malformed bytecode")
+	}
+	expected := "vm panicked at ip 3: runtime error: index out of range [5] with length 1"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, err.Error())
+	}
+
+	program := parse("1 + 2")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.SafeRun(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testExpectedObject(t, 3, machine.LastPoppedStackElem())
+}
+
+func TestStep(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(`let add = fn(a, b) { a + b }; add(1, 2) * 3`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	steps := 0
+	for done := false; !done; steps++ {
+		var err error
+		done, err = machine.Step()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+	// 主程序 9 条指令，add 函数体 4 条
+	if steps != 13 {
+		t.Errorf("wrong number of steps. want=13, got=%d", steps)
+	}
+	testExpectedObject(t, 9, machine.LastPoppedStackElem())
+	if len(machine.Stack()) != 0 {
+		t.Errorf("stack not empty after last step: %v", machine.Stack())
+	}
+	if done, err := machine.Step(); !done || err != nil {
+		t.Errorf("Step after completion: done=%t, err=%v", done, err)
+	}
+
+	comp = compiler.New()
+	if err := comp.Compile(parse(`1 + true`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine = New(comp.Bytecode())
+	for range 2 {
+		if _, err := machine.Step(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+	_, err := machine.Step()
+	if err == nil || err.Error() != "[line 1:3] unsupported types for binary operation: INTEGER BOOLEAN" {
+		t.Errorf("wrong error from Step. got=%v", err)
+	}
+}
+
+func TestStepMatchesRun(t *testing.T) {
+	inputs := []string{
+		`1 + 2 * 3`,
+		`let x = [1, 2, 3]; x[1:]`,
+		`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)`,
+		`let total = 0; for (let i = 0; i < 5; let i = i + 1) { let total = total + i; } total`,
+		`map([1, 2, 3], fn(x) { x * x })`,
+		`let a = 1; return a + 1; 99`,
+		`1 + "a"`,
+	}
+	for _, input := range inputs {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		ran := New(bytecode)
+		runErr := ran.Run()
+
+		stepped := New(bytecode)
+		var stepErr error
+		for done := false; !done && stepErr == nil; {
+			done, stepErr = stepped.Step()
+		}
+
+		if fmt.Sprint(runErr) != fmt.Sprint(stepErr) {
+			t.Errorf("%s: errors differ. run=%v, step=%v", input, runErr, stepErr)
+			continue
+		}
+		if ran.sp != stepped.sp || ran.lastIP != stepped.lastIP {
+			t.Errorf("%s: state differs. run sp=%d ip=%d, step sp=%d ip=%d",
+				input, ran.sp, ran.lastIP, stepped.sp, stepped.lastIP)
+		}
+		if runErr == nil && ran.LastPoppedStackElem().Inspect() != stepped.LastPoppedStackElem().Inspect() {
+			t.Errorf("%s: results differ. run=%s, step=%s",
+				input, ran.LastPoppedStackElem().Inspect(), stepped.LastPoppedStackElem().Inspect())
+		}
+		for i := range 4 {
+			if ran.globals[i] == nil || ran.globals[i].Type() == object.ClosureObj {
+				continue
+			}
+			if ran.globals[i].Inspect() != stepped.globals[i].Inspect() {
+				t.Errorf("%s: globals[%d] differ. run=%s, step=%s",
+					input, i, ran.globals[i].Inspect(), stepped.globals[i].Inspect())
+			}
+		}
+		ran.Release()
+		stepped.Release()
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	tests := []struct {
+		input    string
+		offset   int
+		pauses   []code.Opcode
+		stacks   [][]int64 // 每次暂停时栈顶的整数元素
+		expected int
+	}{
+		{`1 + 2 * 3`, 10, []code.Opcode{code.OpAdd}, [][]int64{{1, 6}}, 7},
+		// 断点位置对每一帧都生效：主程序的 OpConstant 和函数体的 OpAdd 都位于 4
+		{`fn(a, b) { a + b }(1, 2)`, 4, []code.Opcode{code.OpConstant, code.OpAdd}, [][]int64{{}, {1, 2}}, 3},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := New(comp.Bytecode())
+		machine.SetBreakpoint(tt.offset)
+		for i, op := range tt.pauses {
+			err := machine.Run()
+			if !errors.Is(err, ErrBreakpoint) {
+				t.Fatalf("%s: expected ErrBreakpoint, got=%v", tt.input, err)
+			}
+			ins, ip := machine.Position()
+			if ip != tt.offset || code.Opcode(ins[ip]) != op {
+				t.Fatalf("%s: paused at wrong instruction: %s", tt.input, ins.InstructionAt(ip))
+			}
+			stack := machine.Stack()
+			top := stack[len(stack)-len(tt.stacks[i]):]
+			for j, want := range tt.stacks[i] {
+				if err := testIntegerObject(want, top[j]); err != nil {
+					t.Errorf("%s: stack at pause %d: %s", tt.input, i, err)
+				}
+			}
+		}
+		if err := machine.Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected token.Position
+		message  string
+	}{
+		{"1;\n  1 + true", token.Position{Line: 2, Column: 5}, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"-true", token.Position{Line: 1, Column: 1}, "unsupported type for negation: BOOLEAN"},
+		{"let f = fn() {\n  [1][\"a\"]\n};\nf()", token.Position{Line: 2, Column: 3}, "index operator not supported: ARRAY"},
+		{"let f = fn(x) { x };\n\nf(1, 2)", token.Position{Line: 3, Column: 1}, "wrong number of arguments: want=1, got=2"},
+		{"let f = fn() { [1][\"a\"] };\nlet g = fn() { [1][\"a\"] };\ng()", token.Position{Line: 2, Column: 16}, "index operator not supported: ARRAY"},
+	}
+	for _, tt := range tests {
+		// 常量去重不能让指令相同的函数共用另一个函数的源代码位置
+		for _, dedup := range []bool{false, true} {
+			comp := compiler.New()
+			if dedup {
+				comp.EnableConstantDedup()
+			}
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err := New(comp.Bytecode()).Run()
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("%q: expected RuntimeError, got=%T (%v)", tt.input, err, err)
+			}
+			if runtimeErr.Pos != tt.expected || runtimeErr.Err.Error() != tt.message {
+				t.Errorf("%q (dedup=%t): wrong error. want=[line %s] %s, got=%s", tt.input, dedup, tt.expected, tt.message, err)
+			}
+		}
+	}
+}
+
+func TestCallCounts(t *testing.T) {
+	input := `
+	let fibonacci = fn(x) {
+		if (x < 2) { return x; }
+		fibonacci(x - 1) + fibonacci(x - 2)
+	};
+	let double = fn(x) { x * 2 };
+	double(fibonacci(10));
+	`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	machine := New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if machine.CallCounts() != nil {
+		t.Errorf("call counts recorded without profiling")
+	}
+
+	machine = New(bytecode)
+	machine.EnableProfiling()
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	fibonacci := bytecode.Constants[findFunctionConstant(t, bytecode.Constants, 0)].(*object.CompiledFunction)
+	double := bytecode.Constants[findFunctionConstant(t, bytecode.Constants, 1)].(*object.CompiledFunction)
+	// fib(n) 的调用次数为 2*fib(n+1)-1，fib(11) = 89
+	if got := machine.CallCounts()[fibonacci]; got != 177 {
+		t.Errorf("wrong fibonacci call count. got=%d, want=177", got)
+	}
+	if got := machine.CallCounts()[double]; got != 1 {
+		t.Errorf("wrong double call count. got=%d, want=1", got)
+	}
+}
+
+// findFunctionConstant 返回常量池中第 n 个编译函数的位置
+func findFunctionConstant(t *testing.T, constants []object.Object, n int) int {
+	t.Helper()
+	for i, c := range constants {
+		if _, ok := c.(*object.CompiledFunction); ok {
+			if n == 0 {
+				return i
+			}
+			n--
+		}
+	}
+	t.Fatalf("function constant not found")
+	return -1
+}
+
+func TestPredefinedGlobals(t *testing.T) {
+	config := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for key, value := range map[string]object.Object{
+		"name":    &object.String{Value: "monkey"},
+		"retries": &object.Integer{Value: 3},
+	} {
+		k := &object.String{Value: key}
+		config.Pairs[k.HashKey()] = object.HashPair{Key: k, Value: value}
+	}
+
+	tests := []vmTestCase{
+		{`config["retries"] * 2`, 6},
+		{`config.name`, "monkey"},
+		{`let f = fn() { config.retries + 1 }; f()`, 4},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewWithPredefined(map[string]object.Object{"config": config})
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func TestPooledVMReuse(t *testing.T) {
+	inputs := []vmTestCase{
+		{`let a = [1, 2, 3]; let b = {"a": a}; len(b["a"])`, 3},
+		{`let x = 10; let f = fn(y) { x + y }; f(5)`, 15},
+		{`"mon" + "key"`, "monkey"},
+	}
+	for i := 0; i < 20; i++ {
+		tt := inputs[i%len(inputs)]
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		for j, obj := range vm.stack {
+			if obj != nil {
+				t.Fatalf("run %d: stack slot %d leaked from previous run: %s", i, j, obj.Inspect())
+			}
+		}
+		for j, obj := range vm.globals {
+			if obj != nil {
+				t.Fatalf("run %d: global %d leaked from previous run: %s", i, j, obj.Inspect())
+			}
+		}
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		vm.Release()
+	}
+}
+
+func TestReleaseKeepsExternalGlobals(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	comp := compiler.New()
+	err := comp.Compile(parse(`let a = 5;`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithGlobalsStore(comp.Bytecode(), globals)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	vm.Release()
+	if err := testIntegerObject(5, globals[0]); err != nil {
+		t.Errorf("external globals cleared by Release: %s", err)
+	}
+}
+
 // runVMTests 运行虚拟机测试
 func runVMTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+	runVMTestsWith(t, tests, nil)
+}
+
+// runVMTestsWith 与 runVMTests 相同，setup 不为 nil 时在执行前用它配置虚拟机
+func runVMTestsWith(t *testing.T, tests []vmTestCase, setup func(*VM)) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
@@ -604,12 +1528,18 @@ func runVMTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("compiler error: %s", err)
 		}
 		vm := New(comp.Bytecode())
+		if setup != nil {
+			setup(vm)
+		}
 		err = vm.Run()
 		if err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
 		stackElem := vm.LastPoppedStackElem()
 		testExpectedObject(t, tt.expected, stackElem)
+		if vm.sp != 0 {
+			t.Errorf("stack not balanced after %q. sp=%d", tt.input, vm.sp)
+		}
 	}
 }
 
@@ -622,6 +1552,11 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(exp, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(exp, actual)
 		if err != nil {
@@ -708,6 +1643,18 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+// testFloatObject 测试浮点数对象
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value, got %g want %g", result.Value, expected)
+	}
+	return nil
+}
+
 // testBooleanObject 测试布尔对象
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
@@ -731,3 +1678,44 @@ func testStringObject(expected string, actual object.Object) error {
 	}
 	return nil
 }
+
+func TestLoopHoistingKeepsResults(t *testing.T) {
+	inputs := []string{
+		`let n = 10; let i = 0; while (i < n * 2) { let i = i + 1; } i`,
+		`let f = fn(limit) { let i = 0; let steps = 0; while (i < limit - 1 && steps < 100) { let i = i + 1; let steps = steps + 1; } steps }; f(7)`,
+		`let a = [1, 2, 3]; let i = 0; while (i < len(a) * 2) { let i = i + 1; } i`,
+		`let a = [3]; let i = 0; while (i < a[0] + 0) { a[0] = 1; let i = i + 1; } i`,
+		`let i = 0; let j = 0; while (i < 2 + 1) { let k = 0; while (k < i * 2) { let k = k + 1; let j = j + 1; } let i = i + 1; } j`,
+		`let x = 1; while (x < 1 && 1 / 0 > 0) { let x = x + 1; } x`,
+		`let s = "a"; while (len(s) < 4) { let s = s + "a"; } s`,
+		`let h = {"__add__": fn(a, b) { puts("add"); 3 }}; let i = 0; while (i < h + h) { let i = i + 1; } i`,
+		`let h = {"__eq__": fn(a, b) { puts("eq"); false }}; let i = 0; while (i < 3 && !(h == h)) { let i = i + 1; } i`,
+		`while (puts("a") == 1 - "a") { }`,
+		`let i = 0; while (i < 2 && puts(i) != 0 && 1 * 2 > 0) { let i = i + 1; } i`,
+	}
+	stdout := object.Stdout
+	t.Cleanup(func() { object.Stdout = stdout })
+	for _, input := range inputs {
+		var results []string
+		for _, hoist := range []bool{false, true} {
+			var out bytes.Buffer
+			object.Stdout = &out
+			comp := compiler.New()
+			if hoist {
+				comp.EnableLoopHoisting()
+			}
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			machine := New(comp.Bytecode())
+			if err := machine.Run(); err != nil {
+				results = append(results, out.String()+err.Error())
+				continue
+			}
+			results = append(results, out.String()+machine.LastPoppedStackElem().Inspect())
+		}
+		if results[0] != results[1] {
+			t.Errorf("%s: hoisting changed the result. without=%s, with=%s", input, results[0], results[1])
+		}
+	}
+}